@@ -0,0 +1,559 @@
+package goblin
+
+import (
+	"bufio"
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Besides JSON, dumps can be written as CBOR (RFC 8949) or MessagePack,
+// which are much quicker to parse. Both encode the same values as the
+// JSON output: objects become maps with string keys (in sorted order),
+// lists become arrays, and numbers become integers when they are whole
+// and floats otherwise. Lengths are always definite, so a decoder never
+// has to look for a terminator.
+
+// An encoding for goblin's output.
+type Format int
+
+const (
+	JSON Format = iota
+	CBOR
+	MessagePack
+	Proto // see proto.go
+	Sexp  // see sexp.go
+)
+
+var formatNames = []string{
+	JSON:        "json",
+	CBOR:        "cbor",
+	MessagePack: "msgpack",
+	Proto:       "proto",
+	Sexp:        "sexp",
+}
+
+func (f Format) String() string {
+	if int(f) < len(formatNames) {
+		return formatNames[f]
+	}
+	return "Format(" + strconv.Itoa(int(f)) + ")"
+}
+
+// The Format called name: "json", "cbor", "msgpack", "proto" or
+// "sexp".
+func ParseFormat(name string) (Format, error) {
+	for f, n := range formatNames {
+		if n == name {
+			return Format(f), nil
+		}
+	}
+	return JSON, &Error{Type: "format_error", Reason: "unknown format " + strconv.Quote(name), Position: INVALID_POSITION}
+}
+
+type header int
+
+const (
+	stringHeader header = iota
+	arrayHeader
+	mapHeader
+)
+
+// The CBOR major types of each header.
+var cborMajor = []byte{
+	stringHeader: 3,
+	arrayHeader:  4,
+	mapHeader:    5,
+}
+
+// Write the header of a string, array or map of length n.
+func (s *stream) binaryHeader(h header, n uint64) {
+	if s.format == CBOR {
+		s.cborHead(cborMajor[h], n)
+		return
+	}
+
+	// MessagePack has a short form for small lengths, then 16 and 32
+	// bit ones (and 8 bit ones for strings).
+	switch {
+	case h == stringHeader && n < 32:
+		s.write(0xa0 | byte(n))
+	case h != stringHeader && n < 16:
+		s.write([]byte{arrayHeader: 0x90, mapHeader: 0x80}[h] | byte(n))
+	case h == stringHeader && n <= math.MaxUint8:
+		s.write(0xd9, byte(n))
+	case n <= math.MaxUint16:
+		s.write([]byte{0xda, 0xdc, 0xde}[h])
+		s.write(binary.BigEndian.AppendUint16(nil, uint16(n))...)
+	case n <= math.MaxUint32:
+		s.write([]byte{0xdb, 0xdd, 0xdf}[h])
+		s.write(binary.BigEndian.AppendUint32(nil, uint32(n))...)
+	default:
+		fail(INVALID_POSITION, "encode_error", fmt.Sprintf("too many elements for MessagePack (%d)", n))
+	}
+}
+
+// A CBOR data item head: the major type and an argument in the fewest
+// bytes that hold it.
+func (s *stream) cborHead(major byte, n uint64) {
+	m := major << 5
+	switch {
+	case n < 24:
+		s.write(m | byte(n))
+	case n <= math.MaxUint8:
+		s.write(m|24, byte(n))
+	case n <= math.MaxUint16:
+		s.write(m | 25)
+		s.write(binary.BigEndian.AppendUint16(nil, uint16(n))...)
+	case n <= math.MaxUint32:
+		s.write(m | 26)
+		s.write(binary.BigEndian.AppendUint32(nil, uint32(n))...)
+	default:
+		s.write(m | 27)
+		s.write(binary.BigEndian.AppendUint64(nil, n)...)
+	}
+}
+
+func (s *stream) binaryNull() {
+	if s.format == CBOR {
+		s.write(0xf6)
+	} else {
+		s.write(0xc0)
+	}
+}
+
+func (s *stream) binaryBool(b bool) {
+	var c byte
+	if b {
+		c = 1
+	}
+	if s.format == CBOR {
+		s.write(0xf4 + c)
+	} else {
+		s.write(0xc2 + c)
+	}
+}
+
+// Like encoding/json, replace each invalid byte with U+FFFD.
+func toValidUTF8(s string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+	var b bytes.Buffer
+	for _, r := range s {
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (s *stream) binaryString(str string) {
+	str = toValidUTF8(str)
+	s.binaryHeader(stringHeader, uint64(len(str)))
+	s.write([]byte(str)...)
+}
+
+func (s *stream) binaryUint(n uint64) {
+	if s.format == CBOR {
+		s.cborHead(0, n)
+		return
+	}
+	switch {
+	case n < 128:
+		s.write(byte(n))
+	case n <= math.MaxUint8:
+		s.write(0xcc, byte(n))
+	case n <= math.MaxUint16:
+		s.write(0xcd)
+		s.write(binary.BigEndian.AppendUint16(nil, uint16(n))...)
+	case n <= math.MaxUint32:
+		s.write(0xce)
+		s.write(binary.BigEndian.AppendUint32(nil, uint32(n))...)
+	default:
+		s.write(0xcf)
+		s.write(binary.BigEndian.AppendUint64(nil, n)...)
+	}
+}
+
+func (s *stream) binaryInt(n int64) {
+	if n >= 0 {
+		s.binaryUint(uint64(n))
+		return
+	}
+	if s.format == CBOR {
+		s.cborHead(1, uint64(-1-n))
+		return
+	}
+	switch {
+	case n >= -32:
+		s.write(byte(n))
+	case n >= math.MinInt8:
+		s.write(0xd0, byte(n))
+	case n >= math.MinInt16:
+		s.write(0xd1)
+		s.write(binary.BigEndian.AppendUint16(nil, uint16(n))...)
+	case n >= math.MinInt32:
+		s.write(0xd2)
+		s.write(binary.BigEndian.AppendUint32(nil, uint32(n))...)
+	default:
+		s.write(0xd3)
+		s.write(binary.BigEndian.AppendUint64(nil, uint64(n))...)
+	}
+}
+
+func (s *stream) binaryFloat(f float64) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		fail(INVALID_POSITION, "encode_error", "unsupported value: "+strconv.FormatFloat(f, 'g', -1, 64))
+	}
+	// JSON doesn't tell 1 and 1.0 apart, so neither do we.
+	if f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+		s.binaryInt(int64(f))
+		return
+	}
+	if s.format == CBOR {
+		s.write(0xfb)
+	} else {
+		s.write(0xcb)
+	}
+	s.write(binary.BigEndian.AppendUint64(nil, math.Float64bits(f))...)
+}
+
+var (
+	jsonMarshaler = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshaler = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// Write v as encoding/json would see it. Dumps are made of maps,
+// slices, strings, numbers and booleans, which are written directly;
+// anything else (structs, marshalers, byte slices) goes through
+// encoding/json first.
+func (s *stream) binaryValue(v interface{}) {
+	switch x := v.(type) {
+	case nil:
+		s.binaryNull()
+		return
+	case string:
+		s.binaryString(x)
+		return
+	case bool:
+		s.binaryBool(x)
+		return
+	case int:
+		s.binaryInt(int64(x))
+		return
+	case map[string]interface{}:
+		if x == nil {
+			s.binaryNull()
+		} else {
+			s.object(x, nil)
+		}
+		return
+	case json.Number:
+		s.binaryNumber(x)
+		return
+	}
+
+	rv := reflect.ValueOf(v)
+	t := rv.Type()
+	if t.Implements(jsonMarshaler) || t.Implements(textMarshaler) {
+		s.viaJSON(v)
+		return
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		s.binaryBool(rv.Bool())
+	case reflect.String:
+		s.binaryString(rv.String())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		s.binaryInt(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		s.binaryUint(rv.Uint())
+	case reflect.Float32:
+		// encoding/json prints the shortest decimal that is the same
+		// float32, which isn't always the same float64.
+		f, _ := strconv.ParseFloat(strconv.FormatFloat(rv.Float(), 'g', -1, 32), 64)
+		s.binaryFloat(f)
+	case reflect.Float64:
+		s.binaryFloat(rv.Float())
+	case reflect.Ptr:
+		if rv.IsNil() {
+			s.binaryNull()
+		} else {
+			s.viaJSON(v)
+		}
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && rv.IsNil() {
+			s.binaryNull()
+		} else if t.Elem().Kind() == reflect.Uint8 {
+			s.viaJSON(v)
+		} else {
+			s.array(rv.Len(), func(i int) {
+				s.binaryValue(rv.Index(i).Interface())
+			})
+		}
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String || t.Key().Implements(textMarshaler) {
+			s.viaJSON(v)
+		} else if rv.IsNil() {
+			s.binaryNull()
+		} else {
+			keys := make([]string, 0, rv.Len())
+			for _, k := range rv.MapKeys() {
+				keys = append(keys, k.String())
+			}
+			sort.Strings(keys)
+			s.binaryHeader(mapHeader, uint64(len(keys)))
+			for _, k := range keys {
+				s.binaryString(k)
+				s.binaryValue(rv.MapIndex(reflect.ValueOf(k).Convert(t.Key())).Interface())
+			}
+		}
+	default:
+		s.viaJSON(v)
+	}
+}
+
+// Marshal v to JSON and write the decoded result.
+func (s *stream) viaJSON(v interface{}) {
+	s.binaryValue(jsonValue(v))
+}
+
+// Marshal v to JSON and decode it again, keeping numbers as
+// json.Number.
+func jsonValue(v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		fail(INVALID_POSITION, "json_error", err.Error())
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var decoded interface{}
+	if err := dec.Decode(&decoded); err != nil {
+		fail(INVALID_POSITION, "json_error", err.Error())
+	}
+	return decoded
+}
+
+func (s *stream) binaryNumber(n json.Number) {
+	if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+		s.binaryInt(i)
+	} else if u, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+		s.binaryUint(u)
+	} else if f, err := n.Float64(); err == nil {
+		s.binaryFloat(f)
+	} else {
+		fail(INVALID_POSITION, "json_error", err.Error())
+	}
+}
+
+// Read one value in the given format from r, as encoding/json would
+// decode the JSON output into an interface{}: objects are
+// map[string]interface{}, arrays []interface{} and numbers float64.
+// Only what can be expressed in JSON is accepted, so byte strings,
+// extension types and non-string keys are errors. Input is read
+// ahead, so r shouldn't be shared with another reader.
+func Decode(r io.Reader, format Format) (v interface{}, err error) {
+	if format == JSON {
+		if err := json.NewDecoder(r).Decode(&v); err != nil {
+			return nil, &Error{Type: "json_error", Reason: err.Error(), Position: INVALID_POSITION}
+		}
+		return v, nil
+	}
+	if format == Proto {
+		return nil, &Error{Type: "format_error", Reason: "protobuf dumps can't be decoded without code generated from goblin.proto", Position: INVALID_POSITION}
+	}
+	defer catch(&err)
+	if format == Sexp {
+		return (&sexpReader{r: bufio.NewReader(r)}).value(), nil
+	}
+	d := &decoder{r: bufio.NewReader(r)}
+	if format == CBOR {
+		return d.cbor(), nil
+	}
+	return d.msgpack(), nil
+}
+
+type decoder struct {
+	r *bufio.Reader
+}
+
+func (d *decoder) failf(format string, args ...interface{}) {
+	fail(INVALID_POSITION, "decode_error", fmt.Sprintf(format, args...))
+}
+
+func (d *decoder) byte() byte {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		d.failf("%s", unexpectedEOF(err))
+	}
+	return b
+}
+
+func (d *decoder) bytes(n uint64) []byte {
+	// The length comes from the input, so don't trust it with an
+	// allocation up front.
+	if n > math.MaxInt64 {
+		d.failf("length %d is too large", n)
+	}
+	var b bytes.Buffer
+	if _, err := io.CopyN(&b, d.r, int64(n)); err != nil {
+		d.failf("%s", unexpectedEOF(err))
+	}
+	return b.Bytes()
+}
+
+func unexpectedEOF(err error) error {
+	if err == nil || err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (d *decoder) uint(size int) uint64 {
+	var n uint64
+	for _, b := range d.bytes(uint64(size)) {
+		n = n<<8 | uint64(b)
+	}
+	return n
+}
+
+func (d *decoder) array(n uint64, item func() interface{}) []interface{} {
+	list := []interface{}{}
+	for i := uint64(0); i < n; i++ {
+		list = append(list, item())
+	}
+	return list
+}
+
+func (d *decoder) object(n uint64, key func() interface{}, value func() interface{}) map[string]interface{} {
+	obj := map[string]interface{}{}
+	for i := uint64(0); i < n; i++ {
+		k, ok := key().(string)
+		if !ok {
+			d.failf("map key is not a string")
+		}
+		obj[k] = value()
+	}
+	return obj
+}
+
+func (d *decoder) cbor() interface{} {
+	b := d.byte()
+	major, info := b>>5, b&0x1f
+
+	if major == 7 {
+		switch info {
+		case 20, 21:
+			return info == 21
+		case 22, 23:
+			return nil
+		case 25:
+			return float64(halfToFloat(uint16(d.uint(2))))
+		case 26:
+			return float64(math.Float32frombits(uint32(d.uint(4))))
+		case 27:
+			return math.Float64frombits(d.uint(8))
+		}
+		d.failf("unsupported CBOR simple value %d", info)
+	}
+
+	var n uint64
+	switch {
+	case info < 24:
+		n = uint64(info)
+	case info < 28:
+		n = d.uint(1 << (info - 24))
+	case info == 31:
+		d.failf("indefinite-length CBOR items are not supported")
+	default:
+		d.failf("malformed CBOR head 0x%02x", b)
+	}
+
+	switch major {
+	case 0:
+		return float64(n)
+	case 1:
+		return -1 - float64(n)
+	case 3:
+		return string(d.bytes(n))
+	case 4:
+		return d.array(n, d.cbor)
+	case 5:
+		return d.object(n, d.cbor, d.cbor)
+	case 6:
+		// Tags carry no meaning in JSON.
+		return d.cbor()
+	}
+	d.failf("unsupported CBOR major type %d", major)
+	return nil
+}
+
+// Convert an IEEE 754 half-precision float.
+func halfToFloat(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := uint32(h>>10) & 0x1f
+	frac := uint32(h) & 0x3ff
+	switch exp {
+	case 0:
+		f := float32(frac) / (1 << 24)
+		if sign != 0 {
+			f = -f
+		}
+		return f
+	case 0x1f:
+		return math.Float32frombits(sign | 0xff<<23 | frac<<13)
+	}
+	return math.Float32frombits(sign | (exp+127-15)<<23 | frac<<13)
+}
+
+func (d *decoder) msgpack() interface{} {
+	b := d.byte()
+	switch {
+	case b < 0x80:
+		return float64(b)
+	case b < 0x90:
+		return d.object(uint64(b&0x0f), d.msgpack, d.msgpack)
+	case b < 0xa0:
+		return d.array(uint64(b&0x0f), d.msgpack)
+	case b < 0xc0:
+		return string(d.bytes(uint64(b & 0x1f)))
+	case b >= 0xe0:
+		return float64(int8(b))
+	}
+
+	switch b {
+	case 0xc0:
+		return nil
+	case 0xc2, 0xc3:
+		return b == 0xc3
+	case 0xca:
+		return float64(math.Float32frombits(uint32(d.uint(4))))
+	case 0xcb:
+		return math.Float64frombits(d.uint(8))
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return float64(d.uint(1 << (b - 0xcc)))
+	case 0xd0:
+		return float64(int8(d.uint(1)))
+	case 0xd1:
+		return float64(int16(d.uint(2)))
+	case 0xd2:
+		return float64(int32(d.uint(4)))
+	case 0xd3:
+		return float64(int64(d.uint(8)))
+	case 0xd9, 0xda, 0xdb:
+		return string(d.bytes(d.uint(1 << (b - 0xd9))))
+	case 0xdc, 0xdd:
+		return d.array(d.uint(2<<(b-0xdc)), d.msgpack)
+	case 0xde, 0xdf:
+		return d.object(d.uint(2<<(b-0xde)), d.msgpack, d.msgpack)
+	}
+	d.failf("unsupported MessagePack type 0x%02x", b)
+	return nil
+}
@@ -6,8 +6,8 @@ import (
 	"github.com/GaloisInc/goblin"
 	"go/ast"
 	"go/parser"
+	"go/printer"
 	"go/token"
-	"log"
 	"os"
 )
 
@@ -15,6 +15,109 @@ import (
 // (leaning on -ldflags -X).
 var version string = "unspecified"
 
+var shouldPanic bool = false
+
+// The encoding of everything goblin reads and writes, except errors.
+var format = goblin.JSON
+
+// Report an error as JSON on stderr and exit.
+func Perish(pos token.Position, typ string, reason string) {
+	if shouldPanic {
+		panic(pos.String() + ": " + reason)
+	} else {
+		res, _ := json.Marshal(map[string]interface{}{
+			"error": map[string]interface{}{
+				"type":     typ,
+				"info":     reason,
+				"position": goblin.DumpPosition(pos),
+			},
+		})
+		os.Stderr.Write(res)
+	}
+	os.Exit(1)
+}
+
+// Perish with an error returned by the goblin library.
+func perishWith(err error) {
+	if e, ok := err.(*goblin.Error); ok {
+		Perish(e.Position, e.Type, e.Reason)
+	}
+	Perish(goblin.INVALID_POSITION, "internal_error", err.Error())
+}
+
+func output(val interface{}, err error) {
+	if err != nil {
+		perishWith(err)
+	}
+	if err := goblin.Encode(os.Stdout, val, format); err != nil {
+		perishWith(err)
+	}
+}
+
+// Read and decode a dump (- for stdin).
+func readDump(path string) interface{} {
+	r := os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			Perish(goblin.TOPLEVEL_POSITION, "path_error", err.Error())
+		}
+		defer f.Close()
+		r = f
+	}
+	v, err := goblin.Decode(r, format)
+	if err != nil {
+		perishWith(err)
+	}
+	return v
+}
+
+// Print the Go source for a dumped file or expression.
+func printSource(path string, fset *token.FileSet) {
+	dumped, _ := readDump(path).(map[string]interface{})
+	if dumped == nil {
+		Perish(goblin.TOPLEVEL_POSITION, "json_error", "expected a file or expression")
+	}
+
+	var node interface{}
+	var err error
+	if dumped["kind"] == "file" {
+		node, err = goblin.RebuildFile(dumped, fset)
+	} else {
+		node, err = goblin.RebuildExpr(dumped, fset)
+	}
+	if err != nil {
+		perishWith(err)
+	}
+
+	// Like gofmt, but without sorting the imports.
+	conf := printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+	if err := conf.Fprint(os.Stdout, fset, node); err != nil {
+		Perish(goblin.INVALID_POSITION, "print_error", err.Error())
+	}
+	if dumped["kind"] != "file" {
+		os.Stdout.Write([]byte("\n"))
+	}
+}
+
+// Check a dump against the schema, listing every violation as a
+// diagnostic. Exits with status 1 if there are any.
+func validate(path string) {
+	errs := goblin.Validate(readDump(path))
+	diagnostics := make([]map[string]interface{}, len(errs))
+	for i, e := range errs {
+		diagnostics[i] = goblin.DumpError(e)
+	}
+	output(map[string]interface{}{
+		"schema-version": goblin.SchemaVersion,
+		"valid":          len(errs) == 0,
+		"diagnostics":    diagnostics,
+	}, nil)
+	if len(errs) > 0 {
+		os.Exit(1)
+	}
+}
+
 func main() {
 	versionFlag := flag.Bool("v", false, "display goblin version")
 	builtinDumpFlag := flag.Bool("builtin-dump", false, "use go/ast to dump the file, not JSON")
@@ -23,34 +126,55 @@ func main() {
 	stmtFlag := flag.String("stmt", "", "statement to parse")
 	exprFlag := flag.String("expr", "", "expression to parse")
 	fullFlag := flag.Bool("f", false, "parse and typecheck all imports (with file option)")
+	diagnosticsFlag := flag.Bool("diagnostics", false, "report all syntax and type errors as diagnostics and dump anyway (with f option)")
+	desugarFlag := flag.Bool("desugar", false, "make implicit field promotions, dereferences and address-of operations in selectors explicit (with f option)")
+	monomorphizeFlag := flag.Bool("monomorphize", false, "add a specialized copy of each generic function and type of the main package for each of its instantiations (with f option)")
+	compactFlag := flag.Bool("compact-positions", false, "dump positions as packed integers, with a table of the files they are in (with file or f option)")
+	recoverFlag := flag.Bool("recover", false, "report all syntax errors as diagnostics and dump the partial AST (with file option)")
+	printFlag := flag.String("print", "", "rebuild Go source from a dumped file or expression (- for stdin)")
+	validateFlag := flag.String("validate", "", "check a dump against the output schema (- for stdin)")
+	schemaFlag := flag.Bool("schema", false, "print the JSON Schema of the output format (or goblin.proto, with format proto)")
+	formatFlag := flag.String("format", "json", "encoding of the output (and of the input of print and validate): json, cbor, msgpack, proto or sexp")
 
 	flag.Parse()
 	fset := token.NewFileSet() // positions are relative to fset
 
 	if *panicFlag {
-		goblin.ShouldPanic = true
+		shouldPanic = true
+	}
+	if f, err := goblin.ParseFormat(*formatFlag); err != nil {
+		perishWith(err)
+	} else {
+		format = f
 	}
 
 	if *versionFlag {
 		println(version)
 		return
+	} else if *schemaFlag && format == goblin.Proto {
+		os.Stdout.Write(goblin.ProtoSchema())
+	} else if *schemaFlag {
+		os.Stdout.Write(goblin.Schema())
+	} else if *validateFlag != "" {
+		validate(*validateFlag)
+	} else if *printFlag != "" {
+		printSource(*printFlag, fset)
 	} else if *fileFlag != "" {
 		// If full, use Load
 		if *fullFlag {
-			o := goblin.Load(*fileFlag)
-			str, err := json.Marshal(o)
-			if err != nil {
-				log.Fatal(err)
+			// Streamed, since the whole program can be large.
+			conf := goblin.Config{Diagnostics: *diagnosticsFlag, Format: format, Desugar: *desugarFlag, Monomorphize: *monomorphizeFlag, CompactPositions: *compactFlag}
+			if err := conf.LoadTo(os.Stdout, *fileFlag); err != nil {
+				perishWith(err)
 			}
-			os.Stdout.Write(str)
 		} else {
 			file, err := os.Open(*fileFlag)
 			if err != nil {
-				goblin.Perish(goblin.TOPLEVEL_POSITION, "path_error", err.Error())
+				Perish(goblin.TOPLEVEL_POSITION, "path_error", err.Error())
 			}
 			info, err := file.Stat()
 			if err != nil {
-				goblin.Perish(goblin.TOPLEVEL_POSITION, "path_error", err.Error())
+				Perish(goblin.TOPLEVEL_POSITION, "path_error", err.Error())
 			}
 
 			size := info.Size()
@@ -58,24 +182,55 @@ func main() {
 
 			fset.AddFile(*fileFlag, -1, int(size))
 
-			f, err := parser.ParseFile(fset, *fileFlag, nil, parser.ParseComments)
-			if err != nil {
-				goblin.Perish(goblin.INVALID_POSITION, "positionless_syntax_error", err.Error())
+			mode := parser.ParseComments
+			if *recoverFlag {
+				mode |= parser.AllErrors
+			}
+			f, err := parser.ParseFile(fset, *fileFlag, nil, mode)
+			if err != nil && (!*recoverFlag || f == nil) {
+				Perish(goblin.INVALID_POSITION, "positionless_syntax_error", err.Error())
 			}
 
 			if *builtinDumpFlag {
 				ast.Print(fset, f)
+			} else if *recoverFlag {
+				d := goblin.NewDumper(fset, nil)
+				d.Recover = true
+				d.CompactPositions = *compactFlag
+				dumped, derr := d.DumpFile(f, *fileFlag)
+				if derr == nil {
+					diagnostics := []map[string]interface{}{}
+					if err != nil {
+						for _, e := range goblin.SyntaxErrors(err) {
+							diagnostics = append(diagnostics, goblin.DumpError(e))
+						}
+					}
+					dumped["diagnostics"] = diagnostics
+				}
+				output(dumped, derr)
 			} else {
-				val, _ := json.Marshal(goblin.DumpFile(f, *fileFlag, fset, nil))
-				os.Stdout.Write(val)
+				d := goblin.NewDumper(fset, nil)
+				d.Format = format
+				d.CompactPositions = *compactFlag
+				if err := d.EncodeFile(os.Stdout, f, *fileFlag); err != nil {
+					perishWith(err)
+				}
 			}
 		}
 	} else if *exprFlag != "" {
-		val, _ := json.Marshal(goblin.TestExpr(*exprFlag))
-		os.Stdout.Write(val)
+		e, err := parser.ParseExpr(*exprFlag)
+		if err != nil {
+			Perish(goblin.INVALID_POSITION, "positionless_syntax_error", err.Error())
+		}
+		output(goblin.NewDumper(fset, nil).DumpExpr(e))
 	} else if *stmtFlag != "" {
-		val := goblin.TestStmt(*stmtFlag)
-		os.Stdout.Write(val)
+		// Statements have to be wrapped in a dummy function.
+		src := "package p; func blah(foo int, bar float64) string { " + *stmtFlag + "}"
+		f, err := parser.ParseFile(fset, "stdin", src, 0)
+		if err != nil {
+			Perish(goblin.INVALID_POSITION, "positionless_syntax_error", err.Error())
+		}
+		output(goblin.NewDumper(fset, nil).DumpFile(f, *stmtFlag))
 	} else {
 		flag.PrintDefaults()
 	}
@@ -0,0 +1,116 @@
+package goblin
+
+import (
+	"go/ast"
+	"sort"
+	"strings"
+)
+
+// Comments are attached to the nodes they belong to, as ast.CommentMap
+// sees it: statements, declarations, specs, fields and files have the
+// comment groups that go with them as "leading-comments" (those that
+// end before the node starts) and "trailing-comments" (the rest: those
+// on the line the node ends, or inside it where no nested statement,
+// declaration, spec or field takes them). A comment group that
+// ast.CommentMap gives to some other node, such as an expression, goes
+// to the nearest of these around it instead. Each comment has its
+// position and whether it is a "line" (//) or "block" (/* */) comment.
+//
+// Comments are only attached when a whole file is dumped, and only if
+// it was parsed with parser.ParseComments.
+
+// A Dumper for the declarations of f, with its comment map.
+func (d *Dumper) forFile(f *ast.File) *Dumper {
+	if len(f.Comments) == 0 {
+		return d
+	}
+	cmap := ast.NewCommentMap(d.Fset, f, f.Comments)
+
+	fd := *d
+	fd.comments = ast.CommentMap{}
+	var stack []ast.Node
+	ast.Inspect(f, func(n ast.Node) bool {
+		if n == nil {
+			stack = stack[:len(stack)-1]
+			return true
+		}
+		stack = append(stack, n)
+		if groups := cmap[n]; len(groups) > 0 {
+			target := stack[0]
+			for i := len(stack) - 1; i >= 0; i-- {
+				var parent ast.Node
+				if i > 0 {
+					parent = stack[i-1]
+				}
+				if attachesComments(stack[i], parent) {
+					target = stack[i]
+					break
+				}
+			}
+			fd.comments[target] = append(fd.comments[target], groups...)
+		}
+		return true
+	})
+	for _, groups := range fd.comments {
+		sort.Slice(groups, func(i, j int) bool { return groups[i].Pos() < groups[j].Pos() })
+	}
+	return &fd
+}
+
+// Whether n (a child of parent) is dumped as a node comments can be
+// attached to. Blocks are, except for the bodies of functions and
+// statements, which are dumped as lists of statements.
+func attachesComments(n, parent ast.Node) bool {
+	switch n := n.(type) {
+	case *ast.BlockStmt:
+		switch p := parent.(type) {
+		case *ast.BlockStmt, *ast.CaseClause, *ast.CommClause, *ast.LabeledStmt:
+			return true
+		case *ast.IfStmt:
+			return p.Else == n
+		}
+		return false
+	case ast.Stmt, ast.Decl, ast.Spec, *ast.Field, *ast.File:
+		return true
+	}
+	return false
+}
+
+// Add the comment groups attached to n (see forFile) to its dump.
+func (d *Dumper) withComments(res map[string]interface{}, n ast.Node) map[string]interface{} {
+	var leading, trailing [][]map[string]interface{}
+	for _, g := range d.comments[n] {
+		if g.End() <= n.Pos() {
+			leading = append(leading, d.dumpComments(g))
+		} else {
+			trailing = append(trailing, d.dumpComments(g))
+		}
+	}
+	if leading != nil {
+		res["leading-comments"] = leading
+	}
+	if trailing != nil {
+		res["trailing-comments"] = trailing
+	}
+	return res
+}
+
+// The comments of a group, with their positions (unlike
+// dumpCommentGroup).
+func (d *Dumper) dumpComments(g *ast.CommentGroup) []map[string]interface{} {
+	comments := make([]map[string]interface{}, len(g.List))
+	for i, c := range g.List {
+		typ := "line"
+		if strings.HasPrefix(c.Text, "/*") {
+			typ = "block"
+		}
+		comments[i] = map[string]interface{}{
+			"kind":     "comment",
+			"type":     typ,
+			"text":     c.Text,
+			"position": d.dumpPos(c.Pos()),
+			"end":      d.dumpPos(c.End()),
+		}
+	}
+	return comments
+}
@@ -0,0 +1,111 @@
+package goblin
+
+import (
+	"go/ast"
+	"go/types"
+)
+
+// With Dumper.Desugar, selectors are dumped the way the compiler sees
+// them: x.f, where f is promoted through embedded fields and pointers
+// are dereferenced and addresses taken implicitly, becomes the chain of
+// selectors (*(x.E)).f, and calling a method with a pointer receiver
+// on an addressable value x.M becomes (&x).M. The nodes that aren't in
+// the source are marked "synthesized" and have the position and end of
+// the selector they came from, so that every node of the chain can be
+// traced back to it.
+//
+// Method expressions (T.M) and qualified identifiers are left as they
+// are.
+
+// Dump a selector (whose target has been dumped already) as its
+// explicit chain, or return nil if it doesn't select a field or method
+// of a value.
+func (d *Dumper) desugarSelector(n *ast.SelectorExpr, target map[string]interface{}, tp map[string]interface{}) map[string]interface{} {
+	if d.Info == nil {
+		return nil
+	}
+	sel := d.Info.Selections[n]
+	if sel == nil || sel.Kind() == types.MethodExpr {
+		return nil
+	}
+
+	position := d.dumpPos(n.Pos())
+	end := d.dumpPos(n.End())
+	deref := func(t types.Type) types.Type {
+		p, ok := t.Underlying().(*types.Pointer)
+		if !ok {
+			return t
+		}
+		target = map[string]interface{}{
+			"kind":        "expression",
+			"type":        "star",
+			"target":      target,
+			"synthesized": true,
+			"position":    position,
+			"end":         end,
+			"go-type":     d.dumpGoType(p.Elem()),
+		}
+		return p.Elem()
+	}
+	selector := func(index int, recv types.Type, field map[string]interface{}, kind types.SelectionKind) map[string]interface{} {
+		return map[string]interface{}{
+			"kind":   "expression",
+			"type":   "selector",
+			"target": target,
+			"field":  field,
+			"selection": map[string]interface{}{
+				"selection-kind": SelectionKindStrings[kind],
+				"index":          []int{index},
+				"indirect":       false,
+				"receiver":       d.dumpGoType(recv),
+			},
+			"position": position,
+			"end":      end,
+		}
+	}
+
+	// Embedded fields on the way.
+	index := sel.Index()
+	t := sel.Recv()
+	for _, i := range index[:len(index)-1] {
+		t = deref(t)
+		field := t.Underlying().(*types.Struct).Field(i)
+		ident := map[string]interface{}{
+			"kind":       "ident",
+			"ident-kind": "Var",
+			"value":      field.Name(),
+			"refers-to":  objectID(field, d.Fset, d.Types.fieldOwner),
+			"position":   d.dumpPos(n.Sel.Pos()),
+			"end":        d.dumpPos(n.Sel.End()),
+		}
+		target = selector(i, t, ident, types.FieldVal)
+		target["synthesized"] = true
+		target["go-type"] = d.dumpGoType(field.Type())
+		t = field.Type()
+	}
+
+	// Then the field or method itself.
+	if m, ok := sel.Obj().(*types.Func); ok {
+		_, ptrRecv := m.Type().(*types.Signature).Recv().Type().(*types.Pointer)
+		_, isPtr := t.Underlying().(*types.Pointer)
+		if ptrRecv && !isPtr {
+			pointer := types.NewPointer(t)
+			target = map[string]interface{}{
+				"kind":        "expression",
+				"type":        "unary",
+				"operator":    "&",
+				"target":      target,
+				"synthesized": true,
+				"position":    position,
+				"end":         end,
+				"go-type":     d.dumpGoType(pointer),
+			}
+			t = pointer
+		} else if !ptrRecv && !types.IsInterface(t) {
+			t = deref(t)
+		}
+	} else {
+		t = deref(t)
+	}
+	return withType(selector(index[len(index)-1], t, d.dumpIdent(n.Sel), sel.Kind()), tp)
+}
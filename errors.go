@@ -0,0 +1,84 @@
+package goblin
+
+import (
+	"go/scanner"
+	"go/token"
+	"go/types"
+)
+
+// An Error is returned when goblin can't make sense of its input:
+// unsupported or malformed syntax, or a package that fails to load or
+// typecheck. Type is a short machine-readable classification such as
+// "unexpected_node" or "type_error".
+type Error struct {
+	Type     string
+	Reason   string
+	Position token.Position
+}
+
+func (e *Error) Error() string {
+	if !e.Position.IsValid() {
+		return e.Type + ": " + e.Reason
+	}
+	return e.Position.String() + ": " + e.Type + ": " + e.Reason
+}
+
+// Bail out of a dump. The panic is turned back into an *Error by catch
+// in the exported entry points.
+func fail(pos token.Position, typ string, reason string) {
+	panic(&Error{Type: typ, Reason: reason, Position: pos})
+}
+
+// Recover from a panic raised by fail, storing the *Error in err. Any
+// other panic is a bug in goblin and is propagated.
+func catch(err *error) {
+	if r := recover(); r != nil {
+		e, ok := r.(*Error)
+		if !ok {
+			panic(r)
+		}
+		*err = e
+	}
+}
+
+// Convert errors from the parser and typechecker into *Errors, keeping
+// their positions.
+func convertError(err error, fset *token.FileSet) *Error {
+	switch e := err.(type) {
+	case *Error:
+		return e
+	case scanner.ErrorList:
+		if len(e) > 0 {
+			return convertError(e[0], fset)
+		}
+	case *scanner.Error:
+		return &Error{Type: "syntax_error", Reason: e.Msg, Position: e.Pos}
+	case types.Error:
+		return &Error{Type: "type_error", Reason: e.Msg, Position: fset.Position(e.Pos)}
+	}
+	return &Error{Type: "internal_error", Reason: err.Error(), Position: INVALID_POSITION}
+}
+
+// Every error reported by the parser, with its position.
+func SyntaxErrors(err error) []*Error {
+	list, ok := err.(scanner.ErrorList)
+	if !ok {
+		return []*Error{convertError(err, nil)}
+	}
+	errs := make([]*Error, len(list))
+	for i, e := range list {
+		errs[i] = convertError(e, nil)
+	}
+	return errs
+}
+
+// Errors are dumped in the same shape as the goblin command reports
+// them, plus a kind so they can be told apart from AST nodes.
+func DumpError(e *Error) map[string]interface{} {
+	return map[string]interface{}{
+		"kind":     "diagnostic",
+		"type":     e.Type,
+		"info":     e.Reason,
+		"position": DumpPosition(e.Position),
+	}
+}
@@ -0,0 +1,24 @@
+// Package main counts things.
+package main
+
+import (
+	"fmt" // for Println
+)
+
+// A Counter counts.
+type Counter struct {
+	// The count so far.
+	n int // never negative
+}
+
+// Inc adds one.
+func (c *Counter) Inc() {
+	c.n++ /* no overflow check */
+}
+
+func main() {
+	// Start from zero.
+	var c Counter
+	c.Inc()
+	fmt.Println(c.n) // 1
+}
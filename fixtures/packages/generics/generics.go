@@ -0,0 +1,27 @@
+package main
+
+type Number interface {
+	~int | ~int64 | float64
+}
+
+type Pair[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+func (p *Pair[K, V]) Key() K {
+	return p.key
+}
+
+func Sum[T Number](xs []T) T {
+	var total T
+	for _, x := range xs {
+		total += x
+	}
+	return total
+}
+
+func main() {
+	p := Pair[string, int]{"a", 1}
+	println(p.Key(), Sum[int]([]int{1, 2, 3}))
+}
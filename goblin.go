@@ -9,17 +9,27 @@ import (
 	"go/token"
 	"go/types"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
+	"sync"
 	// "strconv"
 )
 
+// Make Perish, and so the deprecated free functions, panic instead of
+// exiting.
+//
+// Deprecated: the Dumper methods return errors as *Errors instead.
 var ShouldPanic bool = false
+
 var TOPLEVEL_POSITION token.Position = token.Position{Filename: "toplevel", Offset: -1, Line: -1, Column: -1}
 var INVALID_POSITION token.Position = token.Position{Filename: "unspecified", Offset: -1, Line: -1, Column: -1}
 
-var tinfo *types.Info = nil
-
+// Report an error as JSON on stderr and exit (or panic, if ShouldPanic
+// is set).
+//
+// Deprecated: the Dumper methods return errors as *Errors instead;
+// report them the way the goblin command does.
 func Perish(pos token.Position, typ string, reason string) {
 	if ShouldPanic {
 		panic(pos.String() + ": " + reason)
@@ -36,6 +46,52 @@ func Perish(pos token.Position, typ string, reason string) {
 	os.Exit(1)
 }
 
+// A Dumper turns Go syntax trees into serializable objects. AST nodes
+// are decorated with type information from Info if it's not nil.
+//
+// A Dumper keeps no state of its own besides the TypeTable, which is
+// safe for concurrent use, so distinct Dumpers may run in parallel
+// (e.g. one per package) while sharing a TypeTable.
+type Dumper struct {
+	Fset  *token.FileSet
+	Info  *types.Info
+	Types *TypeTable
+
+	// Dump the BadExpr, BadStmt and BadDecl nodes of a partial AST
+	// (as produced by the parser on syntax errors) as "bad" nodes
+	// instead of failing.
+	Recover bool
+
+	// The encoding EncodeFile and EncodePackage write (JSON by
+	// default).
+	Format Format
+
+	// Make the field promotions, dereferences and address-of
+	// operations implicit in selectors explicit (see desugar.go).
+	// Needs type information.
+	Desugar bool
+
+	// Dump positions as packed integers, with a table of the files
+	// they are in, instead of objects (see positions.go).
+	CompactPositions bool
+
+	// The specialized copies of generic declarations, as made by
+	// Load with Config.Monomorphize.
+	specializations map[ast.Node]*specialization
+
+	// The comments of the file being dumped, by the node they're
+	// attached to (see comments.go).
+	comments ast.CommentMap
+}
+
+func NewDumper(fset *token.FileSet, info *types.Info) *Dumper {
+	return &Dumper{
+		Fset:  fset,
+		Info:  info,
+		Types: NewTypeTable(),
+	}
+}
+
 func DumpPosition(p token.Position) map[string]interface{} {
 	return map[string]interface{}{
 		// We need these float64 conversions or our test cases will fail.
@@ -80,31 +136,269 @@ func ConvertChanDir(dir types.ChanDir) ast.ChanDir {
 	}
 }
 
-func getGoType(e ast.Expr) map[string]interface{} {
-	if tinfo == nil {
+func (d *Dumper) getGoType(e ast.Expr) map[string]interface{} {
+	if d.Info == nil {
 		return nil
 	}
-	return DumpGoType(tinfo.Types[e].Type)
+	return d.dumpGoType(d.Info.Types[e].Type)
 }
 
-// Impose a depth limit for now until we can detect and handle
-// recursive types properly.
-const TYPE_DEPTH_LIMIT int = 100
+// Named types are dumped only once, into a TypeTable (keyed by
+// NamedTypeID). Every occurrence of a named type inside a go-type is
+// a reference into the table, which is what lets us handle recursive
+// types.
+type TypeTable struct {
+	mu      sync.Mutex
+	entries map[string]interface{}
+
+	// Also kept here, since they are shared the same way: the types
+	// fields belong to, for ObjectID, and the packages of package
+	// scopes, for scope IDs.
+	fields   map[*types.Package]map[*types.Var]*types.TypeName
+	packages map[*types.Scope]*types.Package
+}
 
-func dumpGoTypeAux(tp types.Type, d int) map[string]interface{} {
-	if tp == nil {
+func NewTypeTable() *TypeTable {
+	return &TypeTable{
+		entries:  map[string]interface{}{},
+		fields:   map[*types.Package]map[*types.Var]*types.TypeName{},
+		packages: map[*types.Scope]*types.Package{},
+	}
+}
+
+// Like fieldOwner, but only going through each package's types once.
+func (t *TypeTable) fieldOwner(v *types.Var) *types.TypeName {
+	if v.Pkg() == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	owners, ok := t.fields[v.Pkg()]
+	if !ok {
+		owners = fieldOwners(v.Pkg())
+		t.fields[v.Pkg()] = owners
+	}
+	return owners[v]
+}
+
+// Like scopePackage, but only going through each package scope once.
+func (t *TypeTable) scopePackage(s *types.Scope) *types.Package {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pkg, ok := t.packages[s]
+	if !ok {
+		pkg = scopePackage(s)
+		t.packages[s] = pkg
+	}
+	return pkg
+}
+
+// Claim the entry for id, reporting whether the caller should fill it
+// in. Reserving the entry before dumping the named type is what makes
+// recursive references to it terminate.
+func (t *TypeTable) reserve(id string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.entries[id]; ok {
+		return false
+	}
+	t.entries[id] = nil
+	return true
+}
+
+func (t *TypeTable) set(id string, entry map[string]interface{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entries[id] = entry
+}
+
+// The named types referenced by all go-types dumped so far.
+func (t *TypeTable) Dump() map[string]interface{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	res := make(map[string]interface{}, len(t.entries))
+	for id, entry := range t.entries {
+		res[id] = entry
+	}
+	return res
+}
+
+// A stable identifier for a named type: its package path and name,
+// followed by the type arguments of an instance. Types declared
+// inside a function are additionally qualified by the position of
+// their declaration, as in "main.T@main.go:12:7" (like ObjectID),
+// since their names need not be unique within the package.
+func NamedTypeID(t *types.Named, fset *token.FileSet) string {
+	id := types.TypeString(t, packageQualifier)
+	obj := t.Obj()
+	if obj.Pkg() != nil && obj.Parent() != nil && obj.Parent() != obj.Pkg().Scope() {
+		if pos := fset.Position(obj.Pos()); pos.IsValid() {
+			id = fmt.Sprintf("%s@%s:%d:%d", id, filepath.Base(pos.Filename), pos.Line, pos.Column)
+		}
+	}
+	return id
+}
+
+// A stable identifier for the object an identifier declares or refers
+// to. Package-level objects are identified by their package path and
+// name ("fmt.Println"), and fields and methods of package-level types
+// by the type's name as well ("net/http.Request.Method"). Predeclared
+// objects are just their name ("len"). Anything else (locals,
+// parameters, labels, ...) is qualified by the position of its
+// declaration, as in "main.x@main.go:12:2". Fields and methods of
+// instances of generic types are identified with their origin's.
+func ObjectID(obj types.Object, fset *token.FileSet) string {
+	return objectID(obj, fset, fieldOwner)
+}
+
+func objectID(obj types.Object, fset *token.FileSet, fieldOwner func(*types.Var) *types.TypeName) string {
+	var owner *types.TypeName
+	switch o := obj.(type) {
+	case *types.Var:
+		obj = o.Origin()
+		if o.IsField() {
+			owner = fieldOwner(o.Origin())
+		}
+	case *types.Func:
+		obj = o.Origin()
+		owner = methodOwner(o.Origin())
+	}
+
+	prefix := ""
+	if pkg := obj.Pkg(); pkg != nil {
+		prefix = packageQualifier(pkg) + "."
+		if obj.Parent() == pkg.Scope() {
+			return prefix + obj.Name()
+		}
+	} else if owner == nil {
+		return obj.Name()
+	}
+
+	if owner != nil {
+		return prefix + owner.Name() + "." + obj.Name()
+	}
+	pos := fset.Position(obj.Pos())
+	if !pos.IsValid() {
+		return prefix + obj.Name()
+	}
+	return fmt.Sprintf("%s%s@%s:%d:%d", prefix, obj.Name(), filepath.Base(pos.Filename), pos.Line, pos.Column)
+}
+
+// Reports whether a type is declared at package level (or is
+// predeclared), so that it can be named by its package and name.
+func isTopLevel(tn *types.TypeName) bool {
+	return tn.Pkg() == nil || tn.Parent() == tn.Pkg().Scope()
+}
+
+// The package-level type a method belongs to, if any.
+func methodOwner(f *types.Func) *types.TypeName {
+	recv := f.Type().(*types.Signature).Recv()
+	if recv == nil {
+		return nil
+	}
+	t := recv.Type()
+	if p, ok := t.(*types.Pointer); ok {
+		t = p.Elem()
+	}
+	if named, ok := t.(*types.Named); ok && isTopLevel(named.Obj()) {
+		return named.Obj()
+	}
+	return nil
+}
+
+// The package-level struct type a field belongs to, if any.
+func fieldOwner(v *types.Var) *types.TypeName {
+	if v.Pkg() == nil {
 		return nil
 	}
+	return fieldOwners(v.Pkg())[v]
+}
+
+// The fields of all of a package's (non-alias) package-level struct
+// types, mapped to their types.
+func fieldOwners(pkg *types.Package) map[*types.Var]*types.TypeName {
+	owners := map[*types.Var]*types.TypeName{}
+	scope := pkg.Scope()
+	for _, name := range scope.Names() {
+		tn, ok := scope.Lookup(name).(*types.TypeName)
+		if !ok || tn.IsAlias() {
+			continue
+		}
+		if st, ok := tn.Type().Underlying().(*types.Struct); ok {
+			for i := 0; i < st.NumFields(); i++ {
+				owners[st.Field(i)] = tn
+			}
+		}
+	}
+	return owners
+}
 
-	if d > TYPE_DEPTH_LIMIT {
-		panic("dumpGoTypeAux: depth limit exceeded")
+// Qualify names by package path, falling back to the package name for
+// packages checked without one (such as the main package in Load).
+func packageQualifier(pkg *types.Package) string {
+	if pkg.Path() == "" {
+		return pkg.Name()
+	}
+	return pkg.Path()
+}
+
+func (d *Dumper) dumpNamed(t *types.Named) map[string]interface{} {
+	obj := t.Obj()
+
+	// The package is nil for predeclared types such as error.
+	var pkg interface{} = nil
+	if obj.Pkg() != nil {
+		pkg = packageQualifier(obj.Pkg())
+	}
+
+	methods := make([]map[string]interface{}, t.NumMethods())
+	for i := 0; i < t.NumMethods(); i++ {
+		m := t.Method(i)
+		sig := m.Type().(*types.Signature)
+		_, ptr := sig.Recv().Type().(*types.Pointer)
+		methods[i] = map[string]interface{}{
+			"name":             m.Name(),
+			"exported":         m.Exported(),
+			"pointer-receiver": ptr,
+			"type":             d.dumpGoTypeAux(sig),
+		}
+	}
+
+	// Instances refer back to their generic origin.
+	var origin interface{} = nil
+	if t.Origin() != t {
+		origin = NamedTypeID(t.Origin(), d.Fset)
+	}
+
+	typeArgs := []map[string]interface{}{}
+	for i := 0; i < t.TypeArgs().Len(); i++ {
+		typeArgs = append(typeArgs, d.dumpGoTypeAux(t.TypeArgs().At(i)))
+	}
+
+	return map[string]interface{}{
+		"type":        "Named",
+		"id":          NamedTypeID(t, d.Fset),
+		"name":        obj.Name(),
+		"package":     pkg,
+		"exported":    obj.Exported(),
+		"underlying":  d.dumpGoTypeAux(t.Underlying()),
+		"methods":     methods,
+		"type-params": d.dumpTypeParamList(t.TypeParams()),
+		"origin":      origin,
+		"type-args":   typeArgs,
+	}
+}
+
+func (d *Dumper) dumpGoTypeAux(tp types.Type) map[string]interface{} {
+	if tp == nil {
+		return nil
 	}
 
 	switch t := tp.(type) {
 	case *types.Array:
 		return map[string]interface{}{
 			"type": "Array",
-			"elem": dumpGoTypeAux(t.Elem(), d+1),
+			"elem": d.dumpGoTypeAux(t.Elem()),
 			"len":  t.Len(),
 		}
 	case *types.Basic:
@@ -116,7 +410,7 @@ func dumpGoTypeAux(tp types.Type, d int) map[string]interface{} {
 		return map[string]interface{}{
 			"type":      "Chan",
 			"direction": DumpChanDir(ConvertChanDir(t.Dir())),
-			"elem":      dumpGoTypeAux(t.Elem(), d+1),
+			"elem":      d.dumpGoTypeAux(t.Elem()),
 		}
 	case *types.Interface:
 		// TODO: may need to call Complete() on t before doing this.
@@ -125,41 +419,52 @@ func dumpGoTypeAux(tp types.Type, d int) map[string]interface{} {
 			m := t.Method(i)
 			methods[i] = map[string]interface{}{
 				"name": m.Name(),
-				"type": dumpGoTypeAux(m.Type(), d+1),
+				"type": d.dumpGoTypeAux(m.Type()),
 			}
 		}
+		embeddeds := make([]map[string]interface{}, t.NumEmbeddeds())
+		for i := 0; i < t.NumEmbeddeds(); i++ {
+			embeddeds[i] = d.dumpGoTypeAux(t.EmbeddedType(i))
+		}
 		return map[string]interface{}{
-			"type":    "Interface",
-			"methods": methods,
+			"type":      "Interface",
+			"methods":   methods,
+			"embeddeds": embeddeds,
 		}
 	case *types.Map:
 		return map[string]interface{}{
 			"type": "Map",
-			"key":  dumpGoTypeAux(t.Key(), d+1),
-			"elem": dumpGoTypeAux(t.Elem(), d+1),
+			"key":  d.dumpGoTypeAux(t.Key()),
+			"elem": d.dumpGoTypeAux(t.Elem()),
 		}
 	case *types.Named:
+		id := NamedTypeID(t, d.Fset)
+		if d.Types.reserve(id) {
+			d.Types.set(id, d.dumpNamed(t))
+		}
 		return map[string]interface{}{
-			"type":       "Named",
-			"underlying": dumpGoTypeAux(t.Underlying(), d+1),
+			"type": "Named",
+			"id":   id,
 		}
 	case *types.Pointer:
 		return map[string]interface{}{
 			"type": "Pointer",
-			"elem": dumpGoTypeAux(t.Elem(), d+1),
+			"elem": d.dumpGoTypeAux(t.Elem()),
 		}
 	case *types.Signature:
 		return map[string]interface{}{
-			"type":     "Signature",
-			"params":   dumpGoTypeAux(t.Params(), d+1),
-			"recv":     DumpVar(t.Recv()),
-			"results":  dumpGoTypeAux(t.Results(), d+1),
-			"variadic": t.Variadic(),
+			"type":             "Signature",
+			"params":           d.dumpGoTypeAux(t.Params()),
+			"recv":             DumpVar(t.Recv()),
+			"results":          d.dumpGoTypeAux(t.Results()),
+			"variadic":         t.Variadic(),
+			"type-params":      d.dumpTypeParamList(t.TypeParams()),
+			"recv-type-params": d.dumpTypeParamList(t.RecvTypeParams()),
 		}
 	case *types.Slice:
 		return map[string]interface{}{
 			"type": "Slice",
-			"elem": dumpGoTypeAux(t.Elem(), d+1),
+			"elem": d.dumpGoTypeAux(t.Elem()),
 		}
 	case *types.Struct:
 		fields := make([]map[string]interface{}, t.NumFields())
@@ -167,7 +472,7 @@ func dumpGoTypeAux(tp types.Type, d int) map[string]interface{} {
 			f := t.Field(i)
 			fields[i] = map[string]interface{}{
 				"name": f.Name(),
-				"type": dumpGoTypeAux(f.Type(), d+1),
+				"type": d.dumpGoTypeAux(f.Type()),
 			}
 		}
 		return map[string]interface{}{
@@ -181,26 +486,69 @@ func dumpGoTypeAux(tp types.Type, d int) map[string]interface{} {
 			f := t.At(i)
 			fields[i] = map[string]interface{}{
 				"name": f.Name(),
-				"type": dumpGoTypeAux(f.Type(), d+1),
+				"type": d.dumpGoTypeAux(f.Type()),
 			}
 		}
 		return map[string]interface{}{
 			"type":   "Tuple",
 			"fields": fields,
 		}
+	case *types.TypeParam:
+		// The constraint is only dumped where the type parameter
+		// is declared (see dumpTypeParamList), since it may refer
+		// back to the type parameter itself.
+		return map[string]interface{}{
+			"type":  "TypeParam",
+			"name":  t.Obj().Name(),
+			"index": t.Index(),
+		}
+	case *types.Union:
+		terms := make([]map[string]interface{}, t.Len())
+		for i := 0; i < t.Len(); i++ {
+			term := t.Term(i)
+			terms[i] = map[string]interface{}{
+				"tilde": term.Tilde(),
+				"type":  d.dumpGoTypeAux(term.Type()),
+			}
+		}
+		return map[string]interface{}{
+			"type":  "Union",
+			"terms": terms,
+		}
+	case *types.Alias:
+		// Aliases (including the predeclared 'any') are
+		// transparent.
+		return d.dumpGoTypeAux(types.Unalias(t))
 	default:
-		fmt.Println("dumpGoTypeAux: unknown go type: ", tp)
-		panic("")
+		fail(INVALID_POSITION, "unknown_go_type", tp.String())
+		panic("unreachable")
 	}
 }
 
-func DumpGoType(tp types.Type) map[string]interface{} {
-	return dumpGoTypeAux(tp, 0)
+func (d *Dumper) dumpTypeParamList(l *types.TypeParamList) []map[string]interface{} {
+	if l == nil {
+		return nil
+	}
+	params := make([]map[string]interface{}, l.Len())
+	for i := 0; i < l.Len(); i++ {
+		p := l.At(i)
+		params[i] = map[string]interface{}{
+			"type":       "TypeParam",
+			"name":       p.Obj().Name(),
+			"index":      p.Index(),
+			"constraint": d.dumpGoTypeAux(p.Constraint()),
+		}
+	}
+	return params
 }
 
-func IdentKind(ident *ast.Ident) string {
-	if tinfo != nil {
-		o := tinfo.Uses[ident]
+func (d *Dumper) dumpGoType(tp types.Type) map[string]interface{} {
+	return d.dumpGoTypeAux(tp)
+}
+
+func (d *Dumper) identKind(ident *ast.Ident) string {
+	if d.Info != nil {
+		o := d.Info.Uses[ident]
 		switch o.(type) {
 		case *types.Builtin:
 			return "Builtin"
@@ -224,19 +572,20 @@ func IdentKind(ident *ast.Ident) string {
 	return "NoKind"
 }
 
-func DumpIdent(i *ast.Ident, fset *token.FileSet) map[string]interface{} {
+func (d *Dumper) dumpIdent(i *ast.Ident) map[string]interface{} {
 	if i == nil {
 		return nil
 	}
 
-	identKind := IdentKind(i)
+	identKind := d.identKind(i)
 
 	// This stuff only applies when type information isn't
 	// available. Otherwise literals are handled by AttemptConst.
 	asLiteral := map[string]interface{}{
 		"kind":     "literal",
 		"type":     "BOOL",
-		"position": DumpPosition(fset.Position(i.Pos())),
+		"position": d.dumpPos(i.Pos()),
+		"end":      d.dumpPos(i.End()),
 	}
 	switch i.Name {
 	case "true":
@@ -253,20 +602,71 @@ func DumpIdent(i *ast.Ident, fset *token.FileSet) map[string]interface{} {
 
 	}
 
-	return map[string]interface{}{
+	res := map[string]interface{}{
 		"kind":       "ident",
 		"ident-kind": identKind,
 		"value":      i.Name,
-		"position":   DumpPosition(fset.Position(i.Pos())),
+		"position":   d.dumpPos(i.Pos()),
+		"end":        d.dumpPos(i.End()),
 	}
+
+	// Link uses to declarations. (The name of an embedded field is
+	// both.)
+	if d.Info != nil {
+		if obj := d.Info.Defs[i]; obj != nil {
+			res["id"] = objectID(obj, d.Fset, d.Types.fieldOwner)
+		}
+		if obj := d.Info.Uses[i]; obj != nil {
+			res["refers-to"] = objectID(obj, d.Fset, d.Types.fieldOwner)
+		}
+	}
+	return d.withInstance(res, i)
 }
 
-func DumpArray(a *ast.ArrayType, fset *token.FileSet) map[string]interface{} {
+// Add the type arguments and instantiated type (or signature) of a use
+// of a generic type or function x, whether they are explicit or
+// inferred, if there's type information about it.
+func (d *Dumper) withInstance(res map[string]interface{}, x ast.Expr) map[string]interface{} {
+	if d.Info == nil {
+		return res
+	}
+	// The name of the generic type or function.
+	for {
+		if p, ok := x.(*ast.ParenExpr); ok {
+			x = p.X
+		} else if s, ok := x.(*ast.SelectorExpr); ok {
+			x = s.Sel
+		} else {
+			break
+		}
+	}
+	i, ok := x.(*ast.Ident)
+	if !ok {
+		return res
+	}
+	inst, ok := d.Info.Instances[i]
+	if !ok {
+		return res
+	}
+
+	args := make([]map[string]interface{}, inst.TypeArgs.Len())
+	for j := range args {
+		args[j] = d.dumpGoType(inst.TypeArgs.At(j))
+	}
+	res["instance"] = map[string]interface{}{
+		"type-args": args,
+		"type":      d.dumpGoType(inst.Type),
+	}
+	return res
+}
+
+func (d *Dumper) dumpArray(a *ast.ArrayType) map[string]interface{} {
 	return map[string]interface{}{
 		"kind":     "array",
-		"length":   DumpExpr(a.Len, fset),
-		"element":  DumpExprAsType(a.Elt, fset),
-		"position": DumpPosition(fset.Position(a.Pos())),
+		"length":   d.dumpExpr(a.Len),
+		"element":  d.dumpExprAsType(a.Elt),
+		"position": d.dumpPos(a.Pos()),
+		"end":      d.dumpPos(a.End()),
 	}
 }
 
@@ -277,32 +677,33 @@ func withType(o map[string]interface{}, tp map[string]interface{}) map[string]in
 	return o
 }
 
-func AttemptExprAsType(e ast.Expr, fset *token.FileSet) map[string]interface{} {
+func (d *Dumper) attemptExprAsType(e ast.Expr) map[string]interface{} {
 	if e == nil {
 		return nil
 	}
 
 	if n, ok := e.(*ast.ParenExpr); ok {
-		return AttemptExprAsType(n.X, fset)
+		return d.attemptExprAsType(n.X)
 	}
 
-	tp := getGoType(e)
+	tp := d.getGoType(e)
 
 	if n, ok := e.(*ast.Ident); ok {
 		return withType(map[string]interface{}{
 			"kind":     "type",
 			"type":     "identifier",
-			"value":    DumpIdent(n, fset),
-			"position": DumpPosition(fset.Position(e.Pos())),
+			"value":    d.dumpIdent(n),
+			"position": d.dumpPos(e.Pos()),
+			"end":      d.dumpPos(e.End()),
 		}, tp)
 	}
 
 	if n, ok := e.(*ast.SelectorExpr); ok {
-		lhs := DumpExpr(n.X, fset)
+		lhs := d.dumpExpr(n.X)
 
 		isType := false
-		if tinfo != nil {
-			isType = IdentKind(n.Sel) == "TypeName"
+		if d.Info != nil {
+			isType = d.identKind(n.Sel) == "TypeName"
 		} else {
 			isType = lhs["type"] == "identifier" && lhs["qualifier"] == nil
 		}
@@ -312,8 +713,9 @@ func AttemptExprAsType(e ast.Expr, fset *token.FileSet) map[string]interface{} {
 				"kind":      "type",
 				"type":      "identifier",
 				"qualifier": lhs["value"],
-				"value":     DumpIdent(n.Sel, fset),
-				"position":  DumpPosition(fset.Position(e.Pos())),
+				"value":     d.dumpIdent(n.Sel),
+				"position":  d.dumpPos(e.Pos()),
+				"end":       d.dumpPos(e.End()),
 			}, tp)
 		}
 	}
@@ -323,17 +725,19 @@ func AttemptExprAsType(e ast.Expr, fset *token.FileSet) map[string]interface{} {
 			return withType(map[string]interface{}{
 				"kind":     "type",
 				"type":     "slice",
-				"element":  DumpExprAsType(n.Elt, fset),
-				"position": DumpPosition(fset.Position(e.Pos())),
+				"element":  d.dumpExprAsType(n.Elt),
+				"position": d.dumpPos(e.Pos()),
+				"end":      d.dumpPos(e.End()),
 			}, tp)
 		}
 
 		return withType(map[string]interface{}{
 			"kind":     "type",
 			"type":     "array",
-			"element":  DumpExprAsType(n.Elt, fset),
-			"length":   DumpExpr(n.Len, fset),
-			"position": DumpPosition(fset.Position(e.Pos())),
+			"element":  d.dumpExprAsType(n.Elt),
+			"length":   d.dumpExpr(n.Len),
+			"position": d.dumpPos(e.Pos()),
+			"end":      d.dumpPos(e.End()),
 		}, tp)
 	}
 
@@ -341,8 +745,9 @@ func AttemptExprAsType(e ast.Expr, fset *token.FileSet) map[string]interface{} {
 		return withType(map[string]interface{}{
 			"kind":      "type",
 			"type":      "pointer",
-			"contained": DumpExprAsType(n.X, fset),
-			"position":  DumpPosition(fset.Position(e.Pos())),
+			"contained": d.dumpExprAsType(n.X),
+			"position":  d.dumpPos(e.Pos()),
+			"end":       d.dumpPos(e.End()),
 		}, tp)
 	}
 
@@ -351,8 +756,9 @@ func AttemptExprAsType(e ast.Expr, fset *token.FileSet) map[string]interface{} {
 			"kind":       "type",
 			"type":       "interface",
 			"incomplete": n.Incomplete,
-			"methods":    DumpFields(n.Methods, fset),
-			"position":   DumpPosition(fset.Position(e.Pos())),
+			"methods":    d.dumpFields(n.Methods),
+			"position":   d.dumpPos(e.Pos()),
+			"end":        d.dumpPos(e.End()),
 		}, tp)
 	}
 
@@ -360,9 +766,10 @@ func AttemptExprAsType(e ast.Expr, fset *token.FileSet) map[string]interface{} {
 		return withType(map[string]interface{}{
 			"kind":     "type",
 			"type":     "map",
-			"key":      DumpExprAsType(n.Key, fset),
-			"value":    DumpExprAsType(n.Value, fset),
-			"position": DumpPosition(fset.Position(e.Pos())),
+			"key":      d.dumpExprAsType(n.Key),
+			"value":    d.dumpExprAsType(n.Value),
+			"position": d.dumpPos(e.Pos()),
+			"end":      d.dumpPos(e.End()),
 		}, tp)
 	}
 
@@ -371,8 +778,9 @@ func AttemptExprAsType(e ast.Expr, fset *token.FileSet) map[string]interface{} {
 			"kind":      "type",
 			"type":      "chan",
 			"direction": DumpChanDir(n.Dir),
-			"value":     DumpExprAsType(n.Value, fset),
-			"position":  DumpPosition(fset.Position(e.Pos())),
+			"value":     d.dumpExprAsType(n.Value),
+			"position":  d.dumpPos(e.Pos()),
+			"end":       d.dumpPos(e.End()),
 		}, tp)
 	}
 
@@ -380,53 +788,145 @@ func AttemptExprAsType(e ast.Expr, fset *token.FileSet) map[string]interface{} {
 		return withType(map[string]interface{}{
 			"kind":     "type",
 			"type":     "struct",
-			"fields":   DumpFields(n.Fields, fset),
-			"position": DumpPosition(fset.Position(e.Pos())),
+			"fields":   d.dumpFields(n.Fields),
+			"position": d.dumpPos(e.Pos()),
+			"end":      d.dumpPos(e.End()),
 		}, tp)
 	}
 
 	if n, ok := e.(*ast.FuncType); ok {
-		variadic := ExtractVariadic(n.Params)
+		params, variadic := splitVariadic(n.Params)
 		return withType(map[string]interface{}{
 			"kind":     "type",
 			"type":     "function",
-			"params":   DumpFields(n.Params, fset),
-			"variadic": AttemptField(variadic, fset),
-			"results":  DumpFields(n.Results, fset),
-			"position": DumpPosition(fset.Position(e.Pos())),
+			"params":   d.dumpFields(params),
+			"variadic": d.attemptField(variadic),
+			"results":  d.dumpFields(n.Results),
+			"position": d.dumpPos(e.Pos()),
+			"end":      d.dumpPos(e.End()),
+		}, tp)
+	}
+
+	// Instantiations of generic functions (as opposed to types) are
+	// not types.
+	if n, ok := e.(*ast.IndexExpr); ok {
+		target := d.attemptExprAsType(n.X)
+		if target == nil {
+			return nil
+		}
+		return withType(d.withInstance(map[string]interface{}{
+			"kind":      "type",
+			"type":      "instantiation",
+			"target":    target,
+			"arguments": d.dumpExprsAsType([]ast.Expr{n.Index}),
+			"position":  d.dumpPos(e.Pos()),
+			"end":       d.dumpPos(e.End()),
+		}, n.X), tp)
+	}
+
+	if n, ok := e.(*ast.IndexListExpr); ok {
+		target := d.attemptExprAsType(n.X)
+		if target == nil {
+			return nil
+		}
+		return withType(d.withInstance(map[string]interface{}{
+			"kind":      "type",
+			"type":      "instantiation",
+			"target":    target,
+			"arguments": d.dumpExprsAsType(n.Indices),
+			"position":  d.dumpPos(e.Pos()),
+			"end":       d.dumpPos(e.End()),
+		}, n.X), tp)
+	}
+
+	// Unions and tilde terms only occur in constraint interfaces.
+	if n, ok := e.(*ast.BinaryExpr); ok && n.Op == token.OR {
+		return withType(map[string]interface{}{
+			"kind":     "type",
+			"type":     "union",
+			"terms":    d.dumpExprsAsType(UnionTerms(n)),
+			"position": d.dumpPos(e.Pos()),
+			"end":      d.dumpPos(e.End()),
+		}, tp)
+	}
+
+	if n, ok := e.(*ast.UnaryExpr); ok && n.Op == token.TILDE {
+		return withType(map[string]interface{}{
+			"kind":     "type",
+			"type":     "tilde",
+			"term":     d.dumpExprAsType(n.X),
+			"position": d.dumpPos(e.Pos()),
+			"end":      d.dumpPos(e.End()),
 		}, tp)
 	}
 
 	if n, ok := e.(*ast.Ellipsis); ok {
 		return withType(map[string]interface{}{
-			"kind":  "type",
-			"type":  "ellipsis",
-			"value": DumpExprAsType(n.Elt, fset),
+			"kind":     "type",
+			"type":     "ellipsis",
+			"value":    d.dumpExprAsType(n.Elt),
+			"position": d.dumpPos(n.Pos()),
+			"end":      d.dumpPos(n.End()),
 		}, tp)
 	}
 
 	return nil
 }
 
-func DumpExprAsType(e ast.Expr, fset *token.FileSet) map[string]interface{} {
-	result := AttemptExprAsType(e, fset)
+func (d *Dumper) dumpExprAsType(e ast.Expr) map[string]interface{} {
+	result := d.attemptExprAsType(e)
 
 	if result != nil {
 		return result
 	}
 
 	if e == nil {
-		panic("unexpected nil Expr")
+		fail(INVALID_POSITION, "internal_error", "unexpected nil Expr")
+	}
+
+	if n, ok := e.(*ast.BadExpr); ok && d.Recover {
+		return d.dumpBad("type", n.From, n.To)
 	}
 
 	// bail out
 
 	gotten := reflect.TypeOf(e).String()
-	pos := fset.PositionFor(e.Pos(), true)
-	Perish(pos, "unrecognized_type", gotten)
+	pos := d.Fset.PositionFor(e.Pos(), true)
+	fail(pos, "unrecognized_type", gotten)
 	panic("unreachable")
 }
 
+func (d *Dumper) dumpExprsAsType(exprs []ast.Expr) []interface{} {
+	values := make([]interface{}, len(exprs))
+	for i, v := range exprs {
+		values[i] = d.dumpExprAsType(v)
+	}
+
+	return values
+}
+
+// Flatten a (left-associative) chain of '|' into the list of its
+// terms, e.g. ~int | ~uint | string.
+func UnionTerms(b *ast.BinaryExpr) []ast.Expr {
+	terms := []ast.Expr{}
+	if l, ok := ast.Unparen(b.X).(*ast.BinaryExpr); ok && l.Op == token.OR {
+		terms = append(terms, UnionTerms(l)...)
+	} else {
+		terms = append(terms, b.X)
+	}
+	return append(terms, b.Y)
+}
+
+// Reports whether an index expression denotes an instantiation of a
+// generic function or type rather than an indexing operation. Only
+// possible to tell when type information is available.
+func (d *Dumper) isInstantiation(n *ast.IndexExpr) bool {
+	if d.Info == nil {
+		return false
+	}
+	return d.Info.Types[n.Index].IsType()
+}
+
 func DumpChanDir(d ast.ChanDir) string {
 	switch d {
 	case ast.SEND:
@@ -439,7 +939,7 @@ func DumpChanDir(d ast.ChanDir) string {
 		return "both"
 	}
 
-	Perish(INVALID_POSITION, "internal_error", fmt.Sprint(d))
+	fail(INVALID_POSITION, "internal_error", fmt.Sprint(d))
 	panic("unreachable")
 }
 
@@ -454,12 +954,12 @@ func isBasicFloat(ty types.Type) bool {
 
 // Dump constant values as BasicConstExprs. Only possible when type
 // information is available.
-func AttemptConst(e ast.Expr, fset *token.FileSet) map[string]interface{} {
-	tp := getGoType(e)
+func (d *Dumper) attemptConst(e ast.Expr) map[string]interface{} {
+	tp := d.getGoType(e)
 	if tp == nil {
 		return nil
 	}
-	value := tinfo.Types[e].Value
+	value := d.Info.Types[e].Value
 	if value == nil {
 		return nil
 	}
@@ -467,14 +967,15 @@ func AttemptConst(e ast.Expr, fset *token.FileSet) map[string]interface{} {
 	// Float literals end up being represented by integer
 	// constants when possible. Here we convert them back to
 	// floats.
-	if isBasicFloat(tinfo.Types[e].Type) {
+	if isBasicFloat(d.Info.Types[e].Type) {
 		value = constant.ToFloat(value)
 	}
 
 	return withType(map[string]interface{}{
 		"kind":     "constant",
 		"value":    DumpConstant(value),
-		"position": DumpPosition(fset.Position(e.Pos())),
+		"position": d.dumpPos(e.Pos()),
+		"end":      d.dumpPos(e.End()),
 	}, tp)
 }
 
@@ -502,10 +1003,12 @@ func DumpConstant(value constant.Value) map[string]interface{} {
 			"denominator": DumpConstant(constant.Denom(value)),
 		}
 	case constant.Complex:
+		// constant.Num and constant.Denom don't accept complex
+		// values, so dump the two parts separately.
 		return map[string]interface{}{
-			"type":        "COMPLEX",
-			"numerator":   DumpConstant(constant.Num(value)),
-			"denominator": DumpConstant(constant.Denom(value)),
+			"type": "COMPLEX",
+			"real": DumpConstant(constant.ToFloat(constant.Real(value))),
+			"imag": DumpConstant(constant.ToFloat(constant.Imag(value))),
 		}
 	case constant.Unknown:
 	default:
@@ -514,24 +1017,25 @@ func DumpConstant(value constant.Value) map[string]interface{} {
 	return nil
 }
 
-func DumpExpr(e ast.Expr, fset *token.FileSet) map[string]interface{} {
+func (d *Dumper) dumpExpr(e ast.Expr) map[string]interface{} {
 	if e == nil {
 		return nil
 	}
 
-	c := AttemptConst(e, fset)
+	c := d.attemptConst(e)
 	if c != nil {
 		return c
 	}
 
-	tp := getGoType(e)
+	tp := d.getGoType(e)
 
-	if _, ok := e.(*ast.ArrayType); ok {
-		return DumpExprAsType(e, fset)
+	switch e.(type) {
+	case *ast.ArrayType, *ast.ChanType, *ast.FuncType, *ast.InterfaceType, *ast.MapType, *ast.StructType:
+		return d.dumpExprAsType(e)
 	}
 
 	if n, ok := e.(*ast.Ident); ok {
-		val := DumpIdent(n, fset)
+		val := d.dumpIdent(n)
 
 		if val["type"] == "BOOL" {
 			return val
@@ -541,34 +1045,38 @@ func DumpExpr(e ast.Expr, fset *token.FileSet) map[string]interface{} {
 			"kind":     "expression",
 			"type":     "identifier",
 			"value":    val,
-			"position": DumpPosition(fset.Position(e.Pos())),
+			"position": d.dumpPos(e.Pos()),
+			"end":      d.dumpPos(e.End()),
 		}, tp)
 	}
 
 	if n, ok := e.(*ast.Ellipsis); ok {
 		return withType(map[string]interface{}{
-			"kind":  "expression",
-			"type":  "ellipsis",
-			"value": DumpExpr(n.Elt, fset),
+			"kind":     "expression",
+			"type":     "ellipsis",
+			"value":    d.dumpExpr(n.Elt),
+			"position": d.dumpPos(n.Pos()),
+			"end":      d.dumpPos(n.End()),
 		}, tp)
 	}
 
 	// is this the right place??
 	if n, ok := e.(*ast.FuncLit); ok {
-		variadic := ExtractVariadic(n.Type.Params)
-		return withType(map[string]interface{}{
+		params, variadic := splitVariadic(n.Type.Params)
+		return withType(d.linkScope(map[string]interface{}{
 			"kind":     "literal",
 			"type":     "function",
-			"params":   DumpFields(n.Type.Params, fset),
-			"variadic": AttemptField(variadic, fset),
-			"results":  DumpFields(n.Type.Results, fset),
-			"body":     DumpBlock(n.Body, fset),
-			"position": DumpPosition(fset.Position(e.Pos())),
-		}, tp)
+			"params":   d.dumpFields(params),
+			"variadic": d.attemptField(variadic),
+			"results":  d.dumpFields(n.Type.Results),
+			"body":     d.dumpBlock(n.Body),
+			"position": d.dumpPos(e.Pos()),
+			"end":      d.dumpPos(e.End()),
+		}, n.Type), tp)
 	}
 
 	if n, ok := e.(*ast.BasicLit); ok {
-		return DumpBasicLit(n, fset)
+		return d.dumpBasicLit(n)
 	}
 
 	if n, ok := e.(*ast.CompositeLit); ok {
@@ -580,9 +1088,10 @@ func DumpExpr(e ast.Expr, fset *token.FileSet) map[string]interface{} {
 		return withType(map[string]interface{}{
 			"kind":     "literal",
 			"type":     "composite",
-			"declared": AttemptExprAsType(n.Type, fset),
-			"values":   DumpExprs(n.Elts, fset),
-			"position": DumpPosition(fset.Position(e.Pos())),
+			"declared": d.attemptExprAsType(n.Type),
+			"values":   d.dumpExprs(n.Elts),
+			"position": d.dumpPos(e.Pos()),
+			"end":      d.dumpPos(e.End()),
 		}, tp)
 	}
 
@@ -590,58 +1099,87 @@ func DumpExpr(e ast.Expr, fset *token.FileSet) map[string]interface{} {
 		return withType(map[string]interface{}{
 			"kind":     "expression",
 			"type":     "binary",
-			"left":     DumpExpr(b.X, fset),
-			"right":    DumpExpr(b.Y, fset),
+			"left":     d.dumpExpr(b.X),
+			"right":    d.dumpExpr(b.Y),
 			"operator": b.Op.String(),
-			"position": DumpPosition(fset.Position(b.Pos())),
+			"position": d.dumpPos(b.Pos()),
+			"end":      d.dumpPos(b.End()),
 		}, tp)
 	}
 
+	if n, ok := e.(*ast.IndexExpr); ok && d.isInstantiation(n) {
+		return withType(d.withInstance(map[string]interface{}{
+			"kind":      "expression",
+			"type":      "instantiation",
+			"target":    d.dumpExpr(n.X),
+			"arguments": d.dumpExprsAsType([]ast.Expr{n.Index}),
+			"position":  d.dumpPos(e.Pos()),
+			"end":       d.dumpPos(e.End()),
+		}, n.X), tp)
+	}
+
+	// Multiple indices can only be type arguments.
+	if n, ok := e.(*ast.IndexListExpr); ok {
+		return withType(d.withInstance(map[string]interface{}{
+			"kind":      "expression",
+			"type":      "instantiation",
+			"target":    d.dumpExpr(n.X),
+			"arguments": d.dumpExprsAsType(n.Indices),
+			"position":  d.dumpPos(e.Pos()),
+			"end":       d.dumpPos(e.End()),
+		}, n.X), tp)
+	}
+
 	if n, ok := e.(*ast.IndexExpr); ok {
 		return withType(map[string]interface{}{
 			"kind":     "expression",
 			"type":     "index",
-			"target":   DumpExpr(n.X, fset),
-			"index":    DumpExpr(n.Index, fset),
-			"position": DumpPosition(fset.Position(e.Pos())),
+			"target":   d.dumpExpr(n.X),
+			"index":    d.dumpExpr(n.Index),
+			"position": d.dumpPos(e.Pos()),
+			"end":      d.dumpPos(e.End()),
 		}, tp)
 	}
 
 	if n, ok := e.(*ast.StarExpr); ok {
 		return withType(map[string]interface{}{
-			"kind":   "expression",
-			"type":   "star",
-			"target": DumpExpr(n.X, fset),
+			"kind":     "expression",
+			"type":     "star",
+			"target":   d.dumpExpr(n.X),
+			"position": d.dumpPos(n.Pos()),
+			"end":      d.dumpPos(n.End()),
 		}, tp)
 	}
 
 	if n, ok := e.(*ast.CallExpr); ok {
-		return DumpCall(n, fset)
+		return d.dumpCall(n)
 	}
 
 	if n, ok := e.(*ast.ParenExpr); ok {
 		return withType(map[string]interface{}{
 			"kind":     "expression",
 			"type":     "paren",
-			"target":   DumpExpr(n.X, fset),
-			"position": DumpPosition(fset.Position(e.Pos())),
+			"target":   d.dumpExpr(n.X),
+			"position": d.dumpPos(e.Pos()),
+			"end":      d.dumpPos(e.End()),
 		}, tp)
 	}
 
 	if n, ok := e.(*ast.SelectorExpr); ok {
-		lhs := DumpExpr(n.X, fset)
+		lhs := d.dumpExpr(n.X)
 		// If the left hand side is just an identifier without a further qualifier,
 		// assume that this is a qualified expression rather than a method call.
 		// this is not correct in all cases, but ensuring correctness is outside
 		// of the scope of a lowly parser such as goblin.
 		// NOTE: this heuristic is only used when no type information is available.
-		if tinfo == nil && lhs["type"] == "identifier" && lhs["qualifier"] == nil {
+		if d.Info == nil && lhs["type"] == "identifier" && lhs["qualifier"] == nil {
 			return map[string]interface{}{
 				"kind":      "expression",
 				"type":      "identifier",
 				"qualifier": lhs["value"],
-				"value":     DumpIdent(n.Sel, fset),
-				"position":  DumpPosition(fset.Position(e.Pos())),
+				"value":     d.dumpIdent(n.Sel),
+				"position":  d.dumpPos(e.Pos()),
+				"end":       d.dumpPos(e.End()),
 			}
 		}
 
@@ -652,29 +1190,41 @@ func DumpExpr(e ast.Expr, fset *token.FileSet) map[string]interface{} {
 					"kind":      "expression",
 					"type":      "identifier",
 					"qualifier": lhs["value"],
-					"value":     DumpIdent(n.Sel, fset),
-					"position":  DumpPosition(fset.Position(e.Pos())),
+					"value":     d.dumpIdent(n.Sel),
+					"position":  d.dumpPos(e.Pos()),
+					"end":       d.dumpPos(e.End()),
 				}, tp)
 			}
 		}
 
 		// Otherwise it's a field/method selector.
-		return withType(map[string]interface{}{
+		if d.Desugar {
+			if res := d.desugarSelector(n, lhs, tp); res != nil {
+				return res
+			}
+		}
+		res := map[string]interface{}{
 			"kind":     "expression",
 			"type":     "selector",
 			"target":   lhs,
-			"field":    DumpIdent(n.Sel, fset),
-			"position": DumpPosition(fset.Position(e.Pos())),
-		}, tp)
+			"field":    d.dumpIdent(n.Sel),
+			"position": d.dumpPos(e.Pos()),
+			"end":      d.dumpPos(e.End()),
+		}
+		if selection := d.dumpSelection(n); selection != nil {
+			res["selection"] = selection
+		}
+		return withType(res, tp)
 	}
 
 	if n, ok := e.(*ast.TypeAssertExpr); ok {
 		return withType(map[string]interface{}{
 			"kind":     "expression",
 			"type":     "type-assert",
-			"target":   DumpExpr(n.X, fset),
-			"asserted": AttemptExprAsType(n.Type, fset),
-			"position": DumpPosition(fset.Position(e.Pos())),
+			"target":   d.dumpExpr(n.X),
+			"asserted": d.attemptExprAsType(n.Type),
+			"position": d.dumpPos(e.Pos()),
+			"end":      d.dumpPos(e.End()),
 		}, tp)
 	}
 
@@ -682,9 +1232,10 @@ func DumpExpr(e ast.Expr, fset *token.FileSet) map[string]interface{} {
 		return withType(map[string]interface{}{
 			"kind":     "expression",
 			"type":     "unary",
-			"target":   DumpExpr(n.X, fset),
+			"target":   d.dumpExpr(n.X),
 			"operator": n.Op.String(),
-			"position": DumpPosition(fset.Position(n.Pos())),
+			"position": d.dumpPos(n.Pos()),
+			"end":      d.dumpPos(n.End()),
 		}, tp)
 	}
 
@@ -692,38 +1243,83 @@ func DumpExpr(e ast.Expr, fset *token.FileSet) map[string]interface{} {
 		return withType(map[string]interface{}{
 			"kind":     "expression",
 			"type":     "slice",
-			"target":   DumpExpr(n.X, fset),
-			"low":      DumpExpr(n.Low, fset),
-			"high":     DumpExpr(n.High, fset),
-			"max":      DumpExpr(n.Max, fset),
+			"target":   d.dumpExpr(n.X),
+			"low":      d.dumpExpr(n.Low),
+			"high":     d.dumpExpr(n.High),
+			"max":      d.dumpExpr(n.Max),
 			"three":    n.Slice3,
-			"position": DumpPosition(fset.Position(e.Pos())),
+			"position": d.dumpPos(e.Pos()),
+			"end":      d.dumpPos(e.End()),
 		}, tp)
 	}
 
 	if n, ok := e.(*ast.KeyValueExpr); ok {
 		return withType(map[string]interface{}{
-			"kind":  "expression",
-			"type":  "key-value",
-			"key":   DumpExpr(n.Key, fset),
-			"value": DumpExpr(n.Value, fset),
+			"kind":     "expression",
+			"type":     "key-value",
+			"key":      d.dumpExpr(n.Key),
+			"value":    d.dumpExpr(n.Value),
+			"position": d.dumpPos(n.Pos()),
+			"end":      d.dumpPos(n.End()),
 		}, tp)
 	}
 
 	if n, ok := e.(*ast.BadExpr); ok {
-		pos := fset.PositionFor(n.From, true)
-		Perish(pos, "internal_error", "encountered BadExpr")
+		if d.Recover {
+			return d.dumpBad("expression", n.From, n.To)
+		}
+		pos := d.Fset.PositionFor(n.From, true)
+		fail(pos, "internal_error", "encountered BadExpr")
 	}
 
 	typ := reflect.TypeOf(e).String()
-	Perish(fset.Position(e.Pos()), "unexpected_node", typ)
+	fail(d.Fset.Position(e.Pos()), "unexpected_node", typ)
 	panic("unreachable")
 }
 
-func DumpExprs(exprs []ast.Expr, fset *token.FileSet) []interface{} {
+// The names of the types.SelectionKind values, as dumped.
+var SelectionKindStrings = [...]string{
+	types.FieldVal:   "field-value",
+	types.MethodVal:  "method-value",
+	types.MethodExpr: "method-expression",
+}
+
+// What a selector selects, if there's type information about it: a
+// field or method, by the path of field indices (through any embedded
+// fields) and method index from the receiver's type, and whether a
+// pointer is implicitly dereferenced on the way.
+func (d *Dumper) dumpSelection(n *ast.SelectorExpr) map[string]interface{} {
+	if d.Info == nil {
+		return nil
+	}
+	sel := d.Info.Selections[n]
+	if sel == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"selection-kind": SelectionKindStrings[sel.Kind()],
+		"index":          sel.Index(),
+		"indirect":       sel.Indirect(),
+		"receiver":       d.dumpGoType(sel.Recv()),
+	}
+}
+
+// The source range of a node the parser couldn't make sense of.
+func (d *Dumper) dumpBad(kind string, from, to token.Pos) map[string]interface{} {
+	return map[string]interface{}{
+		"kind":     kind,
+		"type":     "bad",
+		"from":     d.dumpPos(from),
+		"to":       d.dumpPos(to),
+		"position": d.dumpPos(from),
+		"end":      d.dumpPos(to),
+	}
+}
+
+func (d *Dumper) dumpExprs(exprs []ast.Expr) []interface{} {
 	values := make([]interface{}, len(exprs))
 	for i, v := range exprs {
-		values[i] = DumpExpr(v, fset)
+		values[i] = d.dumpExpr(v)
 	}
 
 	return values
@@ -753,7 +1349,7 @@ func TokenGoType(tok token.Token) types.Type {
 	return types.Typ[TokenBasicKind(tok)]
 }
 
-func DumpBasicLit(l *ast.BasicLit, fset *token.FileSet) map[string]interface{} {
+func (d *Dumper) dumpBasicLit(l *ast.BasicLit) map[string]interface{} {
 	if l == nil {
 		return nil
 	}
@@ -762,19 +1358,20 @@ func DumpBasicLit(l *ast.BasicLit, fset *token.FileSet) map[string]interface{} {
 		"kind":     "literal",
 		"type":     l.Kind.String(),
 		"value":    l.Value,
-		"position": DumpPosition(fset.Position(l.Pos())),
-	}, DumpGoType(TokenGoType(l.Kind)))
+		"position": d.dumpPos(l.Pos()),
+		"end":      d.dumpPos(l.End()),
+	}, d.dumpGoType(TokenGoType(l.Kind)))
 }
 
-func AttemptField(f *ast.Field, fset *token.FileSet) map[string]interface{} {
+func (d *Dumper) attemptField(f *ast.Field) map[string]interface{} {
 	if f == nil {
 		return nil
 	} else {
-		return DumpField(f, fset)
+		return d.dumpField(f)
 	}
 }
 
-func DumpField(f *ast.Field, fset *token.FileSet) map[string]interface{} {
+func (d *Dumper) dumpField(f *ast.Field) map[string]interface{} {
 	nameCount := 0
 	if f.Names != nil {
 		nameCount = len(f.Names)
@@ -783,32 +1380,34 @@ func DumpField(f *ast.Field, fset *token.FileSet) map[string]interface{} {
 	names := make([]interface{}, nameCount)
 	if f.Names != nil {
 		for i, v := range f.Names {
-			names[i] = DumpIdent(v, fset)
+			names[i] = d.dumpIdent(v)
 		}
 	}
 
-	return map[string]interface{}{
+	return d.withComments(map[string]interface{}{
 		"kind":          "field",
 		"names":         names,
-		"declared-type": DumpExprAsType(f.Type, fset),
-		"tag":           DumpBasicLit(f.Tag, fset),
-	}
+		"declared-type": d.dumpExprAsType(f.Type),
+		"tag":           d.dumpBasicLit(f.Tag),
+		"position":      d.dumpPos(f.Pos()),
+		"end":           d.dumpPos(f.End()),
+	}, f)
 }
 
-func DumpFields(fs *ast.FieldList, fset *token.FileSet) []map[string]interface{} {
+func (d *Dumper) dumpFields(fs *ast.FieldList) []map[string]interface{} {
 	if fs == nil {
 		return nil
 	}
 
 	results := make([]map[string]interface{}, len(fs.List))
 	for i, v := range fs.List {
-		results[i] = DumpField(v, fset)
+		results[i] = d.dumpField(v)
 	}
 
 	return results
 }
 
-func DumpCommentGroup(g *ast.CommentGroup, fset *token.FileSet) []string {
+func (d *Dumper) dumpCommentGroup(g *ast.CommentGroup) []string {
 	if g == nil {
 		return []string{}
 	}
@@ -821,37 +1420,62 @@ func DumpCommentGroup(g *ast.CommentGroup, fset *token.FileSet) []string {
 	return result
 }
 
-func DumpTypeAlias(ts []*ast.TypeSpec, fset *token.FileSet) map[string]interface{} {
-	binds := make([]interface{}, len(ts))
-	for i, t := range ts {
-		binds[i] = map[string]interface{}{
-			"name":  DumpIdent(t.Name, fset),
-			"value": DumpExprAsType(t.Type, fset),
-		}
-	}
+// Type declarations are either aliases (type A = B) or definitions of
+// a new named type (type A B).
+func (d *Dumper) dumpTypeSpec(spec *ast.TypeSpec, doc *ast.CommentGroup) map[string]interface{} {
+	typ := "type-definition"
+	if spec.Assign != token.NoPos {
+		typ = "type-alias"
+	}
+
+	return d.linkScope(d.withSpecialization(map[string]interface{}{
+		"kind":        "spec",
+		"type":        typ,
+		"name":        d.dumpIdent(spec.Name),
+		"type-params": d.dumpFields(spec.TypeParams),
+		"value":       d.dumpExprAsType(spec.Type),
+		"object":      d.dumpTypeName(spec.Name),
+		"doc":         d.dumpCommentGroup(doc),
+		"comments":    d.dumpCommentGroup(spec.Comment),
+		"position":    d.dumpPos(spec.Pos()),
+		"end":         d.dumpPos(spec.End()),
+	}, spec), spec)
+}
 
+// The type name declared by a type spec. Only available when type
+// information is.
+func (d *Dumper) dumpTypeName(name *ast.Ident) map[string]interface{} {
+	if d.Info == nil {
+		return nil
+	}
+	obj, ok := d.Info.Defs[name].(*types.TypeName)
+	if !ok {
+		return nil
+	}
+	// For aliases, the type is the aliased type.
 	return map[string]interface{}{
-		"kind":     "decl",
-		"type":     "type-alias",
-		"binds":    binds,
-		"position": DumpPosition(fset.Position(ts[0].Pos())),
+		"name":     obj.Name(),
+		"is-alias": obj.IsAlias(),
+		"type":     d.dumpGoType(obj.Type()),
 	}
 }
 
-func DumpCall(c *ast.CallExpr, fset *token.FileSet) map[string]interface{} {
-	e := AttemptConst(c, fset)
+func (d *Dumper) dumpCall(c *ast.CallExpr) map[string]interface{} {
+	e := d.attemptConst(c)
 	if e != nil {
 		return e
 	}
-	tp := getGoType(c)
+	tp := d.getGoType(c)
 
-	if callee, ok := c.Fun.(*ast.Ident); ok {
+	// (A half-typed call may not have any arguments yet.)
+	if callee, ok := c.Fun.(*ast.Ident); ok && len(c.Args) > 0 {
 		if callee.Name == "new" {
 			return withType(map[string]interface{}{
 				"kind":     "expression",
 				"type":     "new",
-				"argument": DumpExprAsType(c.Args[0], fset),
-				"position": DumpPosition(fset.Position(c.Pos())),
+				"argument": d.dumpExprAsType(c.Args[0]),
+				"position": d.dumpPos(c.Pos()),
+				"end":      d.dumpPos(c.End()),
 			}, tp)
 		}
 
@@ -859,9 +1483,10 @@ func DumpCall(c *ast.CallExpr, fset *token.FileSet) map[string]interface{} {
 			return withType(map[string]interface{}{
 				"kind":     "expression",
 				"type":     "make",
-				"argument": DumpExprAsType(c.Args[0], fset),
-				"rest":     DumpExprs(c.Args[1:], fset),
-				"position": DumpPosition(fset.Position(c.Pos())),
+				"argument": d.dumpExprAsType(c.Args[0]),
+				"rest":     d.dumpExprs(c.Args[1:]),
+				"position": d.dumpPos(c.Pos()),
+				"end":      d.dumpPos(c.End()),
 			}, tp)
 		}
 	}
@@ -872,43 +1497,83 @@ func DumpCall(c *ast.CallExpr, fset *token.FileSet) map[string]interface{} {
 	// et cetera). such heuristics can't be perfectly accurate due to cross-module type
 	// declarations, so it's probably more morally-correct, if less helpful, to treat them
 	// as function calls and disambiguate them at a further stage.
-	callee := AttemptExprAsType(c.Fun, fset)
+	callee := d.attemptExprAsType(c.Fun)
 
-	if callee != nil && callee["value"] != nil && (callee["type"] != "identifier" ||
-		callee["value"].(map[string]interface{})["ident-kind"] == "TypeName") {
+	// When type information is available, it tells us for sure.
+	// Without it, an instantiated generic callee is taken to be a
+	// call to a generic function.
+	isCast := false
+	if d.Info != nil {
+		isCast = callee != nil && d.Info.Types[c.Fun].IsType()
+	} else if callee != nil && callee["type"] != "instantiation" {
+		isCast = callee["value"] != nil && (callee["type"] != "identifier" ||
+			callee["value"].(map[string]interface{})["ident-kind"] == "TypeName")
+	}
+
+	if isCast && len(c.Args) == 1 {
 		return withType(map[string]interface{}{
 			"kind":       "expression",
 			"type":       "cast",
-			"target":     DumpExpr(c.Args[0], fset),
+			"target":     d.dumpExpr(c.Args[0]),
 			"coerced-to": callee,
-			"position":   DumpPosition(fset.Position(c.Pos())),
+			"position":   d.dumpPos(c.Pos()),
+			"end":        d.dumpPos(c.End()),
 		}, tp)
 	}
 
 	return withType(map[string]interface{}{
 		"kind":      "expression",
 		"type":      "call",
-		"function":  DumpExpr(c.Fun, fset),
-		"arguments": DumpExprs(c.Args, fset),
+		"function":  d.dumpExpr(c.Fun),
+		"arguments": d.dumpExprs(c.Args),
 		"ellipsis":  c.Ellipsis != token.NoPos,
-		"position":  DumpPosition(fset.Position(c.Pos())),
+		"position":  d.dumpPos(c.Pos()),
+		"end":       d.dumpPos(c.End()),
 	}, tp)
 }
 
-func DumpImport(spec *ast.ImportSpec, fset *token.FileSet) map[string]interface{} {
+func (d *Dumper) dumpImport(spec *ast.ImportSpec) map[string]interface{} {
 	res := map[string]interface{}{
 		"type":     "import",
-		"doc":      DumpCommentGroup(spec.Doc, fset),
-		"comments": DumpCommentGroup(spec.Comment, fset),
-		"name":     DumpIdent(spec.Name, fset),
+		"doc":      d.dumpCommentGroup(spec.Doc),
+		"comments": d.dumpCommentGroup(spec.Comment),
+		"name":     d.dumpIdent(spec.Name),
 		"path":     strings.Trim(spec.Path.Value, "\""),
-		"position": DumpPosition(fset.Position(spec.Pos())),
+		"position": d.dumpPos(spec.Pos()),
+		"end":      d.dumpPos(spec.End()),
+	}
+	if implicit := d.dumpImplicit(spec); implicit != nil {
+		res["implicit"] = implicit
 	}
 
 	return res
 }
 
-func DumpValue(kind string, spec *ast.ValueSpec, fset *token.FileSet) map[string]interface{} {
+// The object a node declares without naming it (the package name of an
+// unnamed import, or the variable of a type switch in one of its
+// clauses), if there's type information about it.
+func (d *Dumper) dumpImplicit(n ast.Node) map[string]interface{} {
+	if d.Info == nil {
+		return nil
+	}
+	switch obj := d.Info.Implicits[n].(type) {
+	case *types.PkgName:
+		return map[string]interface{}{
+			"name":    obj.Name(),
+			"id":      objectID(obj, d.Fset, d.Types.fieldOwner),
+			"package": obj.Imported().Path(),
+		}
+	case *types.Var:
+		return map[string]interface{}{
+			"name":    obj.Name(),
+			"id":      objectID(obj, d.Fset, d.Types.fieldOwner),
+			"go-type": d.dumpGoType(obj.Type()),
+		}
+	}
+	return nil
+}
+
+func (d *Dumper) dumpValue(kind string, spec *ast.ValueSpec) map[string]interface{} {
 	givenValues := []ast.Expr{}
 	if spec.Values != nil {
 		givenValues = spec.Values
@@ -916,69 +1581,80 @@ func DumpValue(kind string, spec *ast.ValueSpec, fset *token.FileSet) map[string
 
 	processedValues := make([]interface{}, len(givenValues))
 	for i, v := range givenValues {
-		processedValues[i] = DumpExpr(v, fset)
+		processedValues[i] = d.dumpExpr(v)
 	}
 
 	processedNames := make([]interface{}, len(spec.Names))
 	for i, v := range spec.Names {
-		processedNames[i] = DumpIdent(v, fset)
+		processedNames[i] = d.dumpIdent(v)
 	}
 
 	return map[string]interface{}{
 		"kind":          "spec",
 		"type":          kind,
 		"names":         processedNames,
-		"declared-type": AttemptExprAsType(spec.Type, fset),
+		"declared-type": d.attemptExprAsType(spec.Type),
 		"values":        processedValues,
-		"comments":      DumpCommentGroup(spec.Comment, fset),
-		"position":      DumpPosition(fset.Position(spec.Pos())),
-	}
-}
-
-func TypeSpecsOfSpecs(specs []ast.Spec) []*ast.TypeSpec {
-	ts := make([]*ast.TypeSpec, len(specs))
-	for i, spec := range specs {
-		ts[i] = spec.(*ast.TypeSpec)
+		"comments":      d.dumpCommentGroup(spec.Comment),
+		"position":      d.dumpPos(spec.Pos()),
+		"end":           d.dumpPos(spec.End()),
 	}
-	return ts
 }
 
-func DumpGenDecl(decl *ast.GenDecl, fset *token.FileSet) map[string]interface{} {
+func (d *Dumper) dumpGenDecl(decl *ast.GenDecl) map[string]interface{} {
 	prettyToken := ""
 	results := make([]interface{}, len(decl.Specs))
 	switch decl.Tok {
 	case token.TYPE:
-		// EARLY RETURN
-		return DumpTypeAlias(TypeSpecsOfSpecs(decl.Specs), fset)
+		prettyToken = "type"
+		for i, v := range decl.Specs {
+			// The doc comment of an ungrouped declaration
+			// belongs to the GenDecl rather than the spec.
+			spec := v.(*ast.TypeSpec)
+			doc := spec.Doc
+			if doc == nil && !decl.Lparen.IsValid() {
+				doc = decl.Doc
+			}
+			results[i] = d.withComments(d.dumpTypeSpec(spec, doc), spec)
+		}
 	case token.IMPORT:
 		prettyToken = "import"
 		for i, v := range decl.Specs {
-			results[i] = DumpImport(v.(*ast.ImportSpec), fset)
+			results[i] = d.withComments(d.dumpImport(v.(*ast.ImportSpec)), v)
 		}
 	case token.CONST:
 		prettyToken = "const"
 		for i, v := range decl.Specs {
-			results[i] = DumpValue("const", v.(*ast.ValueSpec), fset)
+			results[i] = d.withComments(d.dumpValue("const", v.(*ast.ValueSpec)), v)
 		}
 	case token.VAR:
 		prettyToken = "var"
 		for i, v := range decl.Specs {
-			results[i] = DumpValue("var", v.(*ast.ValueSpec), fset)
+			results[i] = d.withComments(d.dumpValue("var", v.(*ast.ValueSpec)), v)
 		}
 	default:
-		pos := fset.PositionFor(decl.Pos(), true)
-		Perish(pos, "unrecognized_token", decl.Tok.String())
+		pos := d.Fset.PositionFor(decl.Pos(), true)
+		fail(pos, "unrecognized_token", decl.Tok.String())
 	}
 
 	return map[string]interface{}{
 		"kind":     "decl",
 		"type":     prettyToken,
 		"specs":    results,
-		"position": DumpPosition(fset.Position(decl.Pos())),
+		"position": d.dumpPos(decl.Pos()),
+		"end":      d.dumpPos(decl.End()),
 	}
 }
 
-func DumpStmt(s ast.Stmt, fset *token.FileSet) interface{} {
+func (d *Dumper) dumpStmt(s ast.Stmt) interface{} {
+	res := d.dumpStmtNode(s)
+	if m, ok := res.(map[string]interface{}); ok {
+		d.withComments(m, s)
+	}
+	return res
+}
+
+func (d *Dumper) dumpStmtNode(s ast.Stmt) interface{} {
 	if s == nil {
 		return nil
 	}
@@ -987,8 +1663,9 @@ func DumpStmt(s ast.Stmt, fset *token.FileSet) interface{} {
 		return map[string]interface{}{
 			"kind":     "statement",
 			"type":     "return",
-			"values":   DumpExprs(n.Results, fset),
-			"position": DumpPosition(fset.Position(n.Pos())),
+			"values":   d.dumpExprs(n.Results),
+			"position": d.dumpPos(n.Pos()),
+			"end":      d.dumpPos(n.End()),
 		}
 	}
 
@@ -997,18 +1674,20 @@ func DumpStmt(s ast.Stmt, fset *token.FileSet) interface{} {
 			return map[string]interface{}{
 				"kind":     "statement",
 				"type":     "assign",
-				"left":     DumpExprs(n.Lhs, fset),
-				"right":    DumpExprs(n.Rhs, fset),
-				"position": DumpPosition(fset.Position(n.Pos())),
+				"left":     d.dumpExprs(n.Lhs),
+				"right":    d.dumpExprs(n.Rhs),
+				"position": d.dumpPos(n.Pos()),
+				"end":      d.dumpPos(n.End()),
 			}
 
 		} else if n.Tok == token.DEFINE {
 			return map[string]interface{}{
 				"kind":     "statement",
 				"type":     "define",
-				"left":     DumpExprs(n.Lhs, fset),
-				"right":    DumpExprs(n.Rhs, fset),
-				"position": DumpPosition(fset.Position(n.Pos())),
+				"left":     d.dumpExprs(n.Lhs),
+				"right":    d.dumpExprs(n.Rhs),
+				"position": d.dumpPos(n.Pos()),
+				"end":      d.dumpPos(n.End()),
 			}
 		} else {
 			tok := n.Tok.String()
@@ -1016,9 +1695,10 @@ func DumpStmt(s ast.Stmt, fset *token.FileSet) interface{} {
 				"kind":     "statement",
 				"type":     "assign-operator",
 				"operator": tok[0 : len(tok)-1],
-				"left":     DumpExprs(n.Lhs, fset),
-				"right":    DumpExprs(n.Rhs, fset),
-				"position": DumpPosition(fset.Position(n.Pos())),
+				"left":     d.dumpExprs(n.Lhs),
+				"right":    d.dumpExprs(n.Rhs),
+				"position": d.dumpPos(n.Pos()),
+				"end":      d.dumpPos(n.End()),
 			}
 		}
 
@@ -1028,15 +1708,18 @@ func DumpStmt(s ast.Stmt, fset *token.FileSet) interface{} {
 		return map[string]interface{}{
 			"kind":     "statement",
 			"type":     "empty",
-			"position": DumpPosition(fset.Position(n.Pos())),
+			"position": d.dumpPos(n.Pos()),
+			"end":      d.dumpPos(n.End()),
 		}
 	}
 
 	if n, ok := s.(*ast.ExprStmt); ok {
 		return map[string]interface{}{
-			"kind":  "statement",
-			"type":  "expression",
-			"value": DumpExpr(n.X, fset),
+			"kind":     "statement",
+			"type":     "expression",
+			"value":    d.dumpExpr(n.X),
+			"position": d.dumpPos(n.Pos()),
+			"end":      d.dumpPos(n.End()),
 		}
 	}
 
@@ -1044,30 +1727,32 @@ func DumpStmt(s ast.Stmt, fset *token.FileSet) interface{} {
 		return map[string]interface{}{
 			"kind":      "statement",
 			"type":      "labeled",
-			"label":     DumpIdent(n.Label, fset),
-			"statement": DumpStmt(n.Stmt, fset),
-			"position":  DumpPosition(fset.Position(n.Pos())),
+			"label":     d.dumpIdent(n.Label),
+			"statement": d.dumpStmt(n.Stmt),
+			"position":  d.dumpPos(n.Pos()),
+			"end":       d.dumpPos(n.End()),
 		}
 	}
 
 	if n, ok := s.(*ast.BranchStmt); ok {
 		result := map[string]interface{}{
 			"kind":     "statement",
-			"position": DumpPosition(fset.Position(n.Pos())),
+			"position": d.dumpPos(n.Pos()),
+			"end":      d.dumpPos(n.End()),
 		}
 
 		switch n.Tok {
 		case token.BREAK:
 			result["type"] = "break"
-			result["label"] = DumpIdent(n.Label, fset)
+			result["label"] = d.dumpIdent(n.Label)
 
 		case token.CONTINUE:
 			result["type"] = "continue"
-			result["label"] = DumpIdent(n.Label, fset)
+			result["label"] = d.dumpIdent(n.Label)
 
 		case token.GOTO:
 			result["type"] = "goto"
-			result["label"] = DumpIdent(n.Label, fset)
+			result["label"] = d.dumpIdent(n.Label)
 
 		case token.FALLTHROUGH:
 			result["type"] = "fallthrough"
@@ -1077,23 +1762,25 @@ func DumpStmt(s ast.Stmt, fset *token.FileSet) interface{} {
 	}
 
 	if n, ok := s.(*ast.RangeStmt); ok {
-		return map[string]interface{}{
+		return d.linkScope(map[string]interface{}{
 			"kind":      "statement",
 			"type":      "range",
-			"key":       DumpExpr(n.Key, fset),
-			"value":     DumpExpr(n.Value, fset),
-			"target":    DumpExpr(n.X, fset),
+			"key":       d.dumpExpr(n.Key),
+			"value":     d.dumpExpr(n.Value),
+			"target":    d.dumpExpr(n.X),
 			"is-assign": n.Tok == token.ASSIGN,
-			"body":      DumpBlock(n.Body, fset),
-			"position":  DumpPosition(fset.Position(n.Pos())),
-		}
+			"body":      d.dumpBlock(n.Body),
+			"position":  d.dumpPos(n.Pos()),
+			"end":       d.dumpPos(n.End()),
+		}, n)
 	}
 	if n, ok := s.(*ast.DeclStmt); ok {
 		return map[string]interface{}{
 			"kind":     "statement",
 			"type":     "declaration",
-			"target":   DumpDecl(n.Decl, fset),
-			"position": DumpPosition(fset.Position(n.Pos())),
+			"target":   d.dumpDecl(n.Decl),
+			"position": d.dumpPos(n.Pos()),
+			"end":      d.dumpPos(n.End()),
 		}
 	}
 
@@ -1101,45 +1788,49 @@ func DumpStmt(s ast.Stmt, fset *token.FileSet) interface{} {
 		return map[string]interface{}{
 			"kind":     "statement",
 			"type":     "defer",
-			"target":   DumpCall(n.Call, fset),
-			"position": DumpPosition(fset.Position(n.Pos())),
+			"target":   d.dumpCall(n.Call),
+			"position": d.dumpPos(n.Pos()),
+			"end":      d.dumpPos(n.End()),
 		}
 	}
 
 	if n, ok := s.(*ast.IfStmt); ok {
-		return map[string]interface{}{
+		return d.linkScope(map[string]interface{}{
 			"kind":      "statement",
 			"type":      "if",
-			"init":      DumpStmt(n.Init, fset),
-			"condition": DumpExpr(n.Cond, fset),
-			"body":      DumpBlock(n.Body, fset),
-			"else":      DumpStmt(n.Else, fset),
-			"position":  DumpPosition(fset.Position(n.Pos())),
-		}
+			"init":      d.dumpStmt(n.Init),
+			"condition": d.dumpExpr(n.Cond),
+			"body":      d.dumpBlock(n.Body),
+			"else":      d.dumpStmt(n.Else),
+			"position":  d.dumpPos(n.Pos()),
+			"end":       d.dumpPos(n.End()),
+		}, n)
 	}
 
 	if n, ok := s.(*ast.BlockStmt); ok {
-		return DumpBlockAsStmt(n, fset)
+		return d.dumpBlockAsStmt(n)
 	}
 
 	if n, ok := s.(*ast.ForStmt); ok {
-		return map[string]interface{}{
+		return d.linkScope(map[string]interface{}{
 			"kind":      "statement",
 			"type":      "for",
-			"init":      DumpStmt(n.Init, fset),
-			"condition": DumpExpr(n.Cond, fset),
-			"post":      DumpStmt(n.Post, fset),
-			"body":      DumpBlock(n.Body, fset),
-			"position":  DumpPosition(fset.Position(n.Pos())),
-		}
+			"init":      d.dumpStmt(n.Init),
+			"condition": d.dumpExpr(n.Cond),
+			"post":      d.dumpStmt(n.Post),
+			"body":      d.dumpBlock(n.Body),
+			"position":  d.dumpPos(n.Pos()),
+			"end":       d.dumpPos(n.End()),
+		}, n)
 	}
 
 	if n, ok := s.(*ast.GoStmt); ok {
 		return map[string]interface{}{
 			"kind":     "statement",
 			"type":     "go",
-			"target":   DumpCall(n.Call, fset),
-			"position": DumpPosition(fset.Position(n.Pos())),
+			"target":   d.dumpCall(n.Call),
+			"position": d.dumpPos(n.Pos()),
+			"end":      d.dumpPos(n.End()),
 		}
 	}
 
@@ -1147,9 +1838,10 @@ func DumpStmt(s ast.Stmt, fset *token.FileSet) interface{} {
 		return map[string]interface{}{
 			"kind":     "statement",
 			"type":     "send",
-			"channel":  DumpExpr(n.Chan, fset),
-			"value":    DumpExpr(n.Value, fset),
-			"position": DumpPosition(fset.Position(n.Pos())),
+			"channel":  d.dumpExpr(n.Chan),
+			"value":    d.dumpExpr(n.Value),
+			"position": d.dumpPos(n.Pos()),
+			"end":      d.dumpPos(n.End()),
 		}
 	}
 
@@ -1157,8 +1849,9 @@ func DumpStmt(s ast.Stmt, fset *token.FileSet) interface{} {
 		return map[string]interface{}{
 			"kind":     "statement",
 			"type":     "select",
-			"body":     DumpBlock(n.Body, fset),
-			"position": DumpPosition(fset.Position(n.Pos())),
+			"body":     d.dumpBlock(n.Body),
+			"position": d.dumpPos(n.Pos()),
+			"end":      d.dumpPos(n.End()),
 		}
 	}
 
@@ -1166,166 +1859,200 @@ func DumpStmt(s ast.Stmt, fset *token.FileSet) interface{} {
 		return map[string]interface{}{
 			"kind":      "statement",
 			"type":      "crement",
-			"target":    DumpExpr(n.X, fset),
+			"target":    d.dumpExpr(n.X),
 			"operation": n.Tok.String(),
-			"position":  DumpPosition(fset.Position(n.Pos())),
+			"position":  d.dumpPos(n.Pos()),
+			"end":       d.dumpPos(n.End()),
 		}
 	}
 
 	if n, ok := s.(*ast.SwitchStmt); ok {
-		return map[string]interface{}{
+		return d.linkScope(map[string]interface{}{
 			"kind":      "statement",
 			"type":      "switch",
-			"init":      DumpStmt(n.Init, fset),
-			"condition": DumpExpr(n.Tag, fset),
-			"body":      DumpBlock(n.Body, fset),
-			"position":  DumpPosition(fset.Position(n.Pos())),
-		}
+			"init":      d.dumpStmt(n.Init),
+			"condition": d.dumpExpr(n.Tag),
+			"body":      d.dumpBlock(n.Body),
+			"position":  d.dumpPos(n.Pos()),
+			"end":       d.dumpPos(n.End()),
+		}, n)
 	}
 
 	if n, ok := s.(*ast.TypeSwitchStmt); ok {
-		return map[string]interface{}{
+		return d.linkScope(map[string]interface{}{
 			"kind":     "statement",
 			"type":     "type-switch",
-			"init":     DumpStmt(n.Init, fset),
-			"assign":   DumpStmt(n.Assign, fset),
-			"body":     DumpBlock(n.Body, fset),
-			"position": DumpPosition(fset.Position(n.Pos())),
-		}
+			"init":     d.dumpStmt(n.Init),
+			"assign":   d.dumpStmt(n.Assign),
+			"body":     d.dumpBlock(n.Body),
+			"position": d.dumpPos(n.Pos()),
+			"end":      d.dumpPos(n.End()),
+		}, n)
 	}
 
 	if n, ok := s.(*ast.CommClause); ok {
 		stmts := make([]interface{}, len(n.Body))
 		for i, v := range n.Body {
-			stmts[i] = DumpStmt(v, fset)
+			stmts[i] = d.dumpStmt(v)
 		}
 
-		return map[string]interface{}{
+		return d.linkScope(map[string]interface{}{
 			"kind":      "statement",
 			"type":      "select-clause",
-			"statement": DumpStmt(n.Comm, fset),
+			"statement": d.dumpStmt(n.Comm),
 			"body":      stmts,
-			"position":  DumpPosition(fset.Position(n.Pos())),
-		}
+			"position":  d.dumpPos(n.Pos()),
+			"end":       d.dumpPos(n.End()),
+		}, n)
 
 	}
 
 	if n, ok := s.(*ast.CaseClause); ok {
 		exprs := make([]interface{}, len(n.Body))
 		for i, v := range n.Body {
-			exprs[i] = DumpStmt(v, fset)
+			exprs[i] = d.dumpStmt(v)
 		}
 
-		return map[string]interface{}{
+		res := d.linkScope(map[string]interface{}{
 			"kind":        "statement",
 			"type":        "case-clause",
-			"expressions": DumpExprs(n.List, fset),
+			"expressions": d.dumpExprs(n.List),
 			"body":        exprs,
-			"position":    DumpPosition(fset.Position(n.Pos())),
+			"position":    d.dumpPos(n.Pos()),
+			"end":         d.dumpPos(n.End()),
+		}, n)
+		// Type switches declare their variable in each clause.
+		if implicit := d.dumpImplicit(n); implicit != nil {
+			res["implicit"] = implicit
 		}
+		return res
 	}
 
 	if n, ok := s.(*ast.BadStmt); ok {
-		pos := fset.PositionFor(n.From, true)
-		Perish(pos, "internal_error", "encountered BadStmt")
+		if d.Recover {
+			return d.dumpBad("statement", n.From, n.To)
+		}
+		pos := d.Fset.PositionFor(n.From, true)
+		fail(pos, "internal_error", "encountered BadStmt")
 	}
 
 	typ := reflect.TypeOf(s).String()
-	pos := fset.PositionFor(s.Pos(), true)
-	Perish(pos, "unexpected_node", typ)
+	pos := d.Fset.PositionFor(s.Pos(), true)
+	fail(pos, "unexpected_node", typ)
 	panic("unreachable")
 }
 
-func DumpBlock(b *ast.BlockStmt, fset *token.FileSet) []interface{} {
+func (d *Dumper) dumpBlock(b *ast.BlockStmt) []interface{} {
 	if b == nil {
 		return nil
 	}
 	results := make([]interface{}, len(b.List))
 	for i, v := range b.List {
-		results[i] = DumpStmt(v, fset)
+		results[i] = d.dumpStmt(v)
 	}
 
 	return results
 }
 
-func DumpBlockAsStmt(b *ast.BlockStmt, fset *token.FileSet) map[string]interface{} {
-	return map[string]interface{}{
+func (d *Dumper) dumpBlockAsStmt(b *ast.BlockStmt) map[string]interface{} {
+	return d.linkScope(map[string]interface{}{
 		"kind":     "statement",
 		"type":     "block",
-		"body":     DumpBlock(b, fset),
-		"position": DumpPosition(fset.Position(b.Pos())),
-	}
+		"body":     d.dumpBlock(b),
+		"position": d.dumpPos(b.Pos()),
+		"end":      d.dumpPos(b.End()),
+	}, b)
 }
 
-// SIDE-EFFECT: if a variadic parameter is found, it is removed from
-// the original FieldList.
+// Remove a trailing variadic parameter from a parameter list and
+// return it.
+//
+// Deprecated: this modifies params; the Dumper uses splitVariadic,
+// which doesn't.
 func ExtractVariadic(params *ast.FieldList) *ast.Field {
+	rest, p := splitVariadic(params)
+	params.List = rest.List
+	return p
+}
+
+// Split a trailing variadic parameter off a parameter list. The
+// original FieldList is left untouched (so the same AST can be dumped
+// more than once); a copy without the variadic parameter is returned
+// instead.
+func splitVariadic(params *ast.FieldList) (*ast.FieldList, *ast.Field) {
 	ps := params.List
 	if len(ps) == 0 {
-		return nil
+		return params, nil
 	}
 	p := ps[len(ps)-1]
 	switch p.Type.(type) {
 	case *ast.Ellipsis:
-		params.List = params.List[:len(params.List)-1]
-		return p
+		rest := *params
+		rest.List = ps[:len(ps)-1]
+		return &rest, p
 	default:
-		return nil
+		return params, nil
 	}
 }
 
-func DumpFuncDecl(f *ast.FuncDecl, fset *token.FileSet) map[string]interface{} {
-	variadic := ExtractVariadic(f.Type.Params)
-	return map[string]interface{}{
-		"kind":     "decl",
-		"type":     "function",
-		"name":     DumpIdent(f.Name, fset),
-		"body":     DumpBlock(f.Body, fset),
-		"params":   DumpFields(f.Type.Params, fset),
-		"variadic": AttemptField(variadic, fset),
-		"results":  DumpFields(f.Type.Results, fset),
-		"comments": DumpCommentGroup(f.Doc, fset),
-		"position": DumpPosition(fset.Position(f.Pos())),
-	}
+func (d *Dumper) dumpFuncDecl(f *ast.FuncDecl) map[string]interface{} {
+	params, variadic := splitVariadic(f.Type.Params)
+	return d.linkScope(d.withSpecialization(map[string]interface{}{
+		"kind":        "decl",
+		"type":        "function",
+		"name":        d.dumpIdent(f.Name),
+		"body":        d.dumpBlock(f.Body),
+		"type-params": d.dumpFields(f.Type.TypeParams),
+		"params":      d.dumpFields(params),
+		"variadic":    d.attemptField(variadic),
+		"results":     d.dumpFields(f.Type.Results),
+		"comments":    d.dumpCommentGroup(f.Doc),
+		"position":    d.dumpPos(f.Pos()),
+		"end":         d.dumpPos(f.End()),
+	}, f), f.Type)
 }
 
-func DumpMethodDecl(f *ast.FuncDecl, fset *token.FileSet) map[string]interface{} {
-	variadic := ExtractVariadic(f.Type.Params)
-	return map[string]interface{}{
+func (d *Dumper) dumpMethodDecl(f *ast.FuncDecl) map[string]interface{} {
+	params, variadic := splitVariadic(f.Type.Params)
+	return d.linkScope(d.withSpecialization(map[string]interface{}{
 		"kind":     "decl",
 		"type":     "method",
-		"receiver": DumpField(f.Recv.List[0], fset),
-		"name":     DumpIdent(f.Name, fset),
-		"body":     DumpBlock(f.Body, fset),
-		"params":   DumpFields(f.Type.Params, fset),
-		"variadic": AttemptField(variadic, fset),
-		"results":  DumpFields(f.Type.Results, fset),
-		"comments": DumpCommentGroup(f.Doc, fset),
-		"position": DumpPosition(fset.Position(f.Pos())),
-	}
+		"receiver": d.dumpField(f.Recv.List[0]),
+		"name":     d.dumpIdent(f.Name),
+		"body":     d.dumpBlock(f.Body),
+		"params":   d.dumpFields(params),
+		"variadic": d.attemptField(variadic),
+		"results":  d.dumpFields(f.Type.Results),
+		"comments": d.dumpCommentGroup(f.Doc),
+		"position": d.dumpPos(f.Pos()),
+		"end":      d.dumpPos(f.End()),
+	}, f), f.Type)
 }
 
-func DumpDecl(n ast.Decl, fset *token.FileSet) map[string]interface{} {
+func (d *Dumper) dumpDecl(n ast.Decl) map[string]interface{} {
 	if decl, ok := n.(*ast.GenDecl); ok {
-		return DumpGenDecl(decl, fset)
+		return d.withComments(d.dumpGenDecl(decl), decl)
 	}
 
 	if decl, ok := n.(*ast.FuncDecl); ok {
 		if decl.Recv == nil {
-			return DumpFuncDecl(decl, fset)
+			return d.withComments(d.dumpFuncDecl(decl), decl)
 		} else {
-			return DumpMethodDecl(decl, fset)
+			return d.withComments(d.dumpMethodDecl(decl), decl)
 		}
 	}
 
 	if decl, ok := n.(*ast.BadDecl); ok {
-		pos := fset.PositionFor(decl.From, true)
-		Perish(pos, "internal_error", "encountered BadDecl")
+		if d.Recover {
+			return d.dumpBad("decl", decl.From, decl.To)
+		}
+		pos := d.Fset.PositionFor(decl.From, true)
+		fail(pos, "internal_error", "encountered BadDecl")
 	}
 
 	typ := reflect.TypeOf(n).String()
-	pos := fset.PositionFor(n.Pos(), true)
-	Perish(pos, "unexpected_node", typ)
+	pos := d.Fset.PositionFor(n.Pos(), true)
+	fail(pos, "unexpected_node", typ)
 	panic("unreachable")
 }
 
@@ -1336,50 +2063,284 @@ func IsImport(d ast.Decl) bool {
 	return false
 }
 
-// AST nodes will be decorated with type information provided by the
-// typeinfo argument if it's not nil.
+// The free functions below are goblin's original API, from before the
+// Dumper. They keep their signatures, and report errors with Perish as
+// they always did (exiting, or panicking if ShouldPanic is set). The
+// narrower ones dump without type information: they used to read that
+// of the last DumpFile from a global.
+
+// Deprecated: use NewDumper(fset, typeinfo).DumpFile, which returns an
+// error instead of exiting.
 func DumpFile(f *ast.File, path string, fset *token.FileSet, typeinfo *types.Info) map[string]interface{} {
-	tinfo = typeinfo
-	decls := []interface{}{}
-	imps := []interface{}{}
-	if f.Decls != nil {
-		var ii int
-		for ii = 0; ii < len(f.Decls); ii++ {
-			if !IsImport(f.Decls[ii]) {
-				break
-			}
-		}
+	defer orPerish()
+	return NewDumper(fset, typeinfo).dumpFile(f, path)
+}
 
-		imports := f.Decls[0:ii]
+// Deprecated: use Dumper.DumpExpr.
+func DumpExpr(e ast.Expr, fset *token.FileSet) map[string]interface{} {
+	defer orPerish()
+	return NewDumper(fset, nil).dumpExpr(e)
+}
 
-		decls = make([]interface{}, len(f.Decls))
-		for i, v := range f.Decls {
-			decls[i] = DumpDecl(v, fset)
-		}
+// Deprecated: use Dumper.DumpStmt.
+func DumpStmt(s ast.Stmt, fset *token.FileSet) interface{} {
+	defer orPerish()
+	return NewDumper(fset, nil).dumpStmt(s)
+}
+
+// Deprecated: use Dumper.DumpDecl.
+func DumpDecl(n ast.Decl, fset *token.FileSet) map[string]interface{} {
+	defer orPerish()
+	return NewDumper(fset, nil).dumpDecl(n)
+}
+
+// Deprecated: use Dumper.DumpInitializers.
+func DumpInitializers(fset *token.FileSet, typeinfo *types.Info) []map[string]interface{} {
+	defer orPerish()
+	return NewDumper(fset, typeinfo).dumpInitializers()
+}
+
+// Deprecated: use Dumper.DumpGoType. Without the FileSet, types
+// declared inside functions aren't qualified by their position, and
+// the named types tp refers to go into a table that is thrown away.
+func DumpGoType(tp types.Type) map[string]interface{} {
+	defer orPerish()
+	return NewDumper(token.NewFileSet(), nil).dumpGoType(tp)
+}
+
+// Deprecated: without type information this is always "NoKind"; use a
+// Dumper with the package's types.Info.
+func IdentKind(ident *ast.Ident) string {
+	return NewDumper(nil, nil).identKind(ident)
+}
+
+// Deprecated: use Dumper.DumpExpr.
+func DumpIdent(i *ast.Ident, fset *token.FileSet) map[string]interface{} {
+	defer orPerish()
+	return NewDumper(fset, nil).dumpIdent(i)
+}
+
+// Deprecated: use Dumper.DumpExpr.
+func DumpArray(a *ast.ArrayType, fset *token.FileSet) map[string]interface{} {
+	defer orPerish()
+	return NewDumper(fset, nil).dumpArray(a)
+}
+
+// Deprecated: use Dumper.DumpExpr.
+func AttemptExprAsType(e ast.Expr, fset *token.FileSet) map[string]interface{} {
+	defer orPerish()
+	return NewDumper(fset, nil).attemptExprAsType(e)
+}
 
-		imps = make([]interface{}, len(imports))
-		for i, v := range imports {
-			imps[i] = DumpDecl(v, fset)
+// Deprecated: use Dumper.DumpExpr.
+func DumpExprAsType(e ast.Expr, fset *token.FileSet) map[string]interface{} {
+	defer orPerish()
+	return NewDumper(fset, nil).dumpExprAsType(e)
+}
+
+// Deprecated: without type information there are no constants, so this
+// always returns nil; use Dumper.DumpExpr.
+func AttemptConst(e ast.Expr, fset *token.FileSet) map[string]interface{} {
+	defer orPerish()
+	return NewDumper(fset, nil).attemptConst(e)
+}
+
+// Deprecated: use Dumper.DumpExpr on each expression.
+func DumpExprs(exprs []ast.Expr, fset *token.FileSet) []interface{} {
+	defer orPerish()
+	return NewDumper(fset, nil).dumpExprs(exprs)
+}
+
+// Deprecated: use Dumper.DumpExpr.
+func DumpBasicLit(l *ast.BasicLit, fset *token.FileSet) map[string]interface{} {
+	defer orPerish()
+	return NewDumper(fset, nil).dumpBasicLit(l)
+}
+
+// Deprecated: use Dumper.DumpExpr.
+func DumpCall(c *ast.CallExpr, fset *token.FileSet) map[string]interface{} {
+	defer orPerish()
+	return NewDumper(fset, nil).dumpCall(c)
+}
+
+// Deprecated: use Dumper.DumpDecl on the declaration of the field.
+func AttemptField(f *ast.Field, fset *token.FileSet) map[string]interface{} {
+	defer orPerish()
+	return NewDumper(fset, nil).attemptField(f)
+}
+
+// Deprecated: use Dumper.DumpDecl on the declaration of the field.
+func DumpField(f *ast.Field, fset *token.FileSet) map[string]interface{} {
+	defer orPerish()
+	return NewDumper(fset, nil).dumpField(f)
+}
+
+// Deprecated: use Dumper.DumpDecl on the declaration of the fields.
+func DumpFields(fs *ast.FieldList, fset *token.FileSet) []map[string]interface{} {
+	defer orPerish()
+	return NewDumper(fset, nil).dumpFields(fs)
+}
+
+// Deprecated: use Dumper.DumpFile or Dumper.DumpDecl.
+func DumpCommentGroup(g *ast.CommentGroup, fset *token.FileSet) []string {
+	return NewDumper(fset, nil).dumpCommentGroup(g)
+}
+
+// Deprecated: use Dumper.DumpDecl.
+func DumpImport(spec *ast.ImportSpec, fset *token.FileSet) map[string]interface{} {
+	defer orPerish()
+	return NewDumper(fset, nil).dumpImport(spec)
+}
+
+// Deprecated: use Dumper.DumpDecl.
+func DumpValue(kind string, spec *ast.ValueSpec, fset *token.FileSet) map[string]interface{} {
+	defer orPerish()
+	return NewDumper(fset, nil).dumpValue(kind, spec)
+}
+
+// Deprecated: use Dumper.DumpDecl.
+func DumpGenDecl(decl *ast.GenDecl, fset *token.FileSet) map[string]interface{} {
+	defer orPerish()
+	return NewDumper(fset, nil).dumpGenDecl(decl)
+}
+
+// Deprecated: use Dumper.DumpStmt on each statement.
+func DumpBlock(b *ast.BlockStmt, fset *token.FileSet) []interface{} {
+	defer orPerish()
+	return NewDumper(fset, nil).dumpBlock(b)
+}
+
+// Deprecated: use Dumper.DumpStmt.
+func DumpBlockAsStmt(b *ast.BlockStmt, fset *token.FileSet) map[string]interface{} {
+	defer orPerish()
+	return NewDumper(fset, nil).dumpBlockAsStmt(b)
+}
+
+// Deprecated: use Dumper.DumpDecl.
+func DumpFuncDecl(f *ast.FuncDecl, fset *token.FileSet) map[string]interface{} {
+	defer orPerish()
+	return NewDumper(fset, nil).dumpFuncDecl(f)
+}
+
+// Deprecated: use Dumper.DumpDecl.
+func DumpMethodDecl(f *ast.FuncDecl, fset *token.FileSet) map[string]interface{} {
+	defer orPerish()
+	return NewDumper(fset, nil).dumpMethodDecl(f)
+}
+
+// Deprecated: use Dumper.DumpInitializers.
+func DumpInitializer(init *types.Initializer, fset *token.FileSet) map[string]interface{} {
+	defer orPerish()
+	return NewDumper(fset, nil).dumpInitializer(init)
+}
+
+// Report an error raised by fail with Perish, as the free functions
+// always have.
+func orPerish() {
+	if r := recover(); r != nil {
+		e, ok := r.(*Error)
+		if !ok {
+			panic(r)
 		}
+		Perish(e.Position, e.Type, e.Reason)
 	}
+}
+
+// Report an error returned by a Dumper method with Perish.
+func perishOn(err error) {
+	if err == nil {
+		return
+	}
+	if e, ok := err.(*Error); ok {
+		Perish(e.Position, e.Type, e.Reason)
+	}
+	Perish(INVALID_POSITION, "internal_error", err.Error())
+}
+
+// The exported Dumper methods report unsupported or malformed input
+// as an *Error rather than bailing out of the process.
+
+func (d *Dumper) DumpFile(f *ast.File, path string) (res map[string]interface{}, err error) {
+	defer catch(&err)
+	return d.dumpFile(f, path), nil
+}
+
+func (d *Dumper) DumpExpr(e ast.Expr) (res map[string]interface{}, err error) {
+	defer catch(&err)
+	return d.dumpExpr(e), nil
+}
+
+func (d *Dumper) DumpStmt(s ast.Stmt) (res interface{}, err error) {
+	defer catch(&err)
+	return d.dumpStmt(s), nil
+}
+
+func (d *Dumper) DumpDecl(n ast.Decl) (res map[string]interface{}, err error) {
+	defer catch(&err)
+	return d.dumpDecl(n), nil
+}
+
+// Initializers are dumped on a per-package basis.
+func (d *Dumper) DumpInitializers() (res []map[string]interface{}, err error) {
+	defer catch(&err)
+	return d.dumpInitializers(), nil
+}
+
+func (d *Dumper) DumpGoType(tp types.Type) (res map[string]interface{}, err error) {
+	defer catch(&err)
+	return d.dumpGoType(tp), nil
+}
+
+func (d *Dumper) dumpFile(f *ast.File, path string) map[string]interface{} {
+	d = d.forFile(f)
+	decls := make([]interface{}, len(f.Decls))
+	for i, v := range f.Decls {
+		decls[i] = d.dumpDecl(v)
+	}
+
+	imports := importDecls(f)
+	imps := make([]interface{}, len(imports))
+	for i, v := range imports {
+		imps[i] = d.dumpDecl(v)
+	}
+
+	res := d.dumpFileHeader(f, path)
+	res["declarations"] = decls
+	res["imports"] = imps
+	return res
+}
 
+// Everything about a file but its declarations.
+func (d *Dumper) dumpFileHeader(f *ast.File, path string) map[string]interface{} {
 	allComments := make([][]string, len(f.Comments))
 	for i, v := range f.Comments {
-		allComments[i] = DumpCommentGroup(v, fset)
+		allComments[i] = d.dumpCommentGroup(v)
 	}
 
-	return map[string]interface{}{
+	res := d.withComments(map[string]interface{}{
 		"kind":         "file",
 		"path":         path,
-		"package-name": DumpIdent(f.Name, fset),
-		"comments":     DumpCommentGroup(f.Doc, fset),
+		"package-name": d.dumpIdent(f.Name),
+		"comments":     d.dumpCommentGroup(f.Doc),
 		"all-comments": allComments,
-		"declarations": decls,
-		"imports":      imps,
+		"position":     d.dumpPos(f.Pos()),
+		"end":          d.dumpPos(f.End()),
+	}, f)
+	return d.linkScope(d.withFileTable(res, f), f)
+}
+
+// The import declarations at the start of a file.
+func importDecls(f *ast.File) []ast.Decl {
+	var ii int
+	for ii = 0; ii < len(f.Decls); ii++ {
+		if !IsImport(f.Decls[ii]) {
+			break
+		}
 	}
+	return f.Decls[0:ii]
 }
 
-func DumpInitializer(init *types.Initializer, fset *token.FileSet) map[string]interface{} {
+func (d *Dumper) dumpInitializer(init *types.Initializer) map[string]interface{} {
 	vars := make([]map[string]interface{}, len(init.Lhs))
 	for i, v := range init.Lhs {
 		ident := ast.Ident{
@@ -1390,25 +2351,33 @@ func DumpInitializer(init *types.Initializer, fset *token.FileSet) map[string]in
 		vars[i] = map[string]interface{}{
 			"kind":     "expression",
 			"type":     "identifier",
-			"value":    DumpIdent(&ident, fset),
-			"position": DumpPosition(fset.Position(v.Pos())),
+			"value":    d.dumpIdent(&ident),
+			"position": d.dumpPos(v.Pos()),
+			"end":      d.dumpPos(ident.End()),
 		}
 	}
 
+	// An initializer is not a node of the file; it spans from its first
+	// variable to the end of its value.
 	return map[string]interface{}{
-		"kind":  "statement",
-		"type":  "initializer",
-		"vars":  vars,
-		"value": DumpExpr(init.Rhs, fset),
+		"kind":     "statement",
+		"type":     "initializer",
+		"vars":     vars,
+		"value":    d.dumpExpr(init.Rhs),
+		"position": d.dumpPos(init.Lhs[0].Pos()),
+		"end":      d.dumpPos(init.Rhs.End()),
 	}
 }
 
-// Initializers are dumped on a per-package basis.
-func DumpInitializers(fset *token.FileSet, typeinfo *types.Info) []map[string]interface{} {
-	tinfo = typeinfo
-	initializers := make([]map[string]interface{}, len(tinfo.InitOrder))
-	for i, init := range tinfo.InitOrder {
-		initializers[i] = DumpInitializer(init, fset)
+func (d *Dumper) dumpInitializers() []map[string]interface{} {
+	// Packages that failed to typecheck may come without type
+	// information.
+	if d.Info == nil {
+		return []map[string]interface{}{}
+	}
+	initializers := make([]map[string]interface{}, len(d.Info.InitOrder))
+	for i, init := range d.Info.InitOrder {
+		initializers[i] = d.dumpInitializer(init)
 	}
 	return initializers
 }
@@ -1422,7 +2391,12 @@ func TestExpr(s string) map[string]interface{} {
 	}
 
 	// Inspect the AST and print all identifiers and literals.
-	return DumpExpr(f, fset)
+	res, err := NewDumper(fset, nil).DumpExpr(f)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	return res
 }
 
 func TestFile(p string) []byte {
@@ -1442,14 +2416,20 @@ func TestFile(p string) []byte {
 
 	fset.AddFile(p, -1, int(size))
 
-	f, err := parser.ParseFile(fset, p, nil, 0)
+	// Comments and all, like goblin --file.
+	f, err := parser.ParseFile(fset, p, nil, parser.ParseComments)
 
 	if err != nil {
 		panic(err.Error())
 	}
 
 	// Inspect the AST and print all identifiers and literals.
-	res, err := json.Marshal(DumpFile(f, p, fset, nil))
+	dumped, err := NewDumper(fset, nil).DumpFile(f, p)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	res, err := json.Marshal(dumped)
 
 	if err != nil {
 		panic(err.Error())
@@ -1467,7 +2447,12 @@ func TestStmt(s string) []byte {
 	}
 
 	// Inspect the AST and print all identifiers and literals.
-	res, err := json.Marshal(DumpFile(f, s, fset, nil))
+	dumped, err := NewDumper(fset, nil).DumpFile(f, s)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	res, err := json.Marshal(dumped)
 
 	if err != nil {
 		panic(err.Error())
@@ -1,16 +1,32 @@
 package goblin
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"go/types"
 	"io/ioutil"
 	"math"
 	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"runtime/metrics"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"testing/quick"
+	"time"
+
+	"golang.org/x/tools/go/packages"
 )
 
 // TODO: install github.com/stretchr/testify
@@ -36,56 +52,66 @@ type Fixture struct {
 	jsonPath string
 }
 
+var packageFixtures = []Fixture{
+	{
+		"helloworld",
+		"fixtures/packages/helloworld/helloworld.go",
+		"fixtures/packages/helloworld/helloworld.json",
+	},
+	{
+		"simple type alias",
+		"fixtures/packages/simpletypealias/simpletypealias.go",
+		"fixtures/packages/simpletypealias/simpletypealias.json",
+	},
+	{
+		"untyped top-level variable",
+		"fixtures/packages/untypedvar/untyped.go",
+		"fixtures/packages/untypedvar/untyped.json",
+	},
+	{
+		"qualified type in function argument",
+		"fixtures/packages/qualifiedtype/qualified.go",
+		"fixtures/packages/qualifiedtype/qualified.json",
+	},
+	{
+		"infinite for-loop",
+		"fixtures/packages/emptyfor/empty.go",
+		"fixtures/packages/emptyfor/empty.json",
+	},
+	{
+		"select statement",
+		"fixtures/packages/select/select.go",
+		"fixtures/packages/select/select.json",
+	},
+	{
+		"method declaration",
+		"fixtures/packages/methoddecl/method.go",
+		"fixtures/packages/methoddecl/method.json",
+	},
+	{
+		"map with interface type",
+		"fixtures/packages/interface_type/interface.go",
+		"fixtures/packages/interface_type/interface.json",
+	},
+	{
+		"empty function",
+		"fixtures/packages/emptyfunc/empty.go",
+		"fixtures/packages/emptyfunc/empty.json",
+	},
+	{
+		"generic types and functions",
+		"fixtures/packages/generics/generics.go",
+		"fixtures/packages/generics/generics.json",
+	},
+	{
+		"comments",
+		"fixtures/packages/comments/comments.go",
+		"fixtures/packages/comments/comments.json",
+	},
+}
+
 func TestPackageFixtures(t *testing.T) {
-	fixtures := []Fixture{
-		{
-			"helloworld",
-			"fixtures/packages/helloworld/helloworld.go",
-			"fixtures/packages/helloworld/helloworld.json",
-		},
-		{
-			"simple type alias",
-			"fixtures/packages/simpletypealias/simpletypealias.go",
-			"fixtures/packages/simpletypealias/simpletypealias.json",
-		},
-		{
-			"untyped top-level variable",
-			"fixtures/packages/untypedvar/untyped.go",
-			"fixtures/packages/untypedvar/untyped.json",
-		},
-		{
-			"qualified type in function argument",
-			"fixtures/packages/qualifiedtype/qualified.go",
-			"fixtures/packages/qualifiedtype/qualified.json",
-		},
-		{
-			"infinite for-loop",
-			"fixtures/packages/emptyfor/empty.go",
-			"fixtures/packages/emptyfor/empty.json",
-		},
-		{
-			"select statement",
-			"fixtures/packages/select/select.go",
-			"fixtures/packages/select/select.json",
-		},
-		{
-			"method declaration",
-			"fixtures/packages/methoddecl/method.go",
-			"fixtures/packages/methoddecl/method.json",
-		},
-		{
-			"map with interface type",
-			"fixtures/packages/interface_type/interface.go",
-			"fixtures/packages/interface_type/interface.json",
-		},
-		{
-			"empty function",
-			"fixtures/packages/emptyfunc/empty.go",
-			"fixtures/packages/emptyfunc/empty.json",
-		},
-	}
-
-	for _, fix := range fixtures {
+	for _, fix := range packageFixtures {
 		got := TestFile(fix.goPath)
 		want, _ := ioutil.ReadFile(fix.jsonPath)
 
@@ -108,51 +134,56 @@ func TestPackageFixtures(t *testing.T) {
 	}
 }
 
+var expressionFixtures = []Fixture{
+	{
+		"cast to array",
+		"fixtures/expressions/slicecast/slice.go.txt",
+		"fixtures/expressions/slicecast/slice.json",
+	},
+	{
+		"cast to pointer",
+		"fixtures/expressions/ptrcast/ptr.go.txt",
+		"fixtures/expressions/ptrcast/ptr.json",
+	},
+	{
+		"map literal",
+		"fixtures/expressions/mapliteral/map.go.txt",
+		"fixtures/expressions/mapliteral/map.json",
+	},
+	{
+		"single qualifier",
+		"fixtures/expressions/singlequalifier/single.go.txt",
+		"fixtures/expressions/singlequalifier/single.json",
+	},
+	{
+		"double qualifier",
+		"fixtures/expressions/doublequalifier/double.go.txt",
+		"fixtures/expressions/doublequalifier/double.json",
+	},
+	{
+		"cast to chan",
+		"fixtures/expressions/chancast/chan.go.txt",
+		"fixtures/expressions/chancast/chan.json",
+	},
+	{
+		"cast with parenthesized type",
+		"fixtures/expressions/parenintype/paren.go.txt",
+		"fixtures/expressions/parenintype/paren.json",
+	},
+	{
+		"adding two identifiers",
+		"fixtures/expressions/addition/addition.go.txt",
+		"fixtures/expressions/addition/addition.json",
+	},
+	{
+		"explicit instantiation",
+		"fixtures/expressions/instantiation/instantiation.go.txt",
+		"fixtures/expressions/instantiation/instantiation.json",
+	},
+}
+
 func TestExpressionFixtures(t *testing.T) {
-	fixtures := []Fixture{
-		{
-			"cast to array",
-			"fixtures/expressions/slicecast/slice.go.txt",
-			"fixtures/expressions/slicecast/slice.json",
-		},
-		{
-			"cast to pointer",
-			"fixtures/expressions/ptrcast/ptr.go.txt",
-			"fixtures/expressions/ptrcast/ptr.json",
-		},
-		{
-			"map literal",
-			"fixtures/expressions/mapliteral/map.go.txt",
-			"fixtures/expressions/mapliteral/map.json",
-		},
-		{
-			"single qualifier",
-			"fixtures/expressions/singlequalifier/single.go.txt",
-			"fixtures/expressions/singlequalifier/single.json",
-		},
-		{
-			"double qualifier",
-			"fixtures/expressions/doublequalifier/double.go.txt",
-			"fixtures/expressions/doublequalifier/double.json",
-		},
-		{
-			"cast to chan",
-			"fixtures/expressions/chancast/chan.go.txt",
-			"fixtures/expressions/chancast/chan.json",
-		},
-		{
-			"cast with parenthesized type",
-			"fixtures/expressions/parenintype/paren.go.txt",
-			"fixtures/expressions/parenintype/paren.json",
-		},
-		{
-			"adding two identifiers",
-			"fixtures/expressions/addition/addition.go.txt",
-			"fixtures/expressions/addition/addition.json",
-		},
-	}
-
-	for _, fix := range fixtures {
+	for _, fix := range expressionFixtures {
 		gotBytes, _ := ioutil.ReadFile(fix.goPath)
 		got := TestExpr(string(gotBytes))
 		want, _ := ioutil.ReadFile(fix.jsonPath)
@@ -171,59 +202,2239 @@ func TestExpressionFixtures(t *testing.T) {
 	}
 }
 
-func TestIota(t *testing.T) {
-	got := TestExpr("iota")
-	val := got["value"].(map[string]interface{})
-	if val["type"] != "IOTA" {
-		t.Error("Didn't parse iota as a literal")
+// Go -> JSON -> Go -> JSON should give back the JSON we started with,
+// both with and without positions. Positions themselves aren't
+// compared, since the printer is free to lay things out differently,
+// and neither are comments without them, since there's no telling
+// where they went.
+func TestRebuildFixtures(t *testing.T) {
+	for _, fix := range packageFixtures {
+		for _, keep := range []bool{true, false} {
+			want := normalize(t, TestFile(fix.goPath), keep)
+			fset := token.NewFileSet()
+			f, err := RebuildFile(want, fset)
+			if err != nil {
+				t.Fatalf("%s: %v", fix.name, err)
+			}
+			var src bytes.Buffer
+			if err := printConfig.Fprint(&src, fset, f); err != nil {
+				t.Fatalf("%s: %v", fix.name, err)
+			}
+
+			fset = token.NewFileSet()
+			f, err = parser.ParseFile(fset, fix.goPath, src.Bytes(), parser.ParseComments)
+			if err != nil {
+				t.Fatalf("%s: %v\n%s", fix.name, err, src.Bytes())
+			}
+			dumped, err := NewDumper(fset, nil).DumpFile(f, fix.goPath)
+			if err != nil {
+				t.Fatalf("%s: %v", fix.name, err)
+			}
+			got, _ := json.Marshal(dumped)
+			gotN, wantN := normalize(t, got, false), normalize(t, TestFile(fix.goPath), false)
+			if !keep {
+				stripComments(gotN)
+				stripComments(wantN)
+			}
+			if !reflect.DeepEqual(gotN, wantN) {
+				t.Errorf("round trip failed: %s (positions: %v)\n%s", fix.name, keep, src.Bytes())
+			}
+		}
+	}
+
+	for _, fix := range expressionFixtures {
+		in, _ := ioutil.ReadFile(fix.goPath)
+		orig, _ := json.Marshal(TestExpr(string(in)))
+		want := normalize(t, orig, false)
+		e, err := RebuildExpr(want, token.NewFileSet())
+		if err != nil {
+			t.Fatalf("%s: %v", fix.name, err)
+		}
+		var src bytes.Buffer
+		if err := printConfig.Fprint(&src, token.NewFileSet(), e); err != nil {
+			t.Fatalf("%s: %v", fix.name, err)
+		}
+		got, _ := json.Marshal(TestExpr(src.String()))
+		if !reflect.DeepEqual(normalize(t, got, false), want) {
+			t.Errorf("round trip failed: %s\n%s", fix.name, src.Bytes())
+		}
 	}
 }
 
-func TestTrue(t *testing.T) {
-	got := TestExpr("true")
-	if got["type"] != "BOOL" || got["value"] != "true" {
-		t.Error("Didn't parse 'true' as true")
+// Type information is ignored when rebuilding, so a typed dump gives
+// the same source as an untyped one (as long as it has no constants,
+// which are folded).
+func TestRebuildTyped(t *testing.T) {
+	path := "fixtures/packages/generics/generics.go"
+	src, _ := ioutil.ReadFile(path)
+	typed, _ := json.Marshal(dumpTyped(t, path, src))
+
+	var printed []string
+	for _, dumped := range [][]byte{typed, TestFile(path)} {
+		fset := token.NewFileSet()
+		f, err := RebuildFile(normalize(t, dumped, true), fset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if err := printConfig.Fprint(&out, fset, f); err != nil {
+			t.Fatal(err)
+		}
+		printed = append(printed, out.String())
+	}
+	if printed[0] != printed[1] {
+		t.Errorf("typed and untyped dumps rebuilt differently:\n%s\n%s", printed[0], printed[1])
 	}
 }
 
-func TestFalse(t *testing.T) {
-	got := TestExpr("false")
-	if got["type"] != "BOOL" || got["value"] != "false" {
-		t.Error("Didn't parse 'false' as false")
+// Every dump of the fixtures, typed or not, must conform to the schema,
+// and so must their JSON files.
+func TestSchema(t *testing.T) {
+	valid := func(name string, v interface{}, definition string) {
+		t.Helper()
+		for _, e := range ValidateAs(v, definition) {
+			t.Errorf("%s: %v", name, e)
+		}
 	}
+	committed := func(fix Fixture) interface{} {
+		t.Helper()
+		data, err := ioutil.ReadFile(fix.jsonPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v interface{}
+		if err := json.Unmarshal(data, &v); err != nil {
+			t.Fatalf("%s: %v", fix.jsonPath, err)
+		}
+		return v
+	}
+
+	for _, fix := range packageFixtures {
+		var untyped interface{}
+		if err := json.Unmarshal(TestFile(fix.goPath), &untyped); err != nil {
+			t.Fatal(err)
+		}
+		valid(fix.name, untyped, "file")
+		valid(fix.jsonPath, committed(fix), "file")
+
+		typed, table := dumpTypedTable(t, fix.goPath, nil)
+		valid(fix.name+" (typed)", typed, "file")
+		for id, entry := range table {
+			valid(id, entry, "named-type")
+		}
+	}
+	for _, fix := range expressionFixtures {
+		src, _ := ioutil.ReadFile(fix.goPath)
+		valid(fix.name, TestExpr(string(src)), "expression")
+		valid(fix.jsonPath, committed(fix), "expression")
+	}
+
+	// Bad nodes and diagnostics.
+	fset := token.NewFileSet()
+	f, _ := parser.ParseFile(fset, "half.go", "package main\n\nfunc main() {\n\tx := 1 +\n}\n", parser.AllErrors)
+	d := NewDumper(fset, nil)
+	d.Recover = true
+	recovered, err := d.DumpFile(f, "half.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid("recovered", recovered, "file")
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.go")
+	src := "package main\n\nvar x, y = f()\n\nfunc f() (int, string) { return 1, 2 }\n\nfunc main() { println(x, y) }\n"
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := (&Config{Diagnostics: true}).Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid("Load", loaded, "")
+	valid("Load", loaded, "load")
 }
 
-func TestProvidedImag(t *testing.T) {
-	got := TestExpr("1.414i")
-	if got["type"] != "IMAG" || got["value"] != "1.414i" {
-		t.Error("Imaginary numbers not parsing correctly")
+func TestSchemaErrors(t *testing.T) {
+	node := TestExpr("a + f(b)")
+	node["right"].(map[string]interface{})["ellipsis"] = "no"
+	delete(node, "operator")
+	node["extra"] = true
+
+	var reasons []string
+	for _, e := range Validate(node) {
+		if e.Type != "schema_error" {
+			t.Errorf("wrong error type: %v", e)
+		}
+		reasons = append(reasons, e.Reason)
+	}
+	want := []string{
+		`#: missing key "operator"`,
+		`#: unexpected key "extra"`,
+		`#/right/ellipsis: expected boolean, got string`,
 	}
+	if !reflect.DeepEqual(reasons, want) {
+		t.Errorf("wrong schema errors:\n%s", strings.Join(reasons, "\n"))
+	}
+
+	unknown := map[string]interface{}{"kind": "expression", "type": "sum"}
+	if errs := Validate(unknown); len(errs) != 1 || !strings.Contains(errs[0].Reason, "unexpected node") {
+		t.Errorf("unknown node not rejected: %v", errs)
+	}
+
+	// Packed positions can't be negative.
+	negative := TestExpr("a")
+	negative["position"] = -1
+	if errs := Validate(negative); len(errs) != 1 || !strings.Contains(errs[0].Reason, "expected at least 0") {
+		t.Errorf("negative position not rejected: %v", errs)
+	}
+
+	// A keyword the validator doesn't implement isn't ignored.
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("unsupported keyword accepted")
+			}
+		}()
+		checkKeywords(map[string]interface{}{"type": "string", "maxLength": 3.0}, "#")
+	}()
 }
 
-func TestProvidedFloat(t *testing.T) {
-	got := TestExpr("3.14")
-	if got["value"].(string) != "3.14" {
-		t.Error("Floats not parsing correctly")
+// Unmarshaling a dump into the node structs and marshaling it again
+// must give back the same JSON.
+func TestNodeStructs(t *testing.T) {
+	roundTrip := func(name string, dumped interface{}, into interface{}) {
+		t.Helper()
+		want, _ := json.Marshal(dumped)
+		if err := json.Unmarshal(want, into); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, err := json.Marshal(into)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		var gotJ, wantJ interface{}
+		json.Unmarshal(got, &gotJ)
+		json.Unmarshal(want, &wantJ)
+		if !reflect.DeepEqual(gotJ, wantJ) {
+			t.Errorf("%s: round trip through the node structs failed:\n%s\n%s", name, want, got)
+		}
+	}
+
+	for _, fix := range packageFixtures {
+		var untyped interface{}
+		json.Unmarshal(TestFile(fix.goPath), &untyped)
+		roundTrip(fix.name, untyped, &FileNode{})
+
+		typed, table := dumpTypedTable(t, fix.goPath, nil)
+		roundTrip(fix.name+" (typed)", typed, &FileNode{})
+		for id, entry := range table {
+			roundTrip(id, entry, &NamedType{})
+		}
+	}
+	for _, fix := range expressionFixtures {
+		src, _ := ioutil.ReadFile(fix.goPath)
+		roundTrip(fix.name, TestExpr(string(src)), &Expr{})
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.go")
+	src := "package main\n\nvar x, y = f()\n\nfunc f() (int, string) { return 1, \"2\" }\n\nfunc main() { println(x, y + 1) }\n"
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := (&Config{Diagnostics: true}).Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res LoadResult
+	roundTrip("Load", loaded, &res)
+	if res.SchemaVersion != SchemaVersion {
+		t.Errorf("expected schema version %d, got %d", SchemaVersion, res.SchemaVersion)
+	}
+
+	// The structs can be navigated without type assertions on maps.
+	main := res.Package.Files[0].Declarations[2].Node.(*FuncDeclNode)
+	call := main.Body[0].Node.(*ExprStmtNode).Value.Node.(*CallNode)
+	arg := call.Arguments[1].Node.(*BinaryNode)
+	if arg.Left.Node.(*IdentifierNode).Value.Node.(*IdentNode).IdentKind != "Var" || arg.Position.Line != 7 {
+		t.Errorf("wrong argument: %+v", arg)
+	}
+	if len(res.Diagnostics) != 1 || res.Diagnostics[0].Type != "type_error" {
+		t.Errorf("expected a type error, got %v", res.Diagnostics)
+	}
+
+	var e Expr
+	if err := json.Unmarshal([]byte(`{"kind": "statement", "type": "return"}`), &e); err == nil {
+		t.Error("statement unmarshaled as an expression")
 	}
 }
 
-func TestCall(t *testing.T) {
-	got := TestExpr("foo(bar)")
-	if got["type"].(string) != "call" {
-		t.Error("Function calls not parsing correctly")
+// The streaming encoders write exactly what json.Marshal does for the
+// maps.
+func TestEncoders(t *testing.T) {
+	for _, fix := range packageFixtures {
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, fix.goPath, nil, parser.ParseComments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := NewDumper(fset, nil)
+		dumped, err := d.DumpFile(f, fix.goPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _ := json.Marshal(dumped)
+		var got bytes.Buffer
+		if err := d.EncodeFile(&got, f, fix.goPath); err != nil {
+			t.Fatal(err)
+		}
+		if got.String() != string(want) {
+			t.Errorf("%s: encoded file differs:\n%s\n%s", fix.name, want, got.Bytes())
+		}
 	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.go")
+	src := `package main
+
+import "unicode/utf8"
+
+type Pair[K, V comparable] struct {
+	Key K
+	Val V
 }
 
-func TestRoundTripUInt(t *testing.T) {
-	f := func(ui uint64) bool {
-		want := fmt.Sprintf("%d", ui)
-		got := TestExpr(want)
-		return want == got["value"]
+var p = Pair[string, int]{"héllo", utf8.RuneCountInString("héllo")}
+
+func main() { println(p.Key, p.Val) }
+`
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := json.Marshal(loaded)
+	var got bytes.Buffer
+	if err := LoadTo(&got, path); err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != string(want) {
+		t.Errorf("LoadTo differs from Load")
 	}
 
-	if err := quick.Check(f, nil); err != nil {
-		t.Error(err)
+	// Errors are reported as by the map-based path.
+	fset := token.NewFileSet()
+	f, _ := parser.ParseFile(fset, "half.go", "package main\n\nfunc main() {\n\tx := 1 +\n}\n", parser.AllErrors)
+	err = NewDumper(fset, nil).EncodeFile(ioutil.Discard, f, "half.go")
+	if e, ok := err.(*Error); !ok || e.Type != "internal_error" {
+		t.Errorf("expected an internal_error for the BadExpr, got %v", err)
+	}
+	err = NewDumper(fset, nil).EncodeFile(failingWriter{}, f, "half.go")
+	if e, ok := err.(*Error); !ok || e.Type != "internal_error" {
+		t.Errorf("expected the BadExpr to be reported first, got %v", err)
+	}
+	f, _ = parser.ParseFile(fset, "ok.go", "package main\n\nfunc main() {}\n", 0)
+	err = NewDumper(fset, nil).EncodeFile(failingWriter{}, f, "ok.go")
+	if e, ok := err.(*Error); !ok || e.Type != "write_error" {
+		t.Errorf("expected a write_error, got %v", err)
+	}
+}
+
+// CBOR and MessagePack dumps decode to the same trees as the JSON ones.
+func TestBinaryFormats(t *testing.T) {
+	decode := func(t *testing.T, data []byte, format Format) interface{} {
+		v, err := Decode(bytes.NewReader(data), format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		return v
+	}
+
+	for _, fix := range packageFixtures {
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, fix.goPath, nil, parser.ParseComments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want interface{}
+		for _, format := range []Format{JSON, CBOR, MessagePack} {
+			d := NewDumper(fset, nil)
+			d.Format = format
+			var buf bytes.Buffer
+			if err := d.EncodeFile(&buf, f, fix.goPath); err != nil {
+				t.Fatal(err)
+			}
+			got := decode(t, buf.Bytes(), format)
+			if want == nil {
+				want = got
+			} else if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: %s dump differs from JSON", fix.name, format)
+			}
+		}
+	}
+
+	pkgs, err := import_packages([]string{"unicode/utf8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want interface{}
+	for _, format := range []Format{JSON, CBOR, MessagePack} {
+		d := &Dumper{Types: NewTypeTable(), Format: format}
+		var pkg, table bytes.Buffer
+		if err := d.EncodePackage(&pkg, pkgs[0]); err != nil {
+			t.Fatal(err)
+		}
+		if err := Encode(&table, d.Types.Dump(), format); err != nil {
+			t.Fatal(err)
+		}
+		got := []interface{}{decode(t, pkg.Bytes(), format), decode(t, table.Bytes(), format)}
+		if want == nil {
+			want = got
+		} else if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s package differs from JSON", format)
+		}
+	}
+
+	// Values that aren't dumped as such but can still be encoded.
+	odd := map[string]interface{}{
+		"a":       []interface{}{1, -2, "x", true, nil},
+		"numbers": []interface{}{uint64(math.MaxUint64), int64(math.MinInt64), 1e300, -0.5, float32(1.5), float32(0.1), -33, -129, 70000},
+		"strings": []string{"", "\xffbad", strings.Repeat("long ", 100)},
+		"nil":     []map[string]interface{}(nil),
+		"struct":  struct{ X int }{3},
+		"bytes":   []byte("hi"),
+	}
+	data, _ := json.Marshal(odd)
+	json.Unmarshal(data, &want)
+	for _, format := range []Format{CBOR, MessagePack} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, odd, format); err != nil {
+			t.Fatal(err)
+		}
+		if got := decode(t, buf.Bytes(), format); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, expected %v", format, got, want)
+		}
+	}
+
+	// Checked against RFC 8949 and the MessagePack spec by hand.
+	small := map[string]interface{}{"a": []interface{}{1, -2, "x", true, nil}}
+	for format, want := range map[Format]string{
+		CBOR:        "a1616185012161 78f5f6",
+		MessagePack: "81a16195 01fea178c3c0",
+	} {
+		var buf bytes.Buffer
+		Encode(&buf, small, format)
+		if got := hex.EncodeToString(buf.Bytes()); got != strings.ReplaceAll(want, " ", "") {
+			t.Errorf("%s: got %s, expected %s", format, got, want)
+		}
+	}
+
+	if _, err := Decode(strings.NewReader("\xa1\x01\x02"), CBOR); err == nil {
+		t.Errorf("expected an error for a map key that isn't a string")
+	}
+	if _, err := Decode(strings.NewReader("\x92\x01"), MessagePack); err == nil {
+		t.Errorf("expected an error for a truncated array")
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Errorf("expected an error for an unknown format")
+	}
+}
+
+// Protobuf dumps hold the same trees as the JSON ones, except that
+// kinds and types are implied by messages and null, zero and empty
+// values are left out.
+func TestProtoFormat(t *testing.T) {
+	// Every definition in the schema has a message.
+	defs := loadSchema()["definitions"].(map[string]interface{})
+	for name := range defs {
+		protoMessageFor(name)
+	}
+	if len(loadProto()) != len(defs) {
+		t.Errorf("%d messages for %d definitions", len(loadProto()), len(defs))
+	}
+	// goblin.proto is kept in step with the schema by hand, so each
+	// property that isn't a constant must have a field.
+	for name, def := range defs {
+		m := protoMessageFor(name)
+		props, _ := def.(map[string]interface{})["properties"].(map[string]interface{})
+		for key, p := range props {
+			if _, ok := p.(map[string]interface{})["const"]; !ok && m.byKey[key] == nil {
+				t.Errorf("message %s has no field for %q", m.name, key)
+			}
+		}
+	}
+
+	for _, fix := range packageFixtures {
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, fix.goPath, nil, parser.ParseComments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := NewDumper(fset, nil)
+		want, err := d.DumpFile(f, fix.goPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.Format = Proto
+		var buf bytes.Buffer
+		if err := d.EncodeFile(&buf, f, fix.goPath); err != nil {
+			t.Fatal(err)
+		}
+		compareProto(t, fix.name, buf.Bytes(), protoMessageFor("file"), want)
+	}
+
+	pkgs, err := import_packages([]string{"unicode/utf8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &Dumper{Types: NewTypeTable(), Format: Proto}
+	want, err := d.DumpPackage(pkgs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := d.EncodePackage(&buf, pkgs[0]); err != nil {
+		t.Fatal(err)
+	}
+	compareProto(t, "unicode/utf8", buf.Bytes(), protoMessageFor("package"), want)
+
+	path := filepath.Join(t.TempDir(), "main.go")
+	src := `package main
+
+type Pair[K, V comparable] struct {
+	Key K
+	Val V
+}
+
+func (p Pair[K, V]) Swap() Pair[V, K] { return Pair[V, K]{p.Val, p.Key} }
+
+var p = Pair[string, int]{"a", -1}
+
+func main() { println(p.Swap().Key + "") }
+`
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf := &Config{Diagnostics: true}
+	loaded, err := conf.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.Format = Proto
+	buf.Reset()
+	if err := conf.LoadTo(&buf, path); err != nil {
+		t.Fatal(err)
+	}
+	compareProto(t, "Load", buf.Bytes(), protoMessageFor("load"), loaded)
+
+	// Other values go in the message of the schema's definition for
+	// them.
+	expr, _ := parser.ParseExpr(`f(x.y, []int{1, 2}...)`)
+	dumped, _ := NewDumper(token.NewFileSet(), nil).DumpExpr(expr)
+	buf.Reset()
+	if err := Encode(&buf, dumped, Proto); err != nil {
+		t.Fatal(err)
+	}
+	compareProto(t, "expression", buf.Bytes(), protoMessageFor("expression"), dumped)
+
+	// Checked by hand: field 10 of Expression is an
+	// ExpressionIdentifier, whose field 2 is a Name holding an Ident
+	// (field 1) with the ident-kind "NoKind" and value "x". The
+	// positions and ends are empty messages, since all their fields
+	// are zero.
+	expr, _ = parser.ParseExpr(`x`)
+	dumped, _ = NewDumper(token.NewFileSet(), nil).DumpExpr(expr)
+	buf.Reset()
+	Encode(&buf, dumped, Proto)
+	if got := hex.EncodeToString(buf.Bytes()); got != "521712110a0f0a064e6f4b696e641201781a003a001a002a00" {
+		t.Errorf("unexpected encoding of x: %s", got)
+	}
+
+	if err := Encode(ioutil.Discard, map[string]interface{}{"kind": "nonsense"}, Proto); err == nil {
+		t.Errorf("expected an error for an unknown node")
+	}
+	if _, err := Decode(&buf, Proto); err == nil {
+		t.Errorf("expected an error for decoding protobuf")
+	}
+}
+
+func compareProto(t *testing.T, name string, data []byte, m *protoMessage, want interface{}) {
+	t.Helper()
+	js, _ := json.Marshal(want)
+	json.Unmarshal(js, &want)
+	got := decodeProto(t, m, data)
+	if !reflect.DeepEqual(normalizeProto(got), normalizeProto(want)) {
+		t.Errorf("%s: protobuf dump differs from JSON", name)
+	}
+}
+
+// Decode a message of goblin.proto into the shape of a JSON dump.
+func decodeProto(t *testing.T, m *protoMessage, data []byte) interface{} {
+	obj := map[string]interface{}{}
+	for k, c := range m.consts {
+		obj[k] = c
+	}
+	list := []interface{}{}
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		data = data[n:]
+		var f *protoField
+		for _, g := range m.fields {
+			if g.number == int(tag>>3) {
+				f = g
+			}
+		}
+		if f == nil || n <= 0 {
+			t.Fatalf("%s: unexpected tag %d", m.name, tag)
+		}
+
+		var v interface{}
+		switch tag & 7 {
+		case 0:
+			x, n := binary.Uvarint(data)
+			switch f.typ {
+			case "bool":
+				v = x == 1
+			case "int64":
+				v = float64(int64(x))
+			case "sint64":
+				y, _ := binary.Varint(data)
+				v = float64(y)
+			}
+			data = data[n:]
+		case 2:
+			size, n := binary.Uvarint(data)
+			payload := data[n : n+int(size)]
+			data = data[n+int(size):]
+			switch {
+			case f.typ == "string":
+				v = string(payload)
+			case f.isMap:
+				entry := &protoMessage{fields: []*protoField{
+					{key: "key", number: 1, typ: "string"},
+					{key: "value", number: 2, typ: f.typ, msg: f.msg},
+				}}
+				e := decodeProto(t, entry, payload).(map[string]interface{})
+				table, _ := obj[f.key].(map[string]interface{})
+				if table == nil {
+					table = map[string]interface{}{}
+				}
+				table[e["key"].(string)] = e["value"]
+				obj[f.key] = table
+				continue
+			default:
+				v = decodeProto(t, f.msg, payload)
+			}
+		default:
+			t.Fatalf("%s: unexpected wire type %d", m.name, tag&7)
+		}
+
+		switch {
+		case m.oneof, f == m.bare:
+			return v
+		case m.list:
+			list = append(list, v)
+		case f.repeated:
+			items, _ := obj[f.key].([]interface{})
+			obj[f.key] = append(items, v)
+		default:
+			obj[f.key] = v
+		}
+	}
+	if m.list {
+		return list
+	}
+	return obj
+}
+
+// Drop the values that protobuf doesn't write.
+func normalizeProto(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		res := map[string]interface{}{}
+		for k, val := range x {
+			val = normalizeProto(val)
+			switch y := val.(type) {
+			case nil:
+				continue
+			case []interface{}:
+				if len(y) == 0 {
+					continue
+				}
+			}
+			if val == "" || val == false || val == 0.0 {
+				continue
+			}
+			res[k] = val
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(x))
+		for i, val := range x {
+			res[i] = normalizeProto(val)
+		}
+		return res
+	}
+	return v
+}
+
+// S-expression dumps read back as the same trees as the JSON ones.
+func TestSexpFormat(t *testing.T) {
+	roundTrip := func(name string, data []byte, want interface{}) {
+		t.Helper()
+		js, _ := json.Marshal(want)
+		json.Unmarshal(js, &want)
+		got, err := Decode(bytes.NewReader(data), Sexp)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: s-expression dump differs from JSON:\n%s", name, data)
+		}
+	}
+
+	for _, fix := range packageFixtures {
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, fix.goPath, nil, parser.ParseComments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := NewDumper(fset, nil)
+		want, err := d.DumpFile(f, fix.goPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.Format = Sexp
+		var buf bytes.Buffer
+		if err := d.EncodeFile(&buf, f, fix.goPath); err != nil {
+			t.Fatal(err)
+		}
+		roundTrip(fix.name, buf.Bytes(), want)
+	}
+
+	path := filepath.Join(t.TempDir(), "main.go")
+	src := `package main
+
+type Pair[K, V comparable] struct {
+	Key K
+	Val V
+}
+
+var p = Pair[string, int]{"a\\b", -1}
+
+func main() { println(p.Key + 1) }
+`
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf := &Config{Diagnostics: true}
+	loaded, err := conf.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.Format = Sexp
+	var buf bytes.Buffer
+	if err := conf.LoadTo(&buf, path); err != nil {
+		t.Fatal(err)
+	}
+	roundTrip("Load", buf.Bytes(), loaded)
+
+	odd := map[string]interface{}{
+		"kind":      "not a symbol",
+		"type":      3,
+		"weird key": []interface{}{nil, true, "(\"quoted\")\n", -1.5e300, uint64(math.MaxUint64)},
+		"nested":    []interface{}{[]interface{}{}, map[string]interface{}{}, []string{"-1", "nil"}},
+		"inf":       map[string]interface{}{"kind": "-Inf", "type": "_"},
+	}
+	buf.Reset()
+	if err := Encode(&buf, odd, Sexp); err != nil {
+		t.Fatal(err)
+	}
+	roundTrip("odd values", buf.Bytes(), odd)
+
+	expr, _ := parser.ParseExpr(`-x`)
+	dumped, _ := NewDumper(token.NewFileSet(), nil).DumpExpr(expr)
+	buf.Reset()
+	Encode(&buf, dumped, Sexp)
+	pos := `(_ _ :column 0 :filename "" :line 0 :offset 0)`
+	want := `(expression unary :end ` + pos + ` :operator "-" :position ` + pos + ` :target (expression identifier :end ` + pos +
+		` :position ` + pos + ` :value (ident _ :end ` + pos + ` :ident-kind "NoKind" :position ` + pos + ` :value "x")))`
+	if buf.String() != want {
+		t.Errorf("got %s, expected %s", buf.Bytes(), want)
+	}
+
+	for _, bad := range []string{"(a", "(_ _ x 1)", "(a)", ")", `("x`} {
+		if _, err := Decode(strings.NewReader(bad), Sexp); err == nil {
+			t.Errorf("expected an error for %s", bad)
+		}
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, fmt.Errorf("disk full")
+}
+
+// Emitting go/types and its dependencies (loaded once up front) as
+// maps and then marshaling them, as opposed to streaming them. Run
+// with -bench Emit -benchmem; peak-heap-MB is the most the live heap
+// grew by while emitting.
+func BenchmarkEmitMap(b *testing.B) {
+	pkgs := loadBenchPackages(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	reportPeakHeap(b, func() {
+		for i := 0; i < b.N; i++ {
+			d := &Dumper{Types: NewTypeTable()}
+			dumped, err := d.DumpPackages(pkgs)
+			if err != nil {
+				b.Fatal(err)
+			}
+			res, err := json.Marshal(map[string]interface{}{"imports": dumped, "types": d.Types.Dump()})
+			if err != nil {
+				b.Fatal(err)
+			}
+			ioutil.Discard.Write(res)
+		}
+	})
+}
+
+func BenchmarkEmitStream(b *testing.B) {
+	pkgs := loadBenchPackages(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	reportPeakHeap(b, func() {
+		for i := 0; i < b.N; i++ {
+			d := &Dumper{Types: NewTypeTable()}
+			s := newStream(ioutil.Discard, JSON)
+			s.object(nil, map[string]func(){
+				"imports": func() {
+					s.array(len(pkgs), func(i int) {
+						d.encodePackage(s, pkgs[i])
+					})
+				},
+				"types": func() {
+					s.value(d.Types.Dump())
+				},
+			})
+			var err error
+			if s.flush(&err); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// The same for a file that is one large function, which streaming
+// can't split up: it is dumped as a whole before it is written.
+func BenchmarkEmitLargeDeclMap(b *testing.B) {
+	fset, f := parseLargeDecl(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	reportPeakHeap(b, func() {
+		for i := 0; i < b.N; i++ {
+			dumped, err := NewDumper(fset, nil).DumpFile(f, "large.go")
+			if err != nil {
+				b.Fatal(err)
+			}
+			res, err := json.Marshal(dumped)
+			if err != nil {
+				b.Fatal(err)
+			}
+			ioutil.Discard.Write(res)
+		}
+	})
+}
+
+func BenchmarkEmitLargeDeclStream(b *testing.B) {
+	fset, f := parseLargeDecl(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	reportPeakHeap(b, func() {
+		for i := 0; i < b.N; i++ {
+			if err := NewDumper(fset, nil).EncodeFile(ioutil.Discard, f, "large.go"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func parseLargeDecl(b *testing.B) (*token.FileSet, *ast.File) {
+	var src strings.Builder
+	src.WriteString("package main\n\nfunc main() {\n\tx := 0\n")
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&src, "\tif x > %d {\n\t\tx = x*%d + len(\"%d\")\n\t}\n", i, i, i)
+	}
+	src.WriteString("\tprintln(x)\n}\n")
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "large.go", src.String(), 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return fset, f
+}
+
+var benchPackages struct {
+	once sync.Once
+	pkgs []*packages.Package
+	err  error
+}
+
+func loadBenchPackages(b *testing.B) []*packages.Package {
+	benchPackages.once.Do(func() {
+		roots, err := import_packages([]string{"go/types"})
+		if err != nil {
+			benchPackages.err = err
+			return
+		}
+		for _, p := range roots {
+			benchPackages.pkgs = accum_packages(benchPackages.pkgs, p)
+		}
+	})
+	if benchPackages.err != nil {
+		b.Fatal(benchPackages.err)
+	}
+	return benchPackages.pkgs
+}
+
+// Run f while sampling the size of the heap, and report how much it
+// grew by at most.
+func reportPeakHeap(b *testing.B, f func()) {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	runtime.GC()
+	metrics.Read(sample)
+	base := sample[0].Value.Uint64()
+
+	peak := base
+	done := make(chan bool)
+	go func() {
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				done <- true
+				return
+			case <-ticker.C:
+				metrics.Read(sample)
+				if v := sample[0].Value.Uint64(); v > peak {
+					peak = v
+				}
+			}
+		}
+	}()
+	f()
+	done <- true
+	<-done
+	b.ReportMetric(float64(peak-base)/(1<<20), "peak-heap-MB")
+}
+
+// gofmt's settings. (format.Node would also sort the imports.)
+var printConfig = printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+
+// Decode a dump, dropping positions unless keep is set.
+func normalize(t *testing.T, dumped []byte, keep bool) map[string]interface{} {
+	var v map[string]interface{}
+	if err := json.Unmarshal(dumped, &v); err != nil {
+		t.Fatal(err)
+	}
+	if !keep {
+		stripPositions(v)
+	}
+	return v
+}
+
+// Remove the comments from a dumped tree.
+func stripComments(v interface{}) {
+	switch n := v.(type) {
+	case map[string]interface{}:
+		for _, k := range []string{"comments", "doc", "all-comments", "leading-comments", "trailing-comments"} {
+			delete(n, k)
+		}
+		for _, c := range n {
+			stripComments(c)
+		}
+	case []interface{}:
+		for _, c := range n {
+			stripComments(c)
+		}
+	}
+}
+
+func stripPositions(v interface{}) {
+	switch n := v.(type) {
+	case map[string]interface{}:
+		delete(n, "position")
+		delete(n, "end")
+		delete(n, "from")
+		delete(n, "to")
+		for _, c := range n {
+			stripPositions(c)
+		}
+	case []interface{}:
+		for _, c := range n {
+			stripPositions(c)
+		}
+	}
+}
+
+func TestIota(t *testing.T) {
+	got := TestExpr("iota")
+	val := got["value"].(map[string]interface{})
+	if val["type"] != "IOTA" {
+		t.Error("Didn't parse iota as a literal")
+	}
+}
+
+func TestTrue(t *testing.T) {
+	got := TestExpr("true")
+	if got["type"] != "BOOL" || got["value"] != "true" {
+		t.Error("Didn't parse 'true' as true")
+	}
+}
+
+func TestFalse(t *testing.T) {
+	got := TestExpr("false")
+	if got["type"] != "BOOL" || got["value"] != "false" {
+		t.Error("Didn't parse 'false' as false")
+	}
+}
+
+func TestProvidedImag(t *testing.T) {
+	got := TestExpr("1.414i")
+	if got["type"] != "IMAG" || got["value"] != "1.414i" {
+		t.Error("Imaginary numbers not parsing correctly")
+	}
+}
+
+func TestProvidedFloat(t *testing.T) {
+	got := TestExpr("3.14")
+	if got["value"].(string) != "3.14" {
+		t.Error("Floats not parsing correctly")
+	}
+}
+
+func TestCall(t *testing.T) {
+	got := TestExpr("foo(bar)")
+	if got["type"].(string) != "call" {
+		t.Error("Function calls not parsing correctly")
+	}
+}
+
+// Parse and typecheck a single self-contained file, then dump it
+// with type information.
+func dumpTyped(t *testing.T, path string, src interface{}) map[string]interface{} {
+	t.Helper()
+	res, _ := dumpTypedTable(t, path, src)
+	return res
+}
+
+// Like dumpTyped, but also return the table of named types.
+func dumpTypedTable(t *testing.T, path string, src interface{}) (map[string]interface{}, map[string]interface{}) {
+	t.Helper()
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Implicits:  make(map[ast.Node]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+		Instances:  make(map[*ast.Ident]types.Instance),
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	if _, err := conf.Check("main", fset, []*ast.File{f}, info); err != nil {
+		t.Fatal(err)
+	}
+	d := NewDumper(fset, info)
+	res, err := d.DumpFile(f, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, d.Types.Dump()
+}
+
+func TestGenericsTyped(t *testing.T) {
+	got := dumpTyped(t, "fixtures/packages/generics/generics.go", nil)
+	if _, err := json.Marshal(got); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := findNodes(got, func(n map[string]interface{}) bool {
+		return n["type"] == "call" && n["function"].(map[string]interface{})["type"] == "instantiation"
+	})
+	if len(calls) != 1 {
+		t.Errorf("expected one call to an instantiated function, got %d", len(calls))
+	}
+
+	params := findNodes(got, func(n map[string]interface{}) bool {
+		return n["type"] == "TypeParam"
+	})
+	if len(params) == 0 {
+		t.Error("no TypeParam go-types emitted")
+	}
+}
+
+func TestRecursiveType(t *testing.T) {
+	src := `package main
+
+type List struct {
+	next *List
+	val  int
+}
+
+func main() {
+	var l List
+	l.next = &l
+}
+`
+	got, table := dumpTypedTable(t, "recursive.go", src)
+	if _, err := json.Marshal(got); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(table) != 1 {
+		t.Fatalf("expected a single named type, got %d", len(table))
+	}
+	list, ok := table["main.List"].(map[string]interface{})
+	if !ok {
+		t.Fatal("main.List missing from the type table")
+	}
+	next := list["underlying"].(map[string]interface{})["fields"].([]map[string]interface{})[0]
+	elem := next["type"].(map[string]interface{})["elem"].(map[string]interface{})
+	if elem["type"] != "Named" || elem["id"] != "main.List" {
+		t.Errorf("recursive field not dumped as a reference: %v", elem)
+	}
+}
+
+func TestNamedTypeIdentity(t *testing.T) {
+	src := `package main
+
+type Box[T any] struct{ val T }
+
+type Celsius float64
+
+func (c Celsius) String() string { return "" }
+func (c *Celsius) set(v float64) { *c = Celsius(v) }
+
+func main() {
+	var b Box[Celsius]
+	b.val.set(1)
+	type local int
+	var _ local
+}
+
+func other() {
+	type local string
+	var _ local
+}
+`
+	_, table := dumpTypedTable(t, "named.go", src)
+
+	// Local types are told apart by where they are declared, not by
+	// anything that depends on the FileSet.
+	for _, id := range []string{"main.local@named.go:13:7", "main.local@named.go:18:7"} {
+		if table[id] == nil {
+			t.Errorf("expected a local type %s in the table", id)
+		}
+	}
+
+	celsius := table["main.Celsius"].(map[string]interface{})
+	if celsius["name"] != "Celsius" || celsius["package"] != "main" || celsius["exported"] != true {
+		t.Errorf("wrong identity for Celsius: %v", celsius)
+	}
+	methods := celsius["methods"].([]map[string]interface{})
+	if len(methods) != 2 {
+		t.Fatalf("expected two methods, got %d", len(methods))
+	}
+	for _, m := range methods {
+		if (m["name"] == "set") != m["pointer-receiver"] {
+			t.Errorf("wrong receiver kind for %v", m["name"])
+		}
+	}
+
+	box := table["main.Box[main.Celsius]"].(map[string]interface{})
+	if box["origin"] != "main.Box[T any]" {
+		t.Errorf("wrong origin for Box[Celsius]: %v", box["origin"])
+	}
+	args := box["type-args"].([]map[string]interface{})
+	if len(args) != 1 || args[0]["id"] != "main.Celsius" {
+		t.Errorf("wrong type arguments for Box[Celsius]: %v", args)
+	}
+}
+
+func TestTypeAliasAndDefinition(t *testing.T) {
+	src := `package main
+
+// Defined is a new type.
+type Defined int
+
+type (
+	// Alias is just another name for int.
+	Alias = int
+)
+
+func main() {}
+`
+	got := dumpTyped(t, "alias.go", src)
+	specs := findNodes(got, func(n map[string]interface{}) bool {
+		return n["kind"] == "spec"
+	})
+	if len(specs) != 2 {
+		t.Fatalf("expected two type specs, got %d", len(specs))
+	}
+
+	for _, spec := range specs {
+		obj := spec["object"].(map[string]interface{})
+		goType := obj["type"].(map[string]interface{})
+		switch obj["name"] {
+		case "Defined":
+			if spec["type"] != "type-definition" || obj["is-alias"] != false || goType["type"] != "Named" {
+				t.Errorf("Defined not dumped as a type definition: %v", spec)
+			}
+		case "Alias":
+			if spec["type"] != "type-alias" || obj["is-alias"] != true || goType["type"] != "Basic" {
+				t.Errorf("Alias not dumped as a type alias: %v", spec)
+			}
+		}
+	}
+}
+
+func TestObjectIDs(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.go")
+	src := `package main
+
+import "unicode/utf8"
+
+type Pair[K, V comparable] struct {
+	Key K
+	Val V
+}
+
+func (p Pair[K, V]) Swap() Pair[V, K] { return Pair[V, K]{p.Val, p.Key} }
+
+func width(s string) (n int) {
+outer:
+	for _, r := range s {
+		if r < 0 {
+			break outer
+		}
+		n += utf8.RuneLen(r)
+	}
+	return len(s) - n
+}
+
+func main() {
+	p := Pair[string, int]{Key: "a"}
+	println(p.Swap().Key, width(p.Key))
+}
+`
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defs := map[string]string{} // by name, for the main package
+	ids := map[string]bool{}
+	var refs []string
+	for _, n := range findNodes(loaded, func(n map[string]interface{}) bool { return n["kind"] == "ident" }) {
+		if id, ok := n["id"].(string); ok {
+			ids[id] = true
+			if strings.HasPrefix(id, "main.") {
+				defs[n["value"].(string)] = id
+			}
+		}
+		if ref, ok := n["refers-to"].(string); ok {
+			refs = append(refs, ref)
+		}
+	}
+	// Unnamed imports declare their package name implicitly.
+	for _, n := range findNodes(loaded, func(n map[string]interface{}) bool { return n["type"] == "import" && n["kind"] == nil }) {
+		if implicit, ok := n["implicit"].(map[string]interface{}); ok {
+			ids[implicit["id"].(string)] = true
+		}
+	}
+
+	for name, want := range map[string]string{
+		"Pair":  "main.Pair",
+		"Key":   "main.Pair.Key",
+		"Swap":  "main.Pair.Swap",
+		"width": "main.width",
+		"s":     "main.s@main.go:12:12",
+		"outer": "main.outer@main.go:13:1",
+		"r":     "main.r@main.go:14:9",
+	} {
+		if defs[name] != want {
+			t.Errorf("wrong id for %s: got %q, want %q", name, defs[name], want)
+		}
+	}
+	for _, want := range []string{"unicode/utf8.RuneLen", "len", "println", "int", "main.Pair.Key", "main.outer@main.go:13:1"} {
+		found := false
+		for _, ref := range refs {
+			found = found || ref == want
+		}
+		if !found {
+			t.Errorf("no reference to %s", want)
+		}
+	}
+	for _, ref := range refs {
+		if strings.HasPrefix(ref, "main.") && !ids[ref] {
+			t.Errorf("reference to %s, which is never declared", ref)
+		}
+	}
+	if !ids["unicode/utf8.RuneLen"] {
+		t.Error("unicode/utf8.RuneLen not declared in the loaded packages")
+	}
+}
+
+func TestImplicits(t *testing.T) {
+	src := `package main
+
+import (
+	"strings"
+	str "strconv"
+)
+
+func main() {
+	var x interface{} = str.Itoa(1)
+	switch v := x.(type) {
+	case int, string:
+		_ = v
+	case *strings.Builder:
+		_ = v
+	default:
+	}
+}
+`
+	got := dumpTyped(t, "implicits.go", src)
+
+	imports := findNodes(got, func(n map[string]interface{}) bool { return n["type"] == "import" && n["kind"] == nil })
+	if len(imports) != 4 { // listed in declarations and imports
+		t.Fatalf("expected four import specs, got %d", len(imports))
+	}
+	for _, spec := range imports {
+		implicit, ok := spec["implicit"].(map[string]interface{})
+		switch spec["path"] {
+		case "strings":
+			want := map[string]interface{}{"name": "strings", "id": "main.strings@implicits.go:4:2", "package": "strings"}
+			if !reflect.DeepEqual(implicit, want) {
+				t.Errorf("wrong implicit package name for strings: %v", implicit)
+			}
+		case "strconv":
+			if ok {
+				t.Errorf("renamed import has an implicit package name: %v", implicit)
+			}
+		}
+	}
+
+	clauses := findNodes(got, func(n map[string]interface{}) bool { return n["type"] == "case-clause" })
+	if len(clauses) != 3 {
+		t.Fatalf("expected three clauses, got %d", len(clauses))
+	}
+	for i, want := range []string{"Interface", "Pointer", "Interface"} {
+		implicit := clauses[i]["implicit"].(map[string]interface{})
+		goType := implicit["go-type"].(map[string]interface{})
+		if implicit["name"] != "v" || implicit["id"] != "main.v@implicits.go:10:9" || goType["type"] != want {
+			t.Errorf("wrong implicit variable in clause %d: %v", i, implicit)
+		}
+	}
+}
+
+func TestSelections(t *testing.T) {
+	src := `package main
+
+import "strings"
+
+type Inner struct{ n int }
+
+func (i *Inner) Get() int { return 0 }
+
+type Outer struct {
+	*Inner
+	m int
+}
+
+func main() {
+	o := Outer{Inner: &Inner{}}
+	_ = o.n
+	_ = o.m
+	f := o.Get
+	g := (*Inner).Get
+	_, _ = f, g
+	_ = strings.ToUpper
+}
+`
+	got := dumpTyped(t, "selections.go", src)
+	nodes := findNodes(got, func(n map[string]interface{}) bool { return n["type"] == "selector" })
+	if len(nodes) != 4 {
+		t.Errorf("expected four selectors (and a qualified identifier), got %d", len(nodes))
+	}
+	selections := map[string]map[string]interface{}{} // by kind and field
+	for _, n := range nodes {
+		sel := n["selection"].(map[string]interface{})
+		selections[sel["selection-kind"].(string)+" "+n["field"].(map[string]interface{})["value"].(string)] = sel
+	}
+
+	for key, want := range map[string]struct {
+		index    []int
+		indirect bool
+		receiver string
+	}{
+		"field-value n":         {[]int{0, 0}, true, "main.Outer"},
+		"field-value m":         {[]int{1}, false, "main.Outer"},
+		"method-value Get":      {[]int{0, 0}, true, "main.Outer"},
+		"method-expression Get": {[]int{0}, true, ""}, // the receiver is *Inner
+	} {
+		sel := selections[key]
+		if sel == nil {
+			t.Errorf("no %s selection", key)
+			continue
+		}
+		receiver := sel["receiver"].(map[string]interface{})
+		if want.receiver == "" {
+			receiver = receiver["elem"].(map[string]interface{})
+			want.receiver = "main.Inner"
+		}
+		if !reflect.DeepEqual(sel["index"], want.index) || sel["indirect"] != want.indirect || receiver["id"] != want.receiver {
+			t.Errorf("wrong %s selection: %v", key, sel)
+		}
+	}
+}
+
+func TestInstances(t *testing.T) {
+	src := `package main
+
+func Map[T, U any](xs []T, f func(T) U) []U { return nil }
+
+type Box[T any] struct{ v T }
+
+func main() {
+	xs := []int{1}
+	_ = Map[int, string](xs, func(int) string { return "" })
+	_ = Map(xs, func(int) bool { return true })
+	var b Box[float64]
+	_ = b
+}
+`
+	got := dumpTyped(t, "instances.go", src)
+	basics := func(ts []map[string]interface{}) []interface{} {
+		kinds := []interface{}{}
+		for _, t := range ts {
+			kinds = append(kinds, t["kind"])
+		}
+		return kinds
+	}
+
+	var maps [][]interface{}
+	for _, n := range findNodes(got, func(n map[string]interface{}) bool { return n["kind"] == "ident" && n["value"] == "Map" }) {
+		if instance, ok := n["instance"].(map[string]interface{}); ok {
+			maps = append(maps, basics(instance["type-args"].([]map[string]interface{})))
+			signature := instance["type"].(map[string]interface{})
+			if signature["type"] != "Signature" || len(signature["type-params"].([]map[string]interface{})) != 0 {
+				t.Errorf("wrong instantiated signature: %v", signature)
+			}
+		}
+	}
+	want := [][]interface{}{{"Int", "String"}, {"Int", "Bool"}}
+	if !reflect.DeepEqual(maps, want) {
+		t.Errorf("wrong instances of Map: got %v, want %v", maps, want)
+	}
+
+	instantiations := findNodes(got, func(n map[string]interface{}) bool { return n["type"] == "instantiation" })
+	if len(instantiations) != 2 {
+		t.Fatalf("expected two instantiations, got %d", len(instantiations))
+	}
+	for _, n := range instantiations {
+		instance := n["instance"].(map[string]interface{})
+		switch n["kind"] {
+		case "expression":
+			if !reflect.DeepEqual(basics(instance["type-args"].([]map[string]interface{})), want[0]) {
+				t.Errorf("wrong instance of Map[int, string]: %v", instance)
+			}
+		case "type":
+			if instance["type"].(map[string]interface{})["id"] != "main.Box[float64]" {
+				t.Errorf("wrong instance of Box[float64]: %v", instance)
+			}
+		}
+	}
+}
+
+func TestDesugar(t *testing.T) {
+	src := `package main
+
+type Inner struct{ n int }
+
+func (i *Inner) Get() int { return i.n }
+
+type Middle struct{ Inner }
+
+type Outer struct{ *Middle }
+
+func (o Outer) Name() string { return "" }
+
+func main() {
+	o := Outer{&Middle{}}
+	p := &o
+	var s interface{ Get() int } = &Inner{}
+	_ = o.n
+	_ = p.Get()
+	_ = p.Name
+	_ = s.Get
+	_ = o.Middle.Inner.n
+	_ = (*Inner).Get
+}
+`
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "desugar.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+	}
+	if _, err := (&types.Config{}).Check("main", fset, []*ast.File{f}, info); err != nil {
+		t.Fatal(err)
+	}
+	d := NewDumper(fset, info)
+	d.Desugar = true
+	dumped, err := d.DumpFile(f, "desugar.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := Validate(jsonValue(dumped)); len(errs) > 0 {
+		t.Errorf("desugared dump doesn't conform to the schema: %v", errs[0])
+	}
+
+	var got []string
+	for _, n := range findNodes(dumped, func(n map[string]interface{}) bool { return n["type"] == "assign" }) {
+		value := n["right"].([]interface{})[0].(map[string]interface{})
+		encoded, _ := json.Marshal(value)
+		e, err := RebuildExpr(normalize(t, encoded, true), token.NewFileSet())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		printer.Fprint(&out, token.NewFileSet(), e)
+		got = append(got, out.String())
+
+		// Synthesized nodes have the selector's position.
+		for _, s := range findNodes(value, func(n map[string]interface{}) bool { return n["synthesized"] == true }) {
+			if !reflect.DeepEqual(s["position"], value["position"]) {
+				t.Errorf("synthesized node at %v in a selector at %v", s["position"], value["position"])
+			}
+		}
+	}
+	want := []string{
+		"(*o.Middle).Inner.n",
+		"(&(*(*p).Middle).Inner).Get()",
+		"(*p).Name",
+		"s.Get",
+		"(*o.Middle).Inner.n",
+		"(*Inner).Get",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong desugared selectors:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestMonomorphize(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.go")
+	src := `package main
+
+func Map[T, U any](xs []T, f func(T) U) []U {
+	ys := make([]U, 0, len(xs))
+	for _, x := range xs {
+		ys = append(ys, f(x))
+	}
+	return ys
+}
+
+type Box[T any] struct{ value T }
+
+func (b *Box[T]) Set(v T) { b.value = v }
+
+func Wrap[T any](v T) Box[[]T] {
+	var b Box[[]T]
+	b.Set([]T{v})
+	return b
+}
+
+func main() {
+	lens := Map([]string{"a", "bc"}, func(s string) int { return len(s) })
+	b := Wrap[int](lens[0])
+	println(len(b.value))
+}
+`
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := (&Config{Monomorphize: true}).Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := Validate(jsonValue(got)); len(errs) > 0 {
+		t.Errorf("monomorphized dump doesn't conform to the schema: %v", errs[0])
+	}
+
+	// Each copy, by name, with the generic declaration it's a copy of.
+	copies := map[string]string{}
+	var main map[string]interface{}
+	for _, n := range findNodes(got["package"], func(n map[string]interface{}) bool {
+		return n["kind"] == "decl" && n["name"] != nil || n["type"] == "type-definition"
+	}) {
+		name := n["name"].(map[string]interface{})["value"].(string)
+		if name == "main" {
+			main = n
+		}
+		if n["instance-of"] == nil {
+			continue
+		}
+		copies[name] = n["instance-of"].(string)
+
+		if tps := findNodes(n, func(n map[string]interface{}) bool { return n["type"] == "TypeParam" }); len(tps) > 0 {
+			t.Errorf("%s has type parameters: %v", name, tps[0])
+		}
+		if name == "Mapᐸstringᐧintᐳ" {
+			args := n["type-args"].([]map[string]interface{})
+			if len(args) != 2 || args[0]["kind"] != "String" || args[1]["kind"] != "Int" {
+				t.Errorf("wrong type arguments for %s: %v", name, args)
+			}
+			pos := n["position"].(map[string]interface{})
+			if !strings.HasSuffix(pos["filename"].(string), "main.go#Mapᐸstringᐧintᐳ") || pos["line"] != float64(3) {
+				t.Errorf("wrong position for %s: %v", name, pos)
+			}
+		}
+	}
+	want := map[string]string{
+		"Mapᐸstringᐧintᐳ": "main.Map",
+		"Wrapᐸintᐳ":       "main.Wrap",
+		"Boxᐸᐸᐳintᐳ":      "main.Box",
+		"Set":             "main.Box.Set",
+	}
+	if !reflect.DeepEqual(copies, want) {
+		t.Errorf("expected copies %v, got %v", want, copies)
+	}
+
+	// main only refers to the copies.
+	var refs []string
+	for _, n := range findNodes(main, func(n map[string]interface{}) bool { return n["refers-to"] != nil }) {
+		refs = append(refs, n["refers-to"].(string))
+	}
+	for _, generic := range []string{"main.Map", "main.Wrap", "main.Box"} {
+		for _, ref := range refs {
+			if ref == generic {
+				t.Errorf("main still refers to %s", generic)
+			}
+		}
+	}
+	for _, ref := range []string{"main.Mapᐸstringᐧintᐳ", "main.Wrapᐸintᐳ", "main.Boxᐸᐸᐳintᐳ.value"} {
+		found := false
+		for _, r := range refs {
+			found = found || r == ref
+		}
+		if !found {
+			t.Errorf("main doesn't refer to %s: %v", ref, refs)
+		}
+	}
+}
+
+// Instantiations that aren't specialized are reported.
+func TestMonomorphizeSkipped(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.go")
+	src := `package main
+
+import "slices"
+
+func Id[T any](v T) T { return v }
+
+func main() {
+	type point struct{ x int }
+	_ = Id(point{1})
+	println(slices.Index([]int{1}, 1), Id(2))
+}
+`
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := (&Config{Monomorphize: true}).Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := Validate(jsonValue(got)); len(errs) > 0 {
+		t.Errorf("monomorphized dump doesn't conform to the schema: %v", errs[0])
+	}
+
+	var skipped []string
+	for _, d := range got["diagnostics"].([]map[string]interface{}) {
+		pos := d["position"].(map[string]interface{})
+		skipped = append(skipped, fmt.Sprintf("%s %v:%v %s", d["type"], pos["line"], pos["column"], d["info"]))
+	}
+	want := []string{
+		"monomorphize_skipped 9:6 Id[point] isn't specialized: point is declared in a function",
+		"monomorphize_skipped 10:17 slices.Index[[]int, int] isn't specialized: it is declared in package slices",
+	}
+	if !reflect.DeepEqual(skipped, want) {
+		t.Errorf("wrong diagnostics:\n%s\nwant:\n%s", strings.Join(skipped, "\n"), strings.Join(want, "\n"))
+	}
+
+	// The other instantiation is still specialized.
+	copies := findNodes(got["package"], func(n map[string]interface{}) bool { return n["instance-of"] == "main.Id" })
+	if len(copies) != 1 {
+		t.Errorf("expected one copy of Id, got %d", len(copies))
+	}
+}
+
+func TestScopes(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.go")
+	src := `package main
+
+func main() {
+	x := 1
+	if y := x; y > 0 {
+		f := func(z int) int { return z }
+		_ = f(y)
+	}
+	switch v := interface{}(x).(type) {
+	case int:
+		_ = v
+	}
+}
+`
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	universe := loaded["universe"].(map[string]interface{})
+	scopes := map[string]map[string]interface{}{"universe": universe}
+	for _, s := range loaded["package"].(map[string]interface{})["scopes"].([]map[string]interface{}) {
+		scopes[s["id"].(string)] = s
+	}
+	for id, s := range scopes {
+		if parent, ok := s["parent"].(string); ok && scopes[parent] == nil {
+			t.Errorf("parent %s of scope %s not dumped", parent, id)
+		}
+	}
+
+	for _, want := range []struct {
+		id, typ, parent string
+		objects         []string
+	}{
+		{"main", "package", "universe", []string{"main.main"}},
+		{"main@main.go", "file", "main", []string{}},
+		{"main@main.go:3:1", "function", "main@main.go", []string{"main.x@main.go:4:2"}},
+		{"main@main.go:5:2", "if", "main@main.go:3:1", []string{"main.y@main.go:5:5"}},
+		{"main@main.go:6:8", "function", "main@main.go:5:19", []string{"main.z@main.go:6:13"}},
+		{"main@main.go:10:2", "case-clause", "main@main.go:9:2", []string{"main.v@main.go:9:9"}},
+	} {
+		s := scopes[want.id]
+		if s == nil {
+			t.Errorf("no scope %s", want.id)
+			continue
+		}
+		if s["type"] != want.typ || s["parent"] != want.parent || !reflect.DeepEqual(s["objects"], want.objects) {
+			t.Errorf("wrong scope %s: %v", want.id, s)
+		}
+	}
+	if objects := universe["objects"].([]string); len(objects) == 0 || universe["start"] != nil {
+		t.Errorf("wrong universe scope: %v", universe)
+	}
+
+	links := findNodes(loaded["package"], func(n map[string]interface{}) bool {
+		_, ok := n["scope"].(string)
+		return ok
+	})
+	linked := map[string]bool{}
+	for _, n := range links {
+		id := n["scope"].(string)
+		if scopes[id] == nil {
+			t.Errorf("%s %s links to missing scope %s", n["kind"], n["type"], id)
+		}
+		linked[id] = true
+	}
+	for _, id := range []string{"main@main.go", "main@main.go:3:1", "main@main.go:5:2", "main@main.go:6:8", "main@main.go:9:2"} {
+		if !linked[id] {
+			t.Errorf("no node links to scope %s", id)
+		}
+	}
+}
+
+func TestAttachedComments(t *testing.T) {
+	src := `// Package doc.
+package main
+
+type T struct {
+	// A is a field.
+	A int // trailing A
+	B /* inline */ string
+}
+
+func main() {
+	// leading
+	x := 1 // trailing
+	println(x)
+}
+`
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "comments.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dumped, err := NewDumper(fset, nil).DumpFile(f, "comments.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := Validate(jsonValue(dumped)); len(errs) > 0 {
+		t.Errorf("dump with attached comments doesn't conform to the schema: %v", errs[0])
+	}
+
+	// Each attached comment as text:style:line, by the node's type
+	// and which side it's on.
+	attached := func(dumped map[string]interface{}) map[string][]string {
+		got := map[string][]string{}
+		for _, n := range findNodes(dumped, func(n map[string]interface{}) bool {
+			return n["leading-comments"] != nil || n["trailing-comments"] != nil
+		}) {
+			for _, side := range []string{"leading", "trailing"} {
+				for _, g := range asList(n[side+"-comments"]) {
+					for _, c := range asList(g) {
+						c := asNode(c)
+						line := asNode(c["position"])["line"]
+						key := fmt.Sprintf("%v %s", n["type"], side)
+						if n["kind"] == "file" {
+							key = "file " + side
+						} else if n["kind"] == "field" {
+							key = fmt.Sprintf("field %v %s", asNode(asList(n["names"])[0])["value"], side)
+						}
+						got[key] = append(got[key], fmt.Sprintf("%s:%s:%v", c["text"], c["type"], asNumber(line)))
+					}
+				}
+			}
+		}
+		return got
+	}
+	got := attached(dumped)
+	want := map[string][]string{
+		"file leading":     {"// Package doc.:line:1"},
+		"field A leading":  {"// A is a field.:line:5"},
+		"field A trailing": {"// trailing A:line:6"},
+		"field B trailing": {"/* inline */:block:7"},
+		"define leading":   {"// leading:line:11"},
+		"define trailing":  {"// trailing:line:12"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected comments %v, got %v", want, got)
+	}
+
+	// The comments survive a round trip through the JSON, the
+	// rebuilt source, and a second dump, in the same places.
+	data, err := json.Marshal(dumped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	rfset := token.NewFileSet()
+	rebuilt, err := RebuildFile(decoded, rfset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var orig, buf bytes.Buffer
+	if err := printConfig.Fprint(&orig, fset, f); err != nil {
+		t.Fatal(err)
+	}
+	if err := printConfig.Fprint(&buf, rfset, rebuilt); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != orig.String() {
+		t.Errorf("rebuilt source differs:\n%s", buf.String())
+	}
+	f, err = parser.ParseFile(rfset, "comments.go", buf.Bytes(), parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redumped, err := NewDumper(rfset, nil).DumpFile(f, "comments.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := attached(redumped); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected comments %v after a round trip, got %v", want, got)
+	}
+}
+
+func TestSourceRanges(t *testing.T) {
+	src := `package main
+
+type T struct {
+	A, B int ` + "`json:\"a\"`" + `
+}
+
+var t, u = pair()
+
+func pair() (*T, int) { return &T{A: 1}, 2 }
+
+func f(xs ...int) int {
+	p, _ := pair()
+	println(*p, len(xs))
+	_ = [...]int{1}
+	return f(xs...)
+}
+`
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "ranges.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := newTypesInfo()
+	if _, err := (&types.Config{}).Check("main", fset, []*ast.File{f}, info); err != nil {
+		t.Fatal(err)
+	}
+	d := NewDumper(fset, info)
+	dumped, err := d.DumpFile(f, "ranges.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inits, err := d.DumpInitializers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every node has a position and an end, and spans its source.
+	nodeKinds := map[interface{}]bool{"file": true, "decl": true, "spec": true, "statement": true, "field": true,
+		"expression": true, "literal": true, "constant": true, "type": true, "ident": true}
+	got := map[string][]string{}
+	for _, n := range findNodes([]interface{}{dumped, inits}, func(n map[string]interface{}) bool { return nodeKinds[n["kind"]] }) {
+		pos, _ := n["position"].(map[string]interface{})
+		end, _ := n["end"].(map[string]interface{})
+		if pos == nil || end == nil {
+			t.Errorf("%v %v has no position or end", n["kind"], n["type"])
+			continue
+		}
+		node := fmt.Sprintf("%v %v", n["kind"], n["type"])
+		got[node] = append(got[node], src[int(asNumber(pos["offset"])):int(asNumber(end["offset"]))])
+	}
+	for node, text := range map[string]string{
+		"file <nil>":            src[:len(src)-1],
+		"field <nil>":           "A, B int `json:\"a\"`",
+		"spec type-definition":  "T struct {\n\tA, B int `json:\"a\"`\n}",
+		"decl var":              "var t, u = pair()",
+		"statement initializer": "t, u = pair()",
+		"statement define":      "p, _ := pair()",
+		"statement expression":  "println(*p, len(xs))",
+		"statement return":      "return f(xs...)",
+		"expression star":       "*p",
+		"expression unary":      "&T{A: 1}",
+		"literal composite":     "T{A: 1}",
+		"expression key-value":  "A: 1",
+		"expression call":       "f(xs...)",
+		"expression ellipsis":   "...",
+		"type ellipsis":         "...int",
+		"type pointer":          "*T",
+		"ident <nil>":           "pair",
+	} {
+		found := false
+		for _, g := range got[node] {
+			found = found || g == text
+		}
+		if !found {
+			t.Errorf("expected a %s spanning %q, got %q", node, text, got[node])
+		}
+	}
+}
+
+func TestCompactPositions(t *testing.T) {
+	asJSON := func(v interface{}) map[string]interface{} {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var res map[string]interface{}
+		json.Unmarshal(data, &res)
+		return res
+	}
+	rebuilt := func(name string, dumped map[string]interface{}) string {
+		f, err := RebuildFile(dumped, token.NewFileSet())
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		var src bytes.Buffer
+		printConfig.Fprint(&src, token.NewFileSet(), f)
+		return src.String()
+	}
+
+	for _, fix := range packageFixtures {
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, fix.goPath, nil, parser.ParseComments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		full, err := NewDumper(fset, nil).DumpFile(f, fix.goPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := NewDumper(fset, nil)
+		d.CompactPositions = true
+		dumped, err := d.DumpFile(f, fix.goPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compact := asJSON(dumped)
+		if errs := Validate(compact); len(errs) > 0 {
+			t.Errorf("%s: compact dump doesn't conform to the schema: %v", fix.name, errs[0])
+		}
+
+		// Unpacked with the file table, they are the full positions.
+		unpacked := unpackPositions(compact, fileTableOf(compact)).(map[string]interface{})
+		delete(unpacked, "file-table")
+		if !reflect.DeepEqual(unpacked, asJSON(full)) {
+			t.Errorf("%s: unpacked positions differ from the full ones", fix.name)
+		}
+
+		var node FileNode
+		data, _ := json.Marshal(compact)
+		if err := json.Unmarshal(data, &node); err != nil {
+			t.Fatalf("%s: %v", fix.name, err)
+		}
+		if !reflect.DeepEqual(asJSON(node), compact) {
+			t.Errorf("%s: round trip of packed positions through the node structs failed", fix.name)
+		}
+		if p := node.FileTable.Unpack(*node.Position.Packed); p.Line != fset.Position(f.Package).Line || p.Filename != fix.goPath {
+			t.Errorf("%s: expected the package clause at line %d, got %+v", fix.name, fset.Position(f.Package).Line, p)
+		}
+
+		var buf bytes.Buffer
+		if err := Encode(&buf, dumped, Proto); err != nil {
+			t.Fatalf("%s: %v", fix.name, err)
+		}
+		compareProto(t, fix.name, buf.Bytes(), protoMessageFor("file"), dumped)
+
+		if got, want := rebuilt(fix.name, compact), rebuilt(fix.name, asJSON(full)); got != want {
+			t.Errorf("%s: rebuilt with packed positions as\n%s\nexpected\n%s", fix.name, got, want)
+		}
+	}
+}
+
+func TestConcurrentDumpers(t *testing.T) {
+	path := "fixtures/packages/generics/generics.go"
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &types.Info{
+		Types: make(map[ast.Expr]types.TypeAndValue),
+		Defs:  make(map[*ast.Ident]types.Object),
+		Uses:  make(map[*ast.Ident]types.Object),
+	}
+	if _, err := (&types.Config{}).Check("main", fset, []*ast.File{f}, info); err != nil {
+		t.Fatal(err)
+	}
+
+	// Dumpers sharing a type table must not interfere with each
+	// other (run with -race).
+	table := NewTypeTable()
+	results := make([][]byte, 8)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			d := &Dumper{Fset: fset, Info: info, Types: table}
+			decl, err := d.DumpDecl(f.Decls[len(f.Decls)-1])
+			if err != nil {
+				t.Error(err)
+			}
+			results[i], _ = json.Marshal(decl)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range results {
+		if string(results[i]) != string(results[0]) {
+			t.Fatalf("concurrent dumps differ")
+		}
+	}
+	if len(table.Dump()) == 0 {
+		t.Error("no named types recorded")
+	}
+}
+
+func TestBadExprError(t *testing.T) {
+	fset := token.NewFileSet()
+	fset.AddFile("bad.go", -1, 100)
+	_, err := NewDumper(fset, nil).DumpExpr(&ast.BadExpr{From: 11, To: 20})
+	e, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("expected an *Error, got %v", err)
+	}
+	if e.Type != "internal_error" || e.Position.Filename != "bad.go" || e.Position.Offset != 10 {
+		t.Errorf("wrong error: %v", e)
+	}
+
+	// The old free functions Perish instead, which panics with
+	// ShouldPanic.
+	ShouldPanic = true
+	defer func() {
+		ShouldPanic = false
+		if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "encountered BadExpr") {
+			t.Errorf("expected DumpCall to perish, got %v", r)
+		}
+	}()
+	call := &ast.CallExpr{Fun: ast.NewIdent("f"), Args: []ast.Expr{&ast.BadExpr{From: 11, To: 20}}}
+	DumpCall(call, fset)
+}
+
+func TestLoadTypeError(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.go")
+	src := "package main\n\nfunc main() {\n\tvar x int = \"one\"\n\tprintln(x)\n}\n"
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Load(path)
+	e, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("expected an *Error, got %v", err)
+	}
+	if e.Type != "type_error" || e.Position.Line != 4 {
+		t.Errorf("wrong error: %v", e)
+	}
+}
+
+func TestLoadDiagnostics(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.go")
+	src := `package main
+
+func main() {
+	var x int = "one"
+	y := undefined + 1
+	println(x, y)
+}
+`
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := (&Config{Diagnostics: true}).Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diags := got["diagnostics"].([]map[string]interface{})
+	if len(diags) != 2 {
+		t.Fatalf("expected two diagnostics, got %v", diags)
+	}
+	for i, line := range []float64{4, 5} {
+		pos := diags[i]["position"].(map[string]interface{})
+		if diags[i]["type"] != "type_error" || pos["line"] != line {
+			t.Errorf("wrong diagnostic: %v", diags[i])
+		}
+	}
+
+	// The rest of the package is still dumped.
+	prints := findNodes(got["package"], func(n map[string]interface{}) bool {
+		return n["type"] == "call"
+	})
+	if len(prints) != 1 {
+		t.Errorf("expected the call to println to be dumped")
+	}
+}
+
+func TestRecoverBadNodes(t *testing.T) {
+	src := `package main
+
+func main() {
+	x := 1 +
+}
+`
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "half.go", src, parser.AllErrors)
+	if err == nil {
+		t.Fatal("expected a syntax error")
+	}
+
+	errs := SyntaxErrors(err)
+	if len(errs) == 0 || errs[0].Type != "syntax_error" || errs[0].Position.Line != 5 {
+		t.Errorf("wrong syntax errors: %v", errs)
+	}
+
+	if _, err := NewDumper(fset, nil).DumpFile(f, "half.go"); err == nil {
+		t.Error("expected BadExpr to be rejected without Recover")
+	}
+
+	d := NewDumper(fset, nil)
+	d.Recover = true
+	got, err := d.DumpFile(f, "half.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := findNodes(got, func(n map[string]interface{}) bool {
+		return n["type"] == "bad"
+	})
+	if len(bad) != 1 || bad[0]["kind"] != "expression" {
+		t.Fatalf("expected one bad expression, got %v", bad)
+	}
+	if bad[0]["from"].(map[string]interface{})["line"] != float64(5) {
+		t.Errorf("wrong range for bad expression: %v", bad[0])
+	}
+}
+
+func TestRoundTripUInt(t *testing.T) {
+	f := func(ui uint64) bool {
+		want := fmt.Sprintf("%d", ui)
+		got := TestExpr(want)
+		return want == got["value"]
+	}
+
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+// Collect every node in a dumped tree that satisfies pred.
+func findNodes(v interface{}, pred func(map[string]interface{}) bool) []map[string]interface{} {
+	found := []map[string]interface{}{}
+	switch n := v.(type) {
+	case map[string]interface{}:
+		if pred(n) {
+			found = append(found, n)
+		}
+		for _, c := range n {
+			found = append(found, findNodes(c, pred)...)
+		}
+	case []map[string]interface{}:
+		for _, c := range n {
+			found = append(found, findNodes(c, pred)...)
+		}
+	case []interface{}:
+		for _, c := range n {
+			found = append(found, findNodes(c, pred)...)
+		}
 	}
+	return found
 }
 
 func dumpFail(t *testing.T, fix Fixture, got interface{}) {
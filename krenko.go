@@ -1,15 +1,17 @@
 package goblin
 
 import (
-	"fmt"
 	"go/ast"
 	"go/importer"
 	"go/parser"
 	"go/token"
 	"go/types"
 	"golang.org/x/tools/go/packages"
-	"log"
-	"os"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 // This file contains the code for "Krenko, Mob Boss" (yes the MTG
@@ -23,37 +25,172 @@ import (
 // generalize to a multi-file package by pointing krenko at the
 // package directory instead of a particular file.
 
-func Load(file_path string) map[string]interface{} {
+// Config controls how Load treats errors. The zero Config (used by the
+// Load function) gives up at the first one.
+type Config struct {
+	// Collect every syntax and type error (in the main package as
+	// well as its imports) instead of failing on the first one. The
+	// errors are reported in the "diagnostics" array of the result,
+	// and packages are dumped as far as they could be parsed and
+	// checked.
+	Diagnostics bool
+
+	// The encoding LoadTo writes (JSON by default).
+	Format Format
+
+	// Dump selectors with their implicit steps made explicit, as
+	// with Dumper.Desugar.
+	Desugar bool
+
+	// Specialize the generic functions and types of the main package
+	// for each of their instantiations (see mono.go). Skipped if the
+	// main package has errors.
+	Monomorphize bool
+
+	// Dump positions as packed integers, as with
+	// Dumper.CompactPositions.
+	CompactPositions bool
+}
+
+// Parse and typecheck errors are returned as an *Error.
+func Load(file_path string) (map[string]interface{}, error) {
+	return (&Config{}).Load(file_path)
+}
+
+// Like Load, but write the result to w, dumping one package (and
+// within it one declaration) at a time instead of building the whole
+// tree in memory. The output is the same as json.Marshal of Load's
+// result (or, with Config.Format, its CBOR or MessagePack encoding).
+// If an error occurs part way, what was written so far is left in w.
+func LoadTo(w io.Writer, file_path string) error {
+	return (&Config{}).LoadTo(w, file_path)
+}
+
+func (c *Config) Load(file_path string) (map[string]interface{}, error) {
+	l, err := c.load(file_path)
+	if err != nil {
+		return nil, err
+	}
+
+	imports, err := l.dumper.DumpPackages(l.imports)
+	if err != nil {
+		return nil, err
+	}
+	main, err := l.dumper.DumpPackage(l.main)
+	if err != nil {
+		return nil, err
+	}
+
+	// Construct the final result object to be serialized.
+	res := l.header()
+	res["package"] = main
+	res["imports"] = imports
+	res["types"] = l.dumper.Types.Dump()
+	res["universe"], err = l.dumper.DumpUniverse()
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *Config) LoadTo(w io.Writer, file_path string) (err error) {
+	l, err := c.load(file_path)
+	if err != nil {
+		return err
+	}
+
+	s := newStream(w, c.Format)
+	defer s.flush(&err)
+	defer catch(&err)
+	s.top("load")
+
+	// Keys are written in sorted order, so the types table comes
+	// last, once every package has been dumped.
+	s.object(l.header(), map[string]func(){
+		"imports": func() {
+			s.array(len(l.imports), func(i int) {
+				l.dumper.encodePackage(s, l.imports[i])
+			})
+		},
+		"package": func() {
+			l.dumper.encodePackage(s, l.main)
+		},
+		"types": func() {
+			s.value(l.dumper.Types.Dump())
+		},
+		"universe": func() {
+			s.value(l.dumper.dumpScope(types.Universe, "universe"))
+		},
+	})
+	return nil
+}
+
+// A program that has been loaded and typechecked, ready to be dumped.
+type loaded struct {
+	name        string
+	main        *packages.Package
+	imports     []*packages.Package // in topological order
+	dumper      *Dumper
+	diagnostics []*Error // if collected
+}
+
+func (c *Config) load(file_path string) (*loaded, error) {
 	fset := token.NewFileSet()
+	diagnostics := []*Error{}
 
-	// Parse the main file.
-	f, err := parser.ParseFile(fset, file_path, nil, 0)
+	// Parse the main file. When collecting diagnostics, syntax
+	// errors are reported and the partial AST is dumped (with "bad"
+	// nodes where the parser gave up).
+	mode := parser.ParseComments
+	if c.Diagnostics {
+		mode |= parser.AllErrors
+	}
+	f, err := parser.ParseFile(fset, file_path, nil, mode)
 	if err != nil {
-		log.Fatal(err) // parse error
+		if !c.Diagnostics || f == nil {
+			return nil, convertError(err, fset) // parse error
+		}
+		diagnostics = append(diagnostics, SyntaxErrors(err)...)
 	}
 
 	// Use "ForCompiler" importer to load package sources instead
 	// of precompiled files.
 	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	if c.Diagnostics {
+		// Keep checking after errors.
+		conf.Error = func(err error) {
+			diagnostics = append(diagnostics, convertError(err, fset))
+		}
+	}
 
 	// Set up typechecker info for the main file. This is how we
 	// specify which fields we want from the typechecker.
-	info := types.Info{
-		Types:     make(map[ast.Expr]types.TypeAndValue),
-		Defs:      make(map[*ast.Ident]types.Object),
-		Uses:      make(map[*ast.Ident]types.Object),
-		InitOrder: []*types.Initializer{},
-	}
+	info := newTypesInfo()
 
 	// Typecheck the main file.
-	pkg, err := conf.Check("", fset, []*ast.File{f}, &info)
-	if err != nil {
-		log.Fatal(err) // type error
+	pkg, err := conf.Check("", fset, []*ast.File{f}, info)
+	if err != nil && !c.Diagnostics {
+		return nil, convertError(err, fset) // type error
+	}
+
+	var copies map[ast.Node]*specialization
+	if c.Monomorphize && len(diagnostics) == 0 {
+		newPkg, newInfo, specialized, skipped, err := monomorphize(&conf, fset, f, pkg, info)
+		if err != nil {
+			return nil, err
+		}
+		diagnostics = append(diagnostics, skipped...)
+		if specialized != nil {
+			pkg, info, copies = newPkg, newInfo, specialized
+		}
 	}
 
 	// Parse and typecheck all imported packages and their
 	// dependencies.
-	pkgs := import_packages(package_paths(pkg.Imports()))
+	pkgs, err := import_packages(package_paths(pkg.Imports()))
+	if err != nil {
+		return nil, err
+	}
 
 	// Flatten the list of all packages in topological order so it
 	// will be safe to process them in left-to-right order in
@@ -62,14 +199,60 @@ func Load(file_path string) map[string]interface{} {
 	for _, p := range pkgs {
 		pkgs_flat = accum_packages(pkgs_flat, p)
 	}
-	imports := DumpPackages(pkgs_flat)
 
-	// Construct the final result object to be serialized.
-	return map[string]interface{}{
-		"name":    f.Name.Name,
-		"package": DumpPackage(ConvertPackage(pkg, []string{f.Name.Name}, []*ast.File{f}, fset, &info)),
-		"imports": imports,
+	for _, p := range pkgs_flat {
+		for _, e := range p.Errors {
+			if !c.Diagnostics {
+				return nil, convertPackageError(e)
+			}
+			diagnostics = append(diagnostics, convertPackageError(e))
+		}
+	}
+
+	l := &loaded{
+		name:    f.Name.Name,
+		main:    ConvertPackage(pkg, []string{f.Name.Name}, []*ast.File{f}, fset, info),
+		imports: pkgs_flat,
+		// All packages share a table of named types.
+		dumper: &Dumper{Types: NewTypeTable(), Recover: c.Diagnostics, Desugar: c.Desugar,
+			CompactPositions: c.CompactPositions, specializations: copies},
 	}
+	// Instantiations left generic by Monomorphize are reported
+	// whether or not other diagnostics are collected.
+	if c.Diagnostics || len(diagnostics) > 0 {
+		l.diagnostics = diagnostics
+	}
+	return l, nil
+}
+
+// Type information with everything goblin dumps.
+func newTypesInfo() *types.Info {
+	return &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Implicits:  make(map[ast.Node]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+		Instances:  make(map[*ast.Ident]types.Instance),
+		Scopes:     make(map[ast.Node]*types.Scope),
+		InitOrder:  []*types.Initializer{},
+	}
+}
+
+// The parts of the result that aren't dumped from packages.
+func (l *loaded) header() map[string]interface{} {
+	res := map[string]interface{}{
+		"schema-version": SchemaVersion,
+		"name":           l.name,
+	}
+	if l.diagnostics != nil {
+		dumped := make([]map[string]interface{}, len(l.diagnostics))
+		for i, e := range l.diagnostics {
+			dumped[i] = DumpError(e)
+		}
+		res["diagnostics"] = dumped
+	}
+	return res
 }
 
 func package_paths(pkgs []*types.Package) []string {
@@ -83,9 +266,9 @@ func package_paths(pkgs []*types.Package) []string {
 // Given a list of package names, use 'packages' to load and typecheck
 // all of the packages along with the transitive closure of their
 // dependencies.
-func import_packages(pkg_names []string) []*packages.Package {
+func import_packages(pkg_names []string) ([]*packages.Package, error) {
 	if len(pkg_names) == 0 {
-		return []*packages.Package{}
+		return []*packages.Package{}, nil
 	}
 
 	cfg := &packages.Config{Mode: packages.NeedName |
@@ -95,15 +278,49 @@ func import_packages(pkg_names []string) []*packages.Package {
 
 	pkgs, err := packages.Load(cfg, pkg_names...)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "import_packages: %v\n", err)
-		os.Exit(1)
+		return nil, &Error{Type: "import_error", Reason: err.Error(), Position: TOPLEVEL_POSITION}
 	}
-	if packages.PrintErrors(pkgs) > 0 {
-		fmt.Println("import_packages error")
-		os.Exit(1)
+
+	// Errors within the packages are left to the caller.
+	return pkgs, nil
+}
+
+func convertPackageError(err packages.Error) *Error {
+	typ := "import_error"
+	switch err.Kind {
+	case packages.ParseError:
+		typ = "syntax_error"
+	case packages.TypeError:
+		typ = "type_error"
 	}
+	return &Error{Type: typ, Reason: err.Msg, Position: parsePosition(err.Pos)}
+}
 
-	return pkgs
+// packages.Error positions are strings of the form file:line:col (or
+// file:line, or empty if unknown).
+func parsePosition(s string) token.Position {
+	if s == "" || s == "-" {
+		return TOPLEVEL_POSITION
+	}
+	pos := token.Position{Offset: -1}
+	parts := strings.Split(s, ":")
+	nums := []int{}
+	for len(parts) > 1 && len(nums) < 2 {
+		n, err := strconv.Atoi(parts[len(parts)-1])
+		if err != nil {
+			break
+		}
+		nums = append([]int{n}, nums...)
+		parts = parts[:len(parts)-1]
+	}
+	pos.Filename = strings.Join(parts, ":")
+	if len(nums) > 0 {
+		pos.Line = nums[0]
+	}
+	if len(nums) > 1 {
+		pos.Column = nums[1]
+	}
+	return pos
 }
 
 // Gather transitive closure of import packages, avoiding duplicates.
@@ -127,34 +344,129 @@ func elem(x *packages.Package, l []*packages.Package) bool {
 }
 
 // Use goblin's DumpFile.
+//
+// Deprecated: use Dumper.DumpPackage, which returns an error instead
+// of exiting, and keeps the named types the dump refers to in the
+// Dumper's TypeTable (this throws them away).
 func DumpPackage(pkg *packages.Package) map[string]interface{} {
-	imports := []string{}
-	for _, p := range pkg.Imports {
-		imports = append(imports, p.PkgPath)
-	}
+	res, err := NewDumper(pkg.Fset, pkg.TypesInfo).DumpPackage(pkg)
+	perishOn(err)
+	return res
+}
+
+// Dump a package with the options and type table of d. The FileSet and
+// type information are those of pkg.
+func (d *Dumper) DumpPackage(pkg *packages.Package) (map[string]interface{}, error) {
+	pd := d.forPackage(pkg)
 
 	// Dump source files.
 	files := make([]map[string]interface{}, len(pkg.Syntax))
 	for i, f := range pkg.Syntax {
-		files[i] = DumpFile(f, pkg.GoFiles[i], pkg.Fset, pkg.TypesInfo)
+		file, err := pd.DumpFile(f, pkg.GoFiles[i])
+		if err != nil {
+			return nil, err
+		}
+		files[i] = file
+	}
+
+	initializers, err := pd.DumpInitializers()
+	if err != nil {
+		return nil, err
+	}
+	scopes, err := pd.DumpScopes(pkg.Syntax)
+	if err != nil {
+		return nil, err
+	}
+
+	res := packageHeader(pkg)
+	res["files"] = files
+	res["initializers"] = initializers
+	res["scopes"] = scopes
+	return res, nil
+}
+
+// Like DumpPackage, but write the package to w in d.Format one
+// declaration at a time.
+func (d *Dumper) EncodePackage(w io.Writer, pkg *packages.Package) (err error) {
+	s := newStream(w, d.Format)
+	defer s.flush(&err)
+	defer catch(&err)
+	s.top("package")
+	d.encodePackage(s, pkg)
+	return nil
+}
+
+func (d *Dumper) encodePackage(s *stream, pkg *packages.Package) {
+	pd := d.forPackage(pkg)
+	s.object(packageHeader(pkg), map[string]func(){
+		"files": func() {
+			s.array(len(pkg.Syntax), func(i int) {
+				pd.encodeFile(s, pkg.Syntax[i], pkg.GoFiles[i])
+			})
+		},
+		"initializers": func() {
+			s.value(pd.dumpInitializers())
+		},
+		"scopes": func() {
+			s.value(pd.dumpScopes(pkg.Syntax))
+		},
+	})
+}
+
+// A Dumper for the files of pkg.
+func (d *Dumper) forPackage(pkg *packages.Package) *Dumper {
+	pd := *d
+	pd.Fset = pkg.Fset
+	pd.Info = pkg.TypesInfo
+	return &pd
+}
+
+// Everything about a package but its files, initializers and scopes.
+func packageHeader(pkg *packages.Package) map[string]interface{} {
+	// (Sorted, since pkg.Imports is a map.)
+	imports := []string{}
+	for _, p := range pkg.Imports {
+		imports = append(imports, p.PkgPath)
 	}
+	sort.Strings(imports)
 
 	return map[string]interface{}{
-		"name":         pkg.Name,
-		"path":         pkg.PkgPath,
-		"imports":      imports,
-		"file-paths":   pkg.GoFiles,
-		"files":        files,
-		"initializers": DumpInitializers(pkg.Fset, pkg.TypesInfo),
+		"name":       pkg.Name,
+		"path":       pkg.PkgPath,
+		"imports":    imports,
+		"file-paths": pkg.GoFiles,
 	}
 }
 
+// Deprecated: use Dumper.DumpPackages (see DumpPackage).
 func DumpPackages(pkgs []*packages.Package) []map[string]interface{} {
+	res, err := NewDumper(nil, nil).DumpPackages(pkgs)
+	perishOn(err)
+	return res
+}
+
+// Packages are dumped in parallel, but the result is in the same
+// order as pkgs. If any package fails, the error for the first one
+// (in that order) is returned.
+func (d *Dumper) DumpPackages(pkgs []*packages.Package) ([]map[string]interface{}, error) {
 	dumped := make([]map[string]interface{}, len(pkgs))
+	errs := make([]error, len(pkgs))
+	var wg sync.WaitGroup
 	for i, pkg := range pkgs {
-		dumped[i] = DumpPackage(pkg)
+		wg.Add(1)
+		go func(i int, pkg *packages.Package) {
+			defer wg.Done()
+			dumped[i], errs[i] = d.DumpPackage(pkg)
+		}(i, pkg)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
 	}
-	return dumped
+	return dumped, nil
 }
 
 // Convert a types.Package (with some extra info) to a
@@ -0,0 +1,801 @@
+package goblin
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"golang.org/x/tools/go/ast/astutil"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// With Config.Monomorphize, Load specializes the generic functions and
+// types of the main package. Every instantiation reachable from its
+// non-generic code (directly, or through the copies of other
+// instantiations) gets a copy of the generic declaration, and of each
+// method of a generic type, with the type arguments in place of the
+// type parameters. Copies are named after the instance (see mangle),
+// uses of the instance are renamed to refer to the copy whether their
+// type arguments are written or inferred, and the file is typechecked
+// again so that the copies are dumped with their own type information.
+// Each copy is dumped with "instance-of", the ID of the generic
+// declaration, and its "type-args".
+//
+// The generic declarations are left in place, but nothing outside of
+// them refers to them any more. A copy has the positions of the
+// declaration it was copied from, in a file named after the instance
+// ("main.go#Mapᐸintᐧstringᐳ"), so that the objects and scopes of
+// different copies have different IDs.
+//
+// Instantiations of generics from other packages are left as they are
+// (and uses of them are annotated with their "instance", as usual), as
+// are instantiations with a type argument that can't be named outside
+// of the function it is declared in, or outside of the package it is
+// declared in if it isn't exported. Each use of an instantiation left
+// generic is reported as a "monomorphize_skipped" diagnostic.
+
+// A specialized copy of a generic function, type or method.
+type specialization struct {
+	origin types.Object // the generic function, type or method
+	decl   ast.Node     // its declaration (a FuncDecl or TypeSpec)
+	args   []types.Type
+	name   string // of the copy
+	recv   string // the name of the type's copy, for a method
+}
+
+// Beyond this, instantiation is likely to be unbounded (as in a
+// function F[T] that calls F[[]T]).
+const maxSpecializations = 1000
+
+type monomorphizer struct {
+	fset *token.FileSet
+	file *ast.File
+	pkg  *types.Package
+	info *types.Info
+
+	// The generic declarations of the file, by object (methods by
+	// the generic type).
+	funcs   map[types.Object]*ast.FuncDecl
+	types   map[types.Object]*ast.TypeSpec
+	methods map[types.Object][]*ast.FuncDecl
+	parents map[*ast.TypeSpec]*ast.GenDecl
+
+	// Specializations, in the order they were found.
+	seen   map[string]bool
+	queue  []*specialization
+	decls  []ast.Decl
+	copies map[ast.Node]*specialization
+
+	origins map[*ast.Ident]*ast.Ident // copied identifiers to the originals
+	mirrors map[string]*token.File    // by instance
+
+	// Uses of instantiations left generic.
+	skipped   []*Error
+	skippedAt map[*ast.Ident]bool
+}
+
+// Specialize the generics of f, which has been checked as pkg with
+// info, and check it again with conf. Returns the new package and type
+// information and the specialized copies, or nils if there's nothing
+// to specialize, and the uses of instantiations it left generic.
+func monomorphize(conf *types.Config, fset *token.FileSet, f *ast.File, pkg *types.Package, info *types.Info) (_ *types.Package, _ *types.Info, copies map[ast.Node]*specialization, skipped []*Error, err error) {
+	defer catch(&err)
+
+	m := &monomorphizer{
+		fset:    fset,
+		file:    f,
+		pkg:     pkg,
+		info:    info,
+		funcs:   map[types.Object]*ast.FuncDecl{},
+		types:   map[types.Object]*ast.TypeSpec{},
+		methods: map[types.Object][]*ast.FuncDecl{},
+		parents: map[*ast.TypeSpec]*ast.GenDecl{},
+		seen:    map[string]bool{},
+		copies:  map[ast.Node]*specialization{},
+		origins: map[*ast.Ident]*ast.Ident{},
+		mirrors: map[string]*token.File{},
+
+		skippedAt: map[*ast.Ident]bool{},
+	}
+	m.collect()
+
+	// Rename the instances used by non-generic code, then copy what
+	// they (and the copies) use.
+	for _, decl := range f.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if !m.isGeneric(decl) {
+				m.rewrite(decl, nil)
+			}
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				if !m.isGeneric(spec) {
+					m.rewrite(spec, nil)
+				}
+			}
+		}
+	}
+	for i := 0; i < len(m.queue); i++ {
+		m.specialize(m.queue[i])
+	}
+	if len(m.decls) == 0 {
+		return nil, nil, nil, m.skipped, nil
+	}
+	f.Decls = append(f.Decls, m.decls...)
+
+	// Typecheck the file again. Renaming explicit type arguments
+	// may leave imports unused, which is harmless.
+	recheck := *conf
+	var errs []error
+	recheck.Error = func(err error) {
+		if e, ok := err.(types.Error); !ok || !e.Soft {
+			errs = append(errs, err)
+		}
+	}
+	newInfo := newTypesInfo()
+	newPkg, _ := recheck.Check(pkg.Path(), fset, []*ast.File{f}, newInfo)
+	if len(errs) > 0 {
+		e := convertError(errs[0], fset)
+		fail(e.Position, "monomorphize_error", e.Reason)
+	}
+
+	// The type arguments of the copies, in terms of the new package.
+	for _, s := range m.copies {
+		args := make([]types.Type, len(s.args))
+		for i, t := range s.args {
+			args[i] = m.translate(t, newPkg)
+		}
+		s.args = args
+	}
+	return newPkg, newInfo, m.copies, m.skipped, nil
+}
+
+// Find the generic declarations of the file.
+func (m *monomorphizer) collect() {
+	for _, decl := range m.file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			fn, ok := m.info.Defs[decl.Name].(*types.Func)
+			if !ok {
+				continue
+			}
+			sig := fn.Type().(*types.Signature)
+			if sig.TypeParams().Len() > 0 {
+				m.funcs[fn] = decl
+			} else if sig.RecvTypeParams().Len() > 0 {
+				recv := sig.Recv().Type()
+				if p, ok := recv.(*types.Pointer); ok {
+					recv = p.Elem()
+				}
+				if named, ok := recv.(*types.Named); ok {
+					obj := named.Origin().Obj()
+					m.methods[obj] = append(m.methods[obj], decl)
+				}
+			}
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				if spec, ok := spec.(*ast.TypeSpec); ok && spec.TypeParams != nil {
+					if obj := m.info.Defs[spec.Name]; obj != nil {
+						m.types[obj] = spec
+						m.parents[spec] = decl
+					}
+				}
+			}
+		}
+	}
+}
+
+func (m *monomorphizer) isGeneric(n ast.Node) bool {
+	switch n := n.(type) {
+	case *ast.FuncDecl:
+		if n.Type.TypeParams != nil {
+			return true
+		}
+		fn, ok := m.info.Defs[n.Name].(*types.Func)
+		return ok && fn.Type().(*types.Signature).RecvTypeParams().Len() > 0
+	case *ast.TypeSpec:
+		return n.TypeParams != nil
+	}
+	return false
+}
+
+// The name of the copy of a generic declaration for args, queueing it
+// (and the methods of a generic type) to be specialized if it's new.
+func (m *monomorphizer) instantiate(obj types.Object, args []types.Type) string {
+	name := mangle(obj.Name(), args, m.pkg)
+	if m.seen[name] {
+		return name
+	}
+	m.seen[name] = true
+	if len(m.seen) > maxSpecializations {
+		fail(m.fset.Position(obj.Pos()), "monomorphize_error", fmt.Sprintf("more than %d instantiations (is %s instantiated recursively?)", maxSpecializations, obj.Name()))
+	}
+
+	if decl := m.funcs[obj]; decl != nil {
+		m.queue = append(m.queue, &specialization{origin: obj, decl: decl, args: args, name: name})
+		return name
+	}
+	m.queue = append(m.queue, &specialization{origin: obj, decl: m.types[obj], args: args, name: name})
+	for _, decl := range m.methods[obj] {
+		m.queue = append(m.queue, &specialization{origin: m.info.Defs[decl.Name], decl: decl, args: args, name: decl.Name.Name, recv: name})
+	}
+	return name
+}
+
+// Copy a generic declaration for its type arguments.
+func (m *monomorphizer) specialize(s *specialization) {
+	instance := s.name
+	if s.recv != "" {
+		instance = s.recv
+	}
+	delta := m.mirror(instance, s.decl.Pos()).Base() - m.fset.File(s.decl.Pos()).Base()
+
+	var tparams *types.TypeParamList
+	var copied ast.Node
+	switch decl := s.decl.(type) {
+	case *ast.FuncDecl:
+		c := m.copy(decl, delta).(*ast.FuncDecl)
+		sig := s.origin.Type().(*types.Signature)
+		if s.recv != "" {
+			tparams = sig.RecvTypeParams()
+			c.Recv.List[0].Type = recvType(c.Recv.List[0].Type, s.recv)
+		} else {
+			tparams = sig.TypeParams()
+			c.Name.Name = s.name
+		}
+		c.Type.TypeParams = nil
+		m.decls = append(m.decls, c)
+		copied = c
+	case *ast.TypeSpec:
+		// In a declaration of its own, documented like the
+		// original if that is too.
+		parent := m.parents[decl]
+		g := &ast.GenDecl{TokPos: parent.TokPos, Tok: token.TYPE, Specs: []ast.Spec{decl}}
+		if !parent.Lparen.IsValid() {
+			g.Doc = parent.Doc
+		}
+		g = m.copy(g, delta).(*ast.GenDecl)
+		c := g.Specs[0].(*ast.TypeSpec)
+		tparams = s.origin.Type().(*types.Named).TypeParams()
+		c.Name.Name = s.name
+		c.TypeParams = nil
+		m.decls = append(m.decls, g)
+		copied = c
+	}
+
+	subst := map[*types.TypeParam]types.Type{}
+	for i := 0; i < tparams.Len(); i++ {
+		subst[tparams.At(i)] = s.args[i]
+	}
+	m.rewrite(copied, subst)
+	m.copies[copied] = s
+}
+
+// The receiver type of a method's copy: that of the generic method,
+// with the instantiated type replaced by the name of its copy.
+func recvType(x ast.Expr, name string) ast.Expr {
+	switch t := x.(type) {
+	case *ast.StarExpr:
+		t.X = recvType(t.X, name)
+		return t
+	case *ast.ParenExpr:
+		t.X = recvType(t.X, name)
+		return t
+	}
+	return &ast.Ident{NamePos: x.Pos(), Name: name}
+}
+
+// The file the copies of an instance are positioned in: a copy of the
+// lines of the one the generic declaration at pos is in.
+func (m *monomorphizer) mirror(instance string, pos token.Pos) *token.File {
+	if mirror := m.mirrors[instance]; mirror != nil {
+		return mirror
+	}
+	file := m.fset.File(pos)
+	mirror := m.fset.AddFile(file.Name()+"#"+instance, -1, file.Size())
+	mirror.SetLines(file.Lines())
+	m.mirrors[instance] = mirror
+	return mirror
+}
+
+var (
+	posType       = reflect.TypeOf(token.NoPos)
+	astObjectType = reflect.TypeOf((*ast.Object)(nil))
+	astScopeType  = reflect.TypeOf((*ast.Scope)(nil))
+)
+
+// A deep copy of n, moved delta along the FileSet. The (deprecated)
+// objects of the parser aren't copied.
+func (m *monomorphizer) copy(n ast.Node, delta int) ast.Node {
+	return m.copyValue(reflect.ValueOf(n), token.Pos(delta)).Interface().(ast.Node)
+}
+
+func (m *monomorphizer) copyValue(v reflect.Value, delta token.Pos) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() || v.Type() == astObjectType || v.Type() == astScopeType {
+			return reflect.Zero(v.Type())
+		}
+		c := reflect.New(v.Type().Elem())
+		c.Elem().Set(m.copyValue(v.Elem(), delta))
+		if i, ok := v.Interface().(*ast.Ident); ok {
+			m.origins[c.Interface().(*ast.Ident)] = m.origin(i)
+		}
+		return c
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(m.copyValue(v.Elem(), delta))
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(m.copyValue(v.Index(i), delta))
+		}
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			c.Field(i).Set(m.copyValue(v.Field(i), delta))
+		}
+		return c
+	}
+	if v.Type() == posType && token.Pos(v.Int()).IsValid() {
+		return reflect.ValueOf(token.Pos(v.Int()) + delta)
+	}
+	return v
+}
+
+// The identifier i was copied from, which the type information is
+// about.
+func (m *monomorphizer) origin(i *ast.Ident) *ast.Ident {
+	if orig := m.origins[i]; orig != nil {
+		return orig
+	}
+	return i
+}
+
+// Rename the instances n uses to their copies, and replace the type
+// parameters in subst with the types they stand for.
+func (m *monomorphizer) rewrite(n ast.Node, subst map[*types.TypeParam]types.Type) {
+	astutil.Apply(n, func(c *astutil.Cursor) bool {
+		switch x := c.Node().(type) {
+		case *ast.IndexExpr:
+			if name, ok := m.instance(x.X, subst); ok {
+				c.Replace(&ast.Ident{NamePos: x.Pos(), Name: name})
+				return false
+			}
+		case *ast.IndexListExpr:
+			if name, ok := m.instance(x.X, subst); ok {
+				c.Replace(&ast.Ident{NamePos: x.Pos(), Name: name})
+				return false
+			}
+		case *ast.Ident:
+			if name, ok := m.instance(x, subst); ok {
+				c.Replace(&ast.Ident{NamePos: x.Pos(), Name: name})
+			} else if tn, ok := m.info.Uses[m.origin(x)].(*types.TypeName); ok {
+				if tp, ok := tn.Type().(*types.TypeParam); ok && subst[tp] != nil {
+					c.Replace(m.typeExpr(subst[tp], x.Pos()))
+				}
+			}
+		}
+		return true
+	}, nil)
+}
+
+// The name of the copy for a use x of a generic declaration of the
+// file, if it is one.
+func (m *monomorphizer) instance(x ast.Expr, subst map[*types.TypeParam]types.Type) (string, bool) {
+	i, ok := ast.Unparen(x).(*ast.Ident)
+	if !ok {
+		return "", false
+	}
+	inst, ok := m.info.Instances[m.origin(i)]
+	if !ok {
+		return "", false
+	}
+	obj := m.info.Uses[m.origin(i)]
+	args := make([]types.Type, inst.TypeArgs.Len())
+	for j := range args {
+		args[j] = m.subst(inst.TypeArgs.At(j), subst)
+	}
+	if m.funcs[obj] == nil && m.types[obj] == nil {
+		if obj != nil && obj.Pkg() != nil && obj.Pkg() != m.pkg {
+			m.skip(i, obj, args, "it is declared in package "+obj.Pkg().Path())
+		}
+		return "", false
+	}
+	for _, t := range args {
+		if reason := m.unnameable(t); reason != "" {
+			m.skip(i, obj, args, reason)
+			return "", false
+		}
+	}
+	return m.instantiate(obj, args), true
+}
+
+// Report the use i of obj for args as left generic, once (an
+// instantiation is looked up both as the identifier and as the index
+// expression around it).
+func (m *monomorphizer) skip(i *ast.Ident, obj types.Object, args []types.Type, reason string) {
+	if m.skippedAt[i] {
+		return
+	}
+	m.skippedAt[i] = true
+	names := make([]string, len(args))
+	for j, t := range args {
+		names[j] = types.TypeString(t, types.RelativeTo(m.pkg))
+	}
+	instance := obj.Name()
+	if obj.Pkg() != m.pkg {
+		instance = obj.Pkg().Name() + "." + instance
+	}
+	m.skipped = append(m.skipped, &Error{
+		Type:     "monomorphize_skipped",
+		Reason:   fmt.Sprintf("%s[%s] isn't specialized: %s", instance, strings.Join(names, ", "), reason),
+		Position: m.fset.Position(i.Pos()),
+	})
+}
+
+// Why a copy couldn't refer to t, or "" if it can.
+func (m *monomorphizer) unnameable(t types.Type) string {
+	var reason string
+	mapType(t, func(t types.Type) types.Type {
+		if reason != "" {
+			return t
+		}
+		named, ok := t.(*types.Named)
+		if !ok {
+			return nil
+		}
+		obj := named.Obj()
+		if obj.Pkg() != nil && obj.Parent() != obj.Pkg().Scope() {
+			reason = obj.Name() + " is declared in a function"
+		} else if obj.Pkg() != nil && obj.Pkg() != m.pkg && !obj.Exported() {
+			reason = types.TypeString(named, nil) + " isn't exported"
+		}
+		for i := 0; i < named.TypeArgs().Len() && reason == ""; i++ {
+			reason = m.unnameable(named.TypeArgs().At(i))
+		}
+		return named
+	})
+	return reason
+}
+
+// Replace the type parameters in t, and instances of the generic types
+// of the file with their copies.
+func (m *monomorphizer) subst(t types.Type, subst map[*types.TypeParam]types.Type) types.Type {
+	return mapType(t, func(t types.Type) types.Type {
+		switch t := t.(type) {
+		case *types.TypeParam:
+			return subst[t]
+		case *types.Named:
+			if t.TypeArgs().Len() == 0 {
+				return t
+			}
+			args := make([]types.Type, t.TypeArgs().Len())
+			for i := range args {
+				args[i] = m.subst(t.TypeArgs().At(i), subst)
+			}
+			inst, err := types.Instantiate(nil, t.Origin(), args, false)
+			if err != nil {
+				fail(m.fset.Position(t.Obj().Pos()), "monomorphize_error", err.Error())
+			}
+			return inst
+		}
+		return nil
+	})
+}
+
+// The type in the package newPkg (the main package checked again) that
+// t, from the main package as first checked, stands for.
+func (m *monomorphizer) translate(t types.Type, newPkg *types.Package) types.Type {
+	return mapType(t, func(t types.Type) types.Type {
+		named, ok := t.(*types.Named)
+		if !ok {
+			return nil
+		}
+		obj := named.Obj()
+		args := make([]types.Type, named.TypeArgs().Len())
+		for i := range args {
+			args[i] = m.translate(named.TypeArgs().At(i), newPkg)
+		}
+		if obj.Pkg() != m.pkg {
+			if len(args) == 0 {
+				return t
+			}
+			inst, err := types.Instantiate(nil, named.Origin(), args, false)
+			if err != nil {
+				fail(m.fset.Position(obj.Pos()), "monomorphize_error", err.Error())
+			}
+			return inst
+		}
+
+		m.checkNameable(obj)
+		name := obj.Name()
+		if len(args) > 0 {
+			name = mangle(name, typeList(named.TypeArgs()), m.pkg)
+		}
+		return newPkg.Scope().Lookup(name).Type()
+	})
+}
+
+// Copies can only refer to types declared at the package level.
+func (m *monomorphizer) checkNameable(obj *types.TypeName) {
+	if obj.Pkg() != nil && obj.Parent() != obj.Pkg().Scope() {
+		fail(m.fset.Position(obj.Pos()), "monomorphize_error", fmt.Sprintf("can't specialize for %s, which is declared in a function", obj.Name()))
+	}
+}
+
+func typeList(l *types.TypeList) []types.Type {
+	list := make([]types.Type, l.Len())
+	for i := range list {
+		list[i] = l.At(i)
+	}
+	return list
+}
+
+// Rebuild t with f applied to each of its components, from the top
+// down, until f returns non-nil.
+func mapType(t types.Type, f func(types.Type) types.Type) types.Type {
+	if u := f(t); u != nil {
+		return u
+	}
+	rec := func(t types.Type) types.Type {
+		return mapType(t, f)
+	}
+	tuple := func(t *types.Tuple) *types.Tuple {
+		vars := make([]*types.Var, t.Len())
+		for i := range vars {
+			v := t.At(i)
+			vars[i] = types.NewParam(v.Pos(), v.Pkg(), v.Name(), rec(v.Type()))
+		}
+		return types.NewTuple(vars...)
+	}
+
+	switch t := t.(type) {
+	case *types.Alias:
+		return rec(types.Unalias(t))
+	case *types.Pointer:
+		return types.NewPointer(rec(t.Elem()))
+	case *types.Slice:
+		return types.NewSlice(rec(t.Elem()))
+	case *types.Array:
+		return types.NewArray(rec(t.Elem()), t.Len())
+	case *types.Map:
+		return types.NewMap(rec(t.Key()), rec(t.Elem()))
+	case *types.Chan:
+		return types.NewChan(t.Dir(), rec(t.Elem()))
+	case *types.Tuple:
+		return tuple(t)
+	case *types.Signature:
+		return types.NewSignatureType(nil, nil, nil, tuple(t.Params()), tuple(t.Results()), t.Variadic())
+	case *types.Struct:
+		fields := make([]*types.Var, t.NumFields())
+		tags := make([]string, t.NumFields())
+		for i := range fields {
+			v := t.Field(i)
+			fields[i] = types.NewField(v.Pos(), v.Pkg(), v.Name(), rec(v.Type()), v.Embedded())
+			tags[i] = t.Tag(i)
+		}
+		return types.NewStruct(fields, tags)
+	case *types.Interface:
+		methods := make([]*types.Func, t.NumExplicitMethods())
+		for i := range methods {
+			fn := t.ExplicitMethod(i)
+			methods[i] = types.NewFunc(fn.Pos(), fn.Pkg(), fn.Name(), rec(fn.Type()).(*types.Signature))
+		}
+		embeddeds := make([]types.Type, t.NumEmbeddeds())
+		for i := range embeddeds {
+			embeddeds[i] = rec(t.EmbeddedType(i))
+		}
+		return types.NewInterfaceType(methods, embeddeds).Complete()
+	case *types.Union:
+		terms := make([]*types.Term, t.Len())
+		for i := range terms {
+			terms[i] = types.NewTerm(t.Term(i).Tilde(), rec(t.Term(i).Type()))
+		}
+		return types.NewUnion(terms)
+	}
+	return t
+}
+
+// A type expression for t, positioned at pos, in the file.
+func (m *monomorphizer) typeExpr(t types.Type, pos token.Pos) ast.Expr {
+	rec := func(t types.Type) ast.Expr {
+		return m.typeExpr(t, pos)
+	}
+	ident := func(name string) *ast.Ident {
+		return &ast.Ident{NamePos: pos, Name: name}
+	}
+	fields := func(t *types.Tuple, variadic bool) *ast.FieldList {
+		// Names are kept only if every parameter has one.
+		named := true
+		for i := 0; i < t.Len(); i++ {
+			named = named && t.At(i).Name() != ""
+		}
+		list := &ast.FieldList{Opening: pos, Closing: pos}
+		for i := 0; i < t.Len(); i++ {
+			field := &ast.Field{Type: rec(t.At(i).Type())}
+			if variadic && i == t.Len()-1 {
+				field.Type = &ast.Ellipsis{Ellipsis: pos, Elt: rec(t.At(i).Type().(*types.Slice).Elem())}
+			}
+			if named {
+				field.Names = []*ast.Ident{ident(t.At(i).Name())}
+			}
+			list.List = append(list.List, field)
+		}
+		return list
+	}
+
+	switch t := t.(type) {
+	case *types.Basic:
+		if t.Kind() == types.UnsafePointer {
+			return &ast.SelectorExpr{X: ident(m.importName(types.Unsafe)), Sel: ident("Pointer")}
+		}
+		return ident(t.Name())
+	case *types.Alias:
+		return rec(types.Unalias(t))
+	case *types.Named:
+		obj := t.Obj()
+		m.checkNameable(obj)
+		if t.TypeArgs().Len() > 0 && obj.Pkg() == m.pkg {
+			return ident(m.instantiate(t.Origin().Obj(), typeList(t.TypeArgs())))
+		}
+
+		var x ast.Expr = ident(obj.Name())
+		if obj.Pkg() != nil && obj.Pkg() != m.pkg {
+			if !obj.Exported() {
+				fail(m.fset.Position(pos), "monomorphize_error", fmt.Sprintf("can't specialize for %s, which isn't exported", types.TypeString(t, nil)))
+			}
+			x = &ast.SelectorExpr{X: ident(m.importName(obj.Pkg())), Sel: ident(obj.Name())}
+		}
+		if t.TypeArgs().Len() == 0 {
+			return x
+		}
+		args := make([]ast.Expr, t.TypeArgs().Len())
+		for i := range args {
+			args[i] = rec(t.TypeArgs().At(i))
+		}
+		return &ast.IndexListExpr{X: x, Lbrack: pos, Indices: args, Rbrack: pos}
+	case *types.Pointer:
+		return &ast.StarExpr{Star: pos, X: rec(t.Elem())}
+	case *types.Slice:
+		return &ast.ArrayType{Lbrack: pos, Elt: rec(t.Elem())}
+	case *types.Array:
+		length := &ast.BasicLit{ValuePos: pos, Kind: token.INT, Value: strconv.FormatInt(t.Len(), 10)}
+		return &ast.ArrayType{Lbrack: pos, Len: length, Elt: rec(t.Elem())}
+	case *types.Map:
+		return &ast.MapType{Map: pos, Key: rec(t.Key()), Value: rec(t.Elem())}
+	case *types.Chan:
+		dir := ast.SEND | ast.RECV
+		switch t.Dir() {
+		case types.SendOnly:
+			dir = ast.SEND
+		case types.RecvOnly:
+			dir = ast.RECV
+		}
+		return &ast.ChanType{Begin: pos, Dir: dir, Value: rec(t.Elem())}
+	case *types.Signature:
+		return &ast.FuncType{Func: pos, Params: fields(t.Params(), t.Variadic()), Results: fields(t.Results(), false)}
+	case *types.Struct:
+		list := &ast.FieldList{Opening: pos, Closing: pos}
+		for i := 0; i < t.NumFields(); i++ {
+			v := t.Field(i)
+			field := &ast.Field{Type: rec(v.Type())}
+			if !v.Embedded() {
+				field.Names = []*ast.Ident{ident(v.Name())}
+			}
+			if tag := t.Tag(i); tag != "" {
+				field.Tag = &ast.BasicLit{ValuePos: pos, Kind: token.STRING, Value: strconv.Quote(tag)}
+			}
+			list.List = append(list.List, field)
+		}
+		return &ast.StructType{Struct: pos, Fields: list}
+	case *types.Interface:
+		list := &ast.FieldList{Opening: pos, Closing: pos}
+		for i := 0; i < t.NumEmbeddeds(); i++ {
+			list.List = append(list.List, &ast.Field{Type: rec(t.EmbeddedType(i))})
+		}
+		for i := 0; i < t.NumExplicitMethods(); i++ {
+			fn := t.ExplicitMethod(i)
+			list.List = append(list.List, &ast.Field{Names: []*ast.Ident{ident(fn.Name())}, Type: rec(fn.Type())})
+		}
+		return &ast.InterfaceType{Interface: pos, Methods: list}
+	}
+	fail(m.fset.Position(pos), "monomorphize_error", fmt.Sprintf("can't specialize for %s", t))
+	return nil
+}
+
+// The name package p is imported as in the file, importing it if it
+// isn't (a type argument can be inferred from a package the file
+// doesn't import).
+func (m *monomorphizer) importName(p *types.Package) string {
+	for _, spec := range m.file.Imports {
+		if path, _ := strconv.Unquote(spec.Path.Value); path != p.Path() {
+			continue
+		}
+		if spec.Name == nil {
+			return p.Name()
+		} else if spec.Name.Name != "_" && spec.Name.Name != "." {
+			return spec.Name.Name
+		}
+	}
+	spec := &ast.ImportSpec{Path: &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(p.Path())}}
+	m.file.Imports = append(m.file.Imports, spec)
+	m.file.Decls = append([]ast.Decl{&ast.GenDecl{Tok: token.IMPORT, Specs: []ast.Spec{spec}}}, m.file.Decls...)
+	return p.Name()
+}
+
+// The name of the copy of the generic function or type name for args:
+// name, then the type arguments between ᐸ and ᐳ and separated by ᐧ
+// (letters of Canadian syllabics, as Go identifiers can have any
+// letter). Types of other packages are qualified with their path.
+// Within the type arguments, [, ], commas, dots, slashes and stars are
+// written as ᐸ, ᐳ, ᐧ, ꓸ, ᐟ and ᐦ, and other characters that can't be
+// in an identifier as their hexadecimal code between ᐝs, so that Map
+// for int and string is Mapᐸintᐧstringᐳ and Box for []*bytes.Buffer
+// is BoxᐸᐸᐳᐦbytesꓸBufferᐳ.
+func mangle(name string, args []types.Type, pkg *types.Package) string {
+	qualifier := func(p *types.Package) string {
+		if p == pkg {
+			return ""
+		}
+		return p.Path()
+	}
+
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteRune('ᐸ')
+	for i, t := range args {
+		if i > 0 {
+			b.WriteRune('ᐧ')
+		}
+		for _, r := range types.TypeString(t, qualifier) {
+			switch {
+			case r == '[':
+				b.WriteRune('ᐸ')
+			case r == ']':
+				b.WriteRune('ᐳ')
+			case r == ',':
+				b.WriteRune('ᐧ')
+			case r == '.':
+				b.WriteRune('ꓸ')
+			case r == '/':
+				b.WriteRune('ᐟ')
+			case r == '*':
+				b.WriteRune('ᐦ')
+			case r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+				b.WriteRune(r)
+			default:
+				fmt.Fprintf(&b, "ᐝ%xᐝ", r)
+			}
+		}
+	}
+	b.WriteRune('ᐳ')
+	return b.String()
+}
+
+// Add the generic declaration n is a specialized copy of (see
+// monomorphize) and its type arguments, if it is one.
+func (d *Dumper) withSpecialization(res map[string]interface{}, n ast.Node) map[string]interface{} {
+	s := d.specializations[n]
+	if s == nil {
+		return res
+	}
+	args := make([]map[string]interface{}, len(s.args))
+	for i, t := range s.args {
+		args[i] = d.dumpGoType(t)
+	}
+	res["instance-of"] = objectID(s.origin, d.Fset, d.Types.fieldOwner)
+	res["type-args"] = args
+	return res
+}
@@ -0,0 +1,1508 @@
+package goblin
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Go types for the nodes of the output format, for clients that would
+// rather unmarshal a dump than pick apart nested maps. There is one
+// struct per node shape (see schema/goblin.schema.json), with the same
+// field names.
+//
+// Fields that can hold several kinds of node have one of the wrapper
+// types Name, Expr, Type, Stmt, Decl, Spec or GoType, which pick the
+// right struct for a node by its kind and type when unmarshaling. A
+// wrapper whose Node is nil stands for null.
+
+type Position struct {
+	Filename string `json:"filename"`
+	Line     int    `json:"line"`
+	Offset   int    `json:"offset"`
+	Column   int    `json:"column"`
+
+	// A packed position (with Dumper.CompactPositions) is only that,
+	// and the fields above are zero. FileTable.Unpack unpacks it.
+	Packed *int `json:"-"`
+}
+
+func (p Position) MarshalJSON() ([]byte, error) {
+	if p.Packed != nil {
+		return json.Marshal(*p.Packed)
+	}
+	type position Position
+	return json.Marshal(position(p))
+}
+
+func (p *Position) UnmarshalJSON(data []byte) error {
+	var packed int
+	if string(data) == "null" {
+		return nil
+	} else if err := json.Unmarshal(data, &packed); err == nil {
+		*p = Position{Packed: &packed}
+		return nil
+	}
+	type position Position
+	return json.Unmarshal(data, (*position)(p))
+}
+
+// The files the packed positions of a file dump are in.
+type FileTable []*FileTableEntry
+
+type FileTableEntry struct {
+	Name  string `json:"name"`
+	Base  int    `json:"base"`
+	Size  int    `json:"size"`
+	Lines []int  `json:"lines"`
+}
+
+type Diagnostic struct {
+	Kind     string   `json:"kind"`
+	Type     string   `json:"type"`
+	Info     string   `json:"info"`
+	Position Position `json:"position"`
+}
+
+// The comment groups attached to a statement, declaration, spec, field
+// or file.
+type AttachedComments struct {
+	LeadingComments  [][]*CommentNode `json:"leading-comments,omitempty"`
+	TrailingComments [][]*CommentNode `json:"trailing-comments,omitempty"`
+}
+
+type CommentNode struct {
+	Kind     string   `json:"kind"`
+	Type     string   `json:"type"`
+	Text     string   `json:"text"`
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+}
+
+// Node interfaces. Type nodes are also expressions, and "bad" nodes
+// are all of expressions, types, statements and declarations.
+
+type NameNode interface{ nameNode() }
+type ExprNode interface{ exprNode() }
+type TypeNode interface {
+	ExprNode
+	typeNode()
+}
+type StmtNode interface{ stmtNode() }
+type DeclNode interface{ declNode() }
+type SpecNode interface{ specNode() }
+type GoTypeNode interface{ goTypeNode() }
+
+// An identifier, or one of the predeclared true, false and iota.
+type Name struct{ Node NameNode }
+
+// Any expression, including a type.
+type Expr struct{ Node ExprNode }
+
+type Type struct{ Node TypeNode }
+type Stmt struct{ Node StmtNode }
+type Decl struct{ Node DeclNode }
+type Spec struct{ Node SpecNode }
+type GoType struct{ Node GoTypeNode }
+
+// Identifiers.
+
+type IdentNode struct {
+	Kind      string    `json:"kind"`
+	IdentKind string    `json:"ident-kind"`
+	Value     string    `json:"value"`
+	ID        string    `json:"id,omitempty"`        // see ObjectID
+	RefersTo  string    `json:"refers-to,omitempty"` // see ObjectID
+	Instance  *Instance `json:"instance,omitempty"`
+	Position  Position  `json:"position"`
+	End       Position  `json:"end"`
+}
+
+// The type arguments and instantiated type or signature of a use of a
+// generic type or function.
+type Instance struct {
+	TypeArgs []GoType `json:"type-args"`
+	Type     GoType   `json:"type"`
+}
+
+// The literal true or false.
+type BoolLiteralNode struct {
+	Kind     string   `json:"kind"`
+	Type     string   `json:"type"`
+	Value    string   `json:"value"`
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+}
+
+type IotaNode struct {
+	Kind     string   `json:"kind"`
+	Type     string   `json:"type"`
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+}
+
+// Literals and constants.
+
+// An INT, FLOAT, IMAG, CHAR or STRING literal.
+type BasicLiteralNode struct {
+	Kind     string   `json:"kind"`
+	Type     string   `json:"type"`
+	Value    string   `json:"value"`
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+	GoType   *GoType  `json:"go-type,omitempty"`
+}
+
+type FuncLitNode struct {
+	Kind     string       `json:"kind"`
+	Type     string       `json:"type"`
+	Params   []*FieldNode `json:"params"`
+	Variadic *FieldNode   `json:"variadic"`
+	Results  []*FieldNode `json:"results"`
+	Body     []Stmt       `json:"body"`
+	Scope    string       `json:"scope,omitempty"`
+	Position Position     `json:"position"`
+	End      Position     `json:"end"`
+	GoType   *GoType      `json:"go-type,omitempty"`
+}
+
+type CompositeLitNode struct {
+	Kind     string   `json:"kind"`
+	Type     string   `json:"type"`
+	Declared Type     `json:"declared"`
+	Values   []Expr   `json:"values"`
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+	GoType   *GoType  `json:"go-type,omitempty"`
+}
+
+type ConstantNode struct {
+	Kind     string         `json:"kind"`
+	Value    *ConstantValue `json:"value"`
+	Position Position       `json:"position"`
+	End      Position       `json:"end"`
+	GoType   *GoType        `json:"go-type,omitempty"`
+}
+
+// The value of a constant: Value for BOOL, STRING and INT, Numerator
+// and Denominator for FLOAT and Real and Imag for COMPLEX.
+type ConstantValue struct {
+	Type        string
+	Value       string
+	Numerator   *ConstantValue
+	Denominator *ConstantValue
+	Real        *ConstantValue
+	Imag        *ConstantValue
+}
+
+// Expressions.
+
+// An identifier, possibly qualified by a package name.
+type IdentifierNode struct {
+	Kind      string   `json:"kind"`
+	Type      string   `json:"type"`
+	Qualifier *Name    `json:"qualifier,omitempty"`
+	Value     Name     `json:"value"`
+	Position  Position `json:"position"`
+	End       Position `json:"end"`
+	GoType    *GoType  `json:"go-type,omitempty"`
+}
+
+// The length of an array literal's type, as in [...]int.
+type EllipsisNode struct {
+	Kind     string   `json:"kind"`
+	Type     string   `json:"type"`
+	Value    Expr     `json:"value"`
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+	GoType   *GoType  `json:"go-type,omitempty"`
+}
+
+type BinaryNode struct {
+	Kind     string   `json:"kind"`
+	Type     string   `json:"type"`
+	Left     Expr     `json:"left"`
+	Right    Expr     `json:"right"`
+	Operator string   `json:"operator"`
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+	GoType   *GoType  `json:"go-type,omitempty"`
+}
+
+// An instantiation of a generic function.
+type InstantiationNode struct {
+	Kind      string    `json:"kind"`
+	Type      string    `json:"type"`
+	Target    Expr      `json:"target"`
+	Arguments []Type    `json:"arguments"`
+	Instance  *Instance `json:"instance,omitempty"`
+	Position  Position  `json:"position"`
+	End       Position  `json:"end"`
+	GoType    *GoType   `json:"go-type,omitempty"`
+}
+
+type IndexNode struct {
+	Kind     string   `json:"kind"`
+	Type     string   `json:"type"`
+	Target   Expr     `json:"target"`
+	Index    Expr     `json:"index"`
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+	GoType   *GoType  `json:"go-type,omitempty"`
+}
+
+// Synthesized stars (see Dumper.Desugar) have a position.
+type StarNode struct {
+	Kind        string   `json:"kind"`
+	Type        string   `json:"type"`
+	Target      Expr     `json:"target"`
+	Synthesized bool     `json:"synthesized,omitempty"`
+	Position    Position `json:"position"`
+	End         Position `json:"end"`
+	GoType      *GoType  `json:"go-type,omitempty"`
+}
+
+type NewNode struct {
+	Kind     string   `json:"kind"`
+	Type     string   `json:"type"`
+	Argument Type     `json:"argument"`
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+	GoType   *GoType  `json:"go-type,omitempty"`
+}
+
+type MakeNode struct {
+	Kind     string   `json:"kind"`
+	Type     string   `json:"type"`
+	Argument Type     `json:"argument"`
+	Rest     []Expr   `json:"rest"`
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+	GoType   *GoType  `json:"go-type,omitempty"`
+}
+
+type CastNode struct {
+	Kind      string   `json:"kind"`
+	Type      string   `json:"type"`
+	Target    Expr     `json:"target"`
+	CoercedTo Type     `json:"coerced-to"`
+	Position  Position `json:"position"`
+	End       Position `json:"end"`
+	GoType    *GoType  `json:"go-type,omitempty"`
+}
+
+type CallNode struct {
+	Kind      string   `json:"kind"`
+	Type      string   `json:"type"`
+	Function  Expr     `json:"function"`
+	Arguments []Expr   `json:"arguments"`
+	Ellipsis  bool     `json:"ellipsis"`
+	Position  Position `json:"position"`
+	End       Position `json:"end"`
+	GoType    *GoType  `json:"go-type,omitempty"`
+}
+
+type ParenNode struct {
+	Kind     string   `json:"kind"`
+	Type     string   `json:"type"`
+	Target   Expr     `json:"target"`
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+	GoType   *GoType  `json:"go-type,omitempty"`
+}
+
+type SelectorNode struct {
+	Kind        string     `json:"kind"`
+	Type        string     `json:"type"`
+	Target      Expr       `json:"target"`
+	Field       Name       `json:"field"`
+	Selection   *Selection `json:"selection,omitempty"`
+	Synthesized bool       `json:"synthesized,omitempty"`
+	Position    Position   `json:"position"`
+	End         Position   `json:"end"`
+	GoType      *GoType    `json:"go-type,omitempty"`
+}
+
+// What a selector selects (see types.Selection).
+type Selection struct {
+	SelectionKind string `json:"selection-kind"`
+	Index         []int  `json:"index"`
+	Indirect      bool   `json:"indirect"`
+	Receiver      GoType `json:"receiver"`
+}
+
+// A type assertion. Asserted is null in a type switch.
+type TypeAssertNode struct {
+	Kind     string   `json:"kind"`
+	Type     string   `json:"type"`
+	Target   Expr     `json:"target"`
+	Asserted Type     `json:"asserted"`
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+	GoType   *GoType  `json:"go-type,omitempty"`
+}
+
+type UnaryNode struct {
+	Kind        string   `json:"kind"`
+	Type        string   `json:"type"`
+	Target      Expr     `json:"target"`
+	Operator    string   `json:"operator"`
+	Synthesized bool     `json:"synthesized,omitempty"`
+	Position    Position `json:"position"`
+	End         Position `json:"end"`
+	GoType      *GoType  `json:"go-type,omitempty"`
+}
+
+type SliceNode struct {
+	Kind     string   `json:"kind"`
+	Type     string   `json:"type"`
+	Target   Expr     `json:"target"`
+	Low      Expr     `json:"low"`
+	High     Expr     `json:"high"`
+	Max      Expr     `json:"max"`
+	Three    bool     `json:"three"`
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+	GoType   *GoType  `json:"go-type,omitempty"`
+}
+
+type KeyValueNode struct {
+	Kind     string   `json:"kind"`
+	Type     string   `json:"type"`
+	Key      Expr     `json:"key"`
+	Value    Expr     `json:"value"`
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+	GoType   *GoType  `json:"go-type,omitempty"`
+}
+
+// The source range of an expression, type, statement or declaration
+// the parser couldn't make sense of.
+type BadNode struct {
+	Kind string   `json:"kind"`
+	Type string   `json:"type"`
+	From Position `json:"from"`
+	To   Position `json:"to"`
+	AttachedComments
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+}
+
+// Types.
+
+// A type name, possibly qualified by a package name.
+type TypeIdentifierNode struct {
+	Kind      string   `json:"kind"`
+	Type      string   `json:"type"`
+	Qualifier *Name    `json:"qualifier,omitempty"`
+	Value     Name     `json:"value"`
+	Position  Position `json:"position"`
+	End       Position `json:"end"`
+	GoType    *GoType  `json:"go-type,omitempty"`
+}
+
+type SliceTypeNode struct {
+	Kind     string   `json:"kind"`
+	Type     string   `json:"type"`
+	Element  Type     `json:"element"`
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+	GoType   *GoType  `json:"go-type,omitempty"`
+}
+
+type ArrayTypeNode struct {
+	Kind     string   `json:"kind"`
+	Type     string   `json:"type"`
+	Element  Type     `json:"element"`
+	Length   Expr     `json:"length"`
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+	GoType   *GoType  `json:"go-type,omitempty"`
+}
+
+type PointerTypeNode struct {
+	Kind      string   `json:"kind"`
+	Type      string   `json:"type"`
+	Contained Type     `json:"contained"`
+	Position  Position `json:"position"`
+	End       Position `json:"end"`
+	GoType    *GoType  `json:"go-type,omitempty"`
+}
+
+type InterfaceTypeNode struct {
+	Kind       string       `json:"kind"`
+	Type       string       `json:"type"`
+	Incomplete bool         `json:"incomplete"`
+	Methods    []*FieldNode `json:"methods"`
+	Position   Position     `json:"position"`
+	End        Position     `json:"end"`
+	GoType     *GoType      `json:"go-type,omitempty"`
+}
+
+type MapTypeNode struct {
+	Kind     string   `json:"kind"`
+	Type     string   `json:"type"`
+	Key      Type     `json:"key"`
+	Value    Type     `json:"value"`
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+	GoType   *GoType  `json:"go-type,omitempty"`
+}
+
+type ChanTypeNode struct {
+	Kind      string   `json:"kind"`
+	Type      string   `json:"type"`
+	Direction string   `json:"direction"`
+	Value     Type     `json:"value"`
+	Position  Position `json:"position"`
+	End       Position `json:"end"`
+	GoType    *GoType  `json:"go-type,omitempty"`
+}
+
+type StructTypeNode struct {
+	Kind     string       `json:"kind"`
+	Type     string       `json:"type"`
+	Fields   []*FieldNode `json:"fields"`
+	Position Position     `json:"position"`
+	End      Position     `json:"end"`
+	GoType   *GoType      `json:"go-type,omitempty"`
+}
+
+type FuncTypeNode struct {
+	Kind     string       `json:"kind"`
+	Type     string       `json:"type"`
+	Params   []*FieldNode `json:"params"`
+	Variadic *FieldNode   `json:"variadic"`
+	Results  []*FieldNode `json:"results"`
+	Position Position     `json:"position"`
+	End      Position     `json:"end"`
+	GoType   *GoType      `json:"go-type,omitempty"`
+}
+
+// An instantiation of a generic type.
+type TypeInstantiationNode struct {
+	Kind      string    `json:"kind"`
+	Type      string    `json:"type"`
+	Target    Type      `json:"target"`
+	Arguments []Type    `json:"arguments"`
+	Instance  *Instance `json:"instance,omitempty"`
+	Position  Position  `json:"position"`
+	End       Position  `json:"end"`
+	GoType    *GoType   `json:"go-type,omitempty"`
+}
+
+type UnionTypeNode struct {
+	Kind     string   `json:"kind"`
+	Type     string   `json:"type"`
+	Terms    []Type   `json:"terms"`
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+	GoType   *GoType  `json:"go-type,omitempty"`
+}
+
+type TildeTypeNode struct {
+	Kind     string   `json:"kind"`
+	Type     string   `json:"type"`
+	Term     Type     `json:"term"`
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+	GoType   *GoType  `json:"go-type,omitempty"`
+}
+
+// The type of a variadic parameter.
+type EllipsisTypeNode struct {
+	Kind     string   `json:"kind"`
+	Type     string   `json:"type"`
+	Value    Type     `json:"value"`
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+	GoType   *GoType  `json:"go-type,omitempty"`
+}
+
+type FieldNode struct {
+	Kind         string            `json:"kind"`
+	Names        []Name            `json:"names"`
+	DeclaredType Type              `json:"declared-type"`
+	Tag          *BasicLiteralNode `json:"tag"`
+	AttachedComments
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+}
+
+// Statements.
+
+type ReturnNode struct {
+	Kind   string `json:"kind"`
+	Type   string `json:"type"`
+	Values []Expr `json:"values"`
+	AttachedComments
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+}
+
+// An assign, define or assign-operator statement. Only the last has
+// an operator.
+type AssignNode struct {
+	Kind     string `json:"kind"`
+	Type     string `json:"type"`
+	Operator string `json:"operator,omitempty"`
+	Left     []Expr `json:"left"`
+	Right    []Expr `json:"right"`
+	AttachedComments
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+}
+
+type EmptyNode struct {
+	Kind string `json:"kind"`
+	Type string `json:"type"`
+	AttachedComments
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+}
+
+type ExprStmtNode struct {
+	Kind  string `json:"kind"`
+	Type  string `json:"type"`
+	Value Expr   `json:"value"`
+	AttachedComments
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+}
+
+type LabeledNode struct {
+	Kind      string `json:"kind"`
+	Type      string `json:"type"`
+	Label     Name   `json:"label"`
+	Statement Stmt   `json:"statement"`
+	AttachedComments
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+}
+
+// A break, continue or goto statement.
+type BranchNode struct {
+	Kind  string `json:"kind"`
+	Type  string `json:"type"`
+	Label Name   `json:"label"`
+	AttachedComments
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+}
+
+type FallthroughNode struct {
+	Kind string `json:"kind"`
+	Type string `json:"type"`
+	AttachedComments
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+}
+
+type RangeNode struct {
+	Kind     string `json:"kind"`
+	Type     string `json:"type"`
+	Key      Expr   `json:"key"`
+	Value    Expr   `json:"value"`
+	Target   Expr   `json:"target"`
+	IsAssign bool   `json:"is-assign"`
+	Body     []Stmt `json:"body"`
+	Scope    string `json:"scope,omitempty"`
+	AttachedComments
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+}
+
+type DeclStmtNode struct {
+	Kind   string `json:"kind"`
+	Type   string `json:"type"`
+	Target Decl   `json:"target"`
+	AttachedComments
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+}
+
+type DeferNode struct {
+	Kind   string `json:"kind"`
+	Type   string `json:"type"`
+	Target Expr   `json:"target"`
+	AttachedComments
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+}
+
+type GoStmtNode struct {
+	Kind   string `json:"kind"`
+	Type   string `json:"type"`
+	Target Expr   `json:"target"`
+	AttachedComments
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+}
+
+type IfNode struct {
+	Kind      string `json:"kind"`
+	Type      string `json:"type"`
+	Init      Stmt   `json:"init"`
+	Condition Expr   `json:"condition"`
+	Body      []Stmt `json:"body"`
+	Else      Stmt   `json:"else"`
+	Scope     string `json:"scope,omitempty"`
+	AttachedComments
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+}
+
+type BlockNode struct {
+	Kind  string `json:"kind"`
+	Type  string `json:"type"`
+	Body  []Stmt `json:"body"`
+	Scope string `json:"scope,omitempty"`
+	AttachedComments
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+}
+
+type ForNode struct {
+	Kind      string `json:"kind"`
+	Type      string `json:"type"`
+	Init      Stmt   `json:"init"`
+	Condition Expr   `json:"condition"`
+	Post      Stmt   `json:"post"`
+	Body      []Stmt `json:"body"`
+	Scope     string `json:"scope,omitempty"`
+	AttachedComments
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+}
+
+type SendNode struct {
+	Kind    string `json:"kind"`
+	Type    string `json:"type"`
+	Channel Expr   `json:"channel"`
+	Value   Expr   `json:"value"`
+	AttachedComments
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+}
+
+type SelectNode struct {
+	Kind string `json:"kind"`
+	Type string `json:"type"`
+	Body []Stmt `json:"body"`
+	AttachedComments
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+}
+
+type CrementNode struct {
+	Kind      string `json:"kind"`
+	Type      string `json:"type"`
+	Target    Expr   `json:"target"`
+	Operation string `json:"operation"`
+	AttachedComments
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+}
+
+type SwitchNode struct {
+	Kind      string `json:"kind"`
+	Type      string `json:"type"`
+	Init      Stmt   `json:"init"`
+	Condition Expr   `json:"condition"`
+	Body      []Stmt `json:"body"`
+	Scope     string `json:"scope,omitempty"`
+	AttachedComments
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+}
+
+type TypeSwitchNode struct {
+	Kind   string `json:"kind"`
+	Type   string `json:"type"`
+	Init   Stmt   `json:"init"`
+	Assign Stmt   `json:"assign"`
+	Body   []Stmt `json:"body"`
+	Scope  string `json:"scope,omitempty"`
+	AttachedComments
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+}
+
+type SelectClauseNode struct {
+	Kind      string `json:"kind"`
+	Type      string `json:"type"`
+	Statement Stmt   `json:"statement"`
+	Body      []Stmt `json:"body"`
+	Scope     string `json:"scope,omitempty"`
+	AttachedComments
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+}
+
+type CaseClauseNode struct {
+	Kind        string       `json:"kind"`
+	Type        string       `json:"type"`
+	Expressions []Expr       `json:"expressions"`
+	Body        []Stmt       `json:"body"`
+	Scope       string       `json:"scope,omitempty"`
+	Implicit    *ImplicitVar `json:"implicit,omitempty"`
+	AttachedComments
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+}
+
+// The variable a type switch declares in one of its clauses.
+type ImplicitVar struct {
+	Name   string `json:"name"`
+	ID     string `json:"id"`
+	GoType GoType `json:"go-type"`
+}
+
+// A package-level variable initialization (see DumpInitializers).
+type InitializerNode struct {
+	Kind     string            `json:"kind"`
+	Type     string            `json:"type"`
+	Vars     []*IdentifierNode `json:"vars"`
+	Value    Expr              `json:"value"`
+	Position Position          `json:"position"`
+	End      Position          `json:"end"`
+}
+
+// Declarations.
+
+type FuncDeclNode struct {
+	Kind       string       `json:"kind"`
+	Type       string       `json:"type"`
+	Name       Name         `json:"name"`
+	Body       []Stmt       `json:"body"`
+	TypeParams []*FieldNode `json:"type-params"`
+	Params     []*FieldNode `json:"params"`
+	Variadic   *FieldNode   `json:"variadic"`
+	Results    []*FieldNode `json:"results"`
+	Comments   []string     `json:"comments"`
+	Scope      string       `json:"scope,omitempty"`
+	InstanceOf string       `json:"instance-of,omitempty"`
+	TypeArgs   []GoType     `json:"type-args,omitempty"`
+	AttachedComments
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+}
+
+type MethodDeclNode struct {
+	Kind       string       `json:"kind"`
+	Type       string       `json:"type"`
+	Receiver   *FieldNode   `json:"receiver"`
+	Name       Name         `json:"name"`
+	Body       []Stmt       `json:"body"`
+	Params     []*FieldNode `json:"params"`
+	Variadic   *FieldNode   `json:"variadic"`
+	Results    []*FieldNode `json:"results"`
+	Comments   []string     `json:"comments"`
+	Scope      string       `json:"scope,omitempty"`
+	InstanceOf string       `json:"instance-of,omitempty"`
+	TypeArgs   []GoType     `json:"type-args,omitempty"`
+	AttachedComments
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+}
+
+// An import, const, var or type declaration.
+type GenDeclNode struct {
+	Kind  string `json:"kind"`
+	Type  string `json:"type"`
+	Specs []Spec `json:"specs"`
+	AttachedComments
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+}
+
+// Import specs have no kind.
+type ImportSpecNode struct {
+	Type     string               `json:"type"`
+	Doc      []string             `json:"doc"`
+	Comments []string             `json:"comments"`
+	Name     Name                 `json:"name"`
+	Path     string               `json:"path"`
+	Implicit *ImplicitPackageName `json:"implicit,omitempty"`
+	AttachedComments
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+}
+
+// The package name an unnamed import declares.
+type ImplicitPackageName struct {
+	Name    string `json:"name"`
+	ID      string `json:"id"`
+	Package string `json:"package"`
+}
+
+// A const or var spec.
+type ValueSpecNode struct {
+	Kind         string   `json:"kind"`
+	Type         string   `json:"type"`
+	Names        []Name   `json:"names"`
+	DeclaredType Type     `json:"declared-type"`
+	Values       []Expr   `json:"values"`
+	Comments     []string `json:"comments"`
+	AttachedComments
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+}
+
+// A type-alias or type-definition spec.
+type TypeSpecNode struct {
+	Kind       string       `json:"kind"`
+	Type       string       `json:"type"`
+	Name       Name         `json:"name"`
+	TypeParams []*FieldNode `json:"type-params"`
+	Value      Type         `json:"value"`
+	Object     *TypeObject  `json:"object"`
+	Doc        []string     `json:"doc"`
+	Comments   []string     `json:"comments"`
+	Scope      string       `json:"scope,omitempty"`
+	InstanceOf string       `json:"instance-of,omitempty"`
+	TypeArgs   []GoType     `json:"type-args,omitempty"`
+	AttachedComments
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+}
+
+// The type name declared by a type spec.
+type TypeObject struct {
+	Name    string `json:"name"`
+	IsAlias bool   `json:"is-alias"`
+	Type    GoType `json:"type"`
+}
+
+// Files and packages. Diagnostics are only present in the output of
+// the recovery modes (and, for Load, of Monomorphize), and omitted here
+// when there are none.
+
+type FileNode struct {
+	Kind         string         `json:"kind"`
+	Path         string         `json:"path"`
+	PackageName  Name           `json:"package-name"`
+	Comments     []string       `json:"comments"`
+	AllComments  [][]string     `json:"all-comments"`
+	Declarations []Decl         `json:"declarations"`
+	Imports      []*GenDeclNode `json:"imports"`
+	Diagnostics  []*Diagnostic  `json:"diagnostics,omitempty"`
+	Scope        string         `json:"scope,omitempty"`
+	FileTable    FileTable      `json:"file-table,omitempty"`
+	AttachedComments
+	Position Position `json:"position"`
+	End      Position `json:"end"`
+}
+
+type PackageNode struct {
+	Name         string             `json:"name"`
+	Path         string             `json:"path"`
+	Imports      []string           `json:"imports"`
+	FilePaths    []string           `json:"file-paths"`
+	Files        []*FileNode        `json:"files"`
+	Initializers []*InitializerNode `json:"initializers"`
+	Scopes       []*ScopeNode       `json:"scopes,omitempty"`
+}
+
+// The result of Load.
+type LoadResult struct {
+	SchemaVersion int                   `json:"schema-version,omitempty"`
+	Name          string                `json:"name"`
+	Package       *PackageNode          `json:"package"`
+	Imports       []*PackageNode        `json:"imports"`
+	Types         map[string]*NamedType `json:"types"`
+	Diagnostics   []*Diagnostic         `json:"diagnostics,omitempty"`
+	Universe      *ScopeNode            `json:"universe,omitempty"`
+}
+
+// A lexical scope. The universe and package scopes have no Start or
+// End. Nodes that open a scope refer to it by ID in their Scope field.
+type ScopeNode struct {
+	Kind    string    `json:"kind"`
+	Type    string    `json:"type"`
+	ID      string    `json:"id"`
+	Parent  *string   `json:"parent"`
+	Start   *Position `json:"start"`
+	End     *Position `json:"end"`
+	Objects []string  `json:"objects"`
+}
+
+// go-types.
+
+type ArrayGoType struct {
+	Type string `json:"type"`
+	Elem GoType `json:"elem"`
+	Len  int64  `json:"len"`
+}
+
+type BasicGoType struct {
+	Type string `json:"type"`
+	Kind string `json:"kind"`
+}
+
+type ChanGoType struct {
+	Type      string `json:"type"`
+	Direction string `json:"direction"`
+	Elem      GoType `json:"elem"`
+}
+
+type InterfaceGoType struct {
+	Type      string         `json:"type"`
+	Methods   []GoTypeMember `json:"methods"`
+	Embeddeds []GoType       `json:"embeddeds"`
+}
+
+type MapGoType struct {
+	Type string `json:"type"`
+	Key  GoType `json:"key"`
+	Elem GoType `json:"elem"`
+}
+
+// A reference to an entry of the types table.
+type NamedGoType struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type PointerGoType struct {
+	Type string `json:"type"`
+	Elem GoType `json:"elem"`
+}
+
+type SignatureGoType struct {
+	Type           string           `json:"type"`
+	Params         *TupleGoType     `json:"params"`
+	Recv           *GoVar           `json:"recv"`
+	Results        *TupleGoType     `json:"results"`
+	Variadic       bool             `json:"variadic"`
+	TypeParams     []*TypeParamDecl `json:"type-params"`
+	RecvTypeParams []*TypeParamDecl `json:"recv-type-params"`
+}
+
+type SliceGoType struct {
+	Type string `json:"type"`
+	Elem GoType `json:"elem"`
+}
+
+type StructGoType struct {
+	Type   string         `json:"type"`
+	Fields []GoTypeMember `json:"fields"`
+}
+
+type TupleGoType struct {
+	Type   string         `json:"type"`
+	Fields []GoTypeMember `json:"fields"`
+}
+
+// A use of a type parameter.
+type TypeParamGoType struct {
+	Type  string `json:"type"`
+	Name  string `json:"name"`
+	Index int    `json:"index"`
+}
+
+type UnionGoType struct {
+	Type  string      `json:"type"`
+	Terms []UnionTerm `json:"terms"`
+}
+
+// A field of a struct or tuple, or a method of an interface.
+type GoTypeMember struct {
+	Name string `json:"name"`
+	Type GoType `json:"type"`
+}
+
+type GoVar struct {
+	Name string `json:"name"`
+}
+
+type UnionTerm struct {
+	Tilde bool   `json:"tilde"`
+	Type  GoType `json:"type"`
+}
+
+// The declaration of a type parameter.
+type TypeParamDecl struct {
+	Type       string `json:"type"`
+	Name       string `json:"name"`
+	Index      int    `json:"index"`
+	Constraint GoType `json:"constraint"`
+}
+
+// An entry of the types table.
+type NamedType struct {
+	Type       string           `json:"type"`
+	ID         string           `json:"id"`
+	Name       string           `json:"name"`
+	Package    *string          `json:"package"`
+	Exported   bool             `json:"exported"`
+	Underlying GoType           `json:"underlying"`
+	Methods    []NamedMethod    `json:"methods"`
+	TypeParams []*TypeParamDecl `json:"type-params"`
+	Origin     *string          `json:"origin"`
+	TypeArgs   []GoType         `json:"type-args"`
+}
+
+type NamedMethod struct {
+	Name            string           `json:"name"`
+	Exported        bool             `json:"exported"`
+	PointerReceiver bool             `json:"pointer-receiver"`
+	Type            *SignatureGoType `json:"type"`
+}
+
+// Unmarshaling.
+
+// The keys that tell nodes apart.
+type nodeHeader struct {
+	Kind string `json:"kind"`
+	Type string `json:"type"`
+}
+
+// Decode a node into the struct chosen by newNode for its kind and
+// type. Null decodes to nil.
+func decodeNode(data []byte, category string, newNode func(h nodeHeader) interface{}) (interface{}, error) {
+	if string(data) == "null" {
+		return nil, nil
+	}
+	var h nodeHeader
+	if err := json.Unmarshal(data, &h); err != nil {
+		return nil, err
+	}
+	n := newNode(h)
+	if n == nil {
+		reason := fmt.Sprintf("not %s: kind %q, type %q", category, h.Kind, h.Type)
+		return nil, &Error{Type: "unexpected_node", Reason: reason, Position: INVALID_POSITION}
+	}
+	if err := json.Unmarshal(data, n); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func encodeNode(n interface{}) ([]byte, error) {
+	if n == nil {
+		return []byte("null"), nil
+	}
+	return json.Marshal(n)
+}
+
+func newName(h nodeHeader) interface{} {
+	switch {
+	case h.Kind == "ident":
+		return &IdentNode{}
+	case h.Kind == "literal" && h.Type == "BOOL":
+		return &BoolLiteralNode{}
+	case h.Kind == "literal" && h.Type == "IOTA":
+		return &IotaNode{}
+	}
+	return nil
+}
+
+func newType(h nodeHeader) interface{} {
+	if h.Kind != "type" {
+		return nil
+	}
+	switch h.Type {
+	case "identifier":
+		return &TypeIdentifierNode{}
+	case "slice":
+		return &SliceTypeNode{}
+	case "array":
+		return &ArrayTypeNode{}
+	case "pointer":
+		return &PointerTypeNode{}
+	case "interface":
+		return &InterfaceTypeNode{}
+	case "map":
+		return &MapTypeNode{}
+	case "chan":
+		return &ChanTypeNode{}
+	case "struct":
+		return &StructTypeNode{}
+	case "function":
+		return &FuncTypeNode{}
+	case "instantiation":
+		return &TypeInstantiationNode{}
+	case "union":
+		return &UnionTypeNode{}
+	case "tilde":
+		return &TildeTypeNode{}
+	case "ellipsis":
+		return &EllipsisTypeNode{}
+	case "bad":
+		return &BadNode{}
+	}
+	return nil
+}
+
+func newExpr(h nodeHeader) interface{} {
+	switch h.Kind {
+	case "type":
+		return newType(h)
+	case "constant":
+		return &ConstantNode{}
+	case "literal":
+		switch h.Type {
+		case "BOOL":
+			return &BoolLiteralNode{}
+		case "INT", "FLOAT", "IMAG", "CHAR", "STRING":
+			return &BasicLiteralNode{}
+		case "function":
+			return &FuncLitNode{}
+		case "composite":
+			return &CompositeLitNode{}
+		}
+	case "expression":
+		switch h.Type {
+		case "identifier":
+			return &IdentifierNode{}
+		case "ellipsis":
+			return &EllipsisNode{}
+		case "binary":
+			return &BinaryNode{}
+		case "instantiation":
+			return &InstantiationNode{}
+		case "index":
+			return &IndexNode{}
+		case "star":
+			return &StarNode{}
+		case "new":
+			return &NewNode{}
+		case "make":
+			return &MakeNode{}
+		case "cast":
+			return &CastNode{}
+		case "call":
+			return &CallNode{}
+		case "paren":
+			return &ParenNode{}
+		case "selector":
+			return &SelectorNode{}
+		case "type-assert":
+			return &TypeAssertNode{}
+		case "unary":
+			return &UnaryNode{}
+		case "slice":
+			return &SliceNode{}
+		case "key-value":
+			return &KeyValueNode{}
+		case "bad":
+			return &BadNode{}
+		}
+	}
+	return nil
+}
+
+func newStmt(h nodeHeader) interface{} {
+	if h.Kind != "statement" {
+		return nil
+	}
+	switch h.Type {
+	case "return":
+		return &ReturnNode{}
+	case "assign", "define", "assign-operator":
+		return &AssignNode{}
+	case "empty":
+		return &EmptyNode{}
+	case "expression":
+		return &ExprStmtNode{}
+	case "labeled":
+		return &LabeledNode{}
+	case "break", "continue", "goto":
+		return &BranchNode{}
+	case "fallthrough":
+		return &FallthroughNode{}
+	case "range":
+		return &RangeNode{}
+	case "declaration":
+		return &DeclStmtNode{}
+	case "defer":
+		return &DeferNode{}
+	case "go":
+		return &GoStmtNode{}
+	case "if":
+		return &IfNode{}
+	case "block":
+		return &BlockNode{}
+	case "for":
+		return &ForNode{}
+	case "send":
+		return &SendNode{}
+	case "select":
+		return &SelectNode{}
+	case "crement":
+		return &CrementNode{}
+	case "switch":
+		return &SwitchNode{}
+	case "type-switch":
+		return &TypeSwitchNode{}
+	case "select-clause":
+		return &SelectClauseNode{}
+	case "case-clause":
+		return &CaseClauseNode{}
+	case "bad":
+		return &BadNode{}
+	}
+	return nil
+}
+
+func newDecl(h nodeHeader) interface{} {
+	if h.Kind != "decl" {
+		return nil
+	}
+	switch h.Type {
+	case "function":
+		return &FuncDeclNode{}
+	case "method":
+		return &MethodDeclNode{}
+	case "import", "const", "var", "type":
+		return &GenDeclNode{}
+	case "bad":
+		return &BadNode{}
+	}
+	return nil
+}
+
+func newSpec(h nodeHeader) interface{} {
+	switch {
+	case h.Kind == "" && h.Type == "import":
+		return &ImportSpecNode{}
+	case h.Kind == "spec" && (h.Type == "const" || h.Type == "var"):
+		return &ValueSpecNode{}
+	case h.Kind == "spec" && (h.Type == "type-alias" || h.Type == "type-definition"):
+		return &TypeSpecNode{}
+	}
+	return nil
+}
+
+func newGoType(h nodeHeader) interface{} {
+	switch h.Type {
+	case "Array":
+		return &ArrayGoType{}
+	case "Basic":
+		return &BasicGoType{}
+	case "Chan":
+		return &ChanGoType{}
+	case "Interface":
+		return &InterfaceGoType{}
+	case "Map":
+		return &MapGoType{}
+	case "Named":
+		return &NamedGoType{}
+	case "Pointer":
+		return &PointerGoType{}
+	case "Signature":
+		return &SignatureGoType{}
+	case "Slice":
+		return &SliceGoType{}
+	case "Struct":
+		return &StructGoType{}
+	case "Tuple":
+		return &TupleGoType{}
+	case "TypeParam":
+		return &TypeParamGoType{}
+	case "Union":
+		return &UnionGoType{}
+	}
+	return nil
+}
+
+func (w *Name) UnmarshalJSON(data []byte) error {
+	n, err := decodeNode(data, "a name", newName)
+	if n != nil {
+		w.Node = n.(NameNode)
+	}
+	return err
+}
+
+func (w *Expr) UnmarshalJSON(data []byte) error {
+	n, err := decodeNode(data, "an expression", newExpr)
+	if n != nil {
+		w.Node = n.(ExprNode)
+	}
+	return err
+}
+
+func (w *Type) UnmarshalJSON(data []byte) error {
+	n, err := decodeNode(data, "a type", newType)
+	if n != nil {
+		w.Node = n.(TypeNode)
+	}
+	return err
+}
+
+func (w *Stmt) UnmarshalJSON(data []byte) error {
+	n, err := decodeNode(data, "a statement", newStmt)
+	if n != nil {
+		w.Node = n.(StmtNode)
+	}
+	return err
+}
+
+func (w *Decl) UnmarshalJSON(data []byte) error {
+	n, err := decodeNode(data, "a declaration", newDecl)
+	if n != nil {
+		w.Node = n.(DeclNode)
+	}
+	return err
+}
+
+func (w *Spec) UnmarshalJSON(data []byte) error {
+	n, err := decodeNode(data, "a spec", newSpec)
+	if n != nil {
+		w.Node = n.(SpecNode)
+	}
+	return err
+}
+
+func (w *GoType) UnmarshalJSON(data []byte) error {
+	n, err := decodeNode(data, "a go-type", newGoType)
+	if n != nil {
+		w.Node = n.(GoTypeNode)
+	}
+	return err
+}
+
+func (w Name) MarshalJSON() ([]byte, error)   { return encodeNode(w.Node) }
+func (w Expr) MarshalJSON() ([]byte, error)   { return encodeNode(w.Node) }
+func (w Type) MarshalJSON() ([]byte, error)   { return encodeNode(w.Node) }
+func (w Stmt) MarshalJSON() ([]byte, error)   { return encodeNode(w.Node) }
+func (w Decl) MarshalJSON() ([]byte, error)   { return encodeNode(w.Node) }
+func (w Spec) MarshalJSON() ([]byte, error)   { return encodeNode(w.Node) }
+func (w GoType) MarshalJSON() ([]byte, error) { return encodeNode(w.Node) }
+
+// Constant values only have the fields of their type.
+
+type constantJSON struct {
+	Type        string         `json:"type"`
+	Value       *string        `json:"value,omitempty"`
+	Numerator   *ConstantValue `json:"numerator,omitempty"`
+	Denominator *ConstantValue `json:"denominator,omitempty"`
+	Real        *ConstantValue `json:"real,omitempty"`
+	Imag        *ConstantValue `json:"imag,omitempty"`
+}
+
+func (c *ConstantValue) UnmarshalJSON(data []byte) error {
+	var j constantJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*c = ConstantValue{Type: j.Type, Numerator: j.Numerator, Denominator: j.Denominator, Real: j.Real, Imag: j.Imag}
+	if j.Value != nil {
+		c.Value = *j.Value
+	}
+	return nil
+}
+
+func (c ConstantValue) MarshalJSON() ([]byte, error) {
+	j := constantJSON{Type: c.Type}
+	switch c.Type {
+	case "FLOAT":
+		j.Numerator, j.Denominator = c.Numerator, c.Denominator
+	case "COMPLEX":
+		j.Real, j.Imag = c.Real, c.Imag
+	default:
+		j.Value = &c.Value
+	}
+	return json.Marshal(j)
+}
+
+func (*IdentNode) nameNode()       {}
+func (*BoolLiteralNode) nameNode() {}
+func (*IotaNode) nameNode()        {}
+
+func (*BoolLiteralNode) exprNode()       {}
+func (*BasicLiteralNode) exprNode()      {}
+func (*FuncLitNode) exprNode()           {}
+func (*CompositeLitNode) exprNode()      {}
+func (*ConstantNode) exprNode()          {}
+func (*IdentifierNode) exprNode()        {}
+func (*EllipsisNode) exprNode()          {}
+func (*BinaryNode) exprNode()            {}
+func (*InstantiationNode) exprNode()     {}
+func (*IndexNode) exprNode()             {}
+func (*StarNode) exprNode()              {}
+func (*NewNode) exprNode()               {}
+func (*MakeNode) exprNode()              {}
+func (*CastNode) exprNode()              {}
+func (*CallNode) exprNode()              {}
+func (*ParenNode) exprNode()             {}
+func (*SelectorNode) exprNode()          {}
+func (*TypeAssertNode) exprNode()        {}
+func (*UnaryNode) exprNode()             {}
+func (*SliceNode) exprNode()             {}
+func (*KeyValueNode) exprNode()          {}
+func (*BadNode) exprNode()               {}
+func (*TypeIdentifierNode) exprNode()    {}
+func (*SliceTypeNode) exprNode()         {}
+func (*ArrayTypeNode) exprNode()         {}
+func (*PointerTypeNode) exprNode()       {}
+func (*InterfaceTypeNode) exprNode()     {}
+func (*MapTypeNode) exprNode()           {}
+func (*ChanTypeNode) exprNode()          {}
+func (*StructTypeNode) exprNode()        {}
+func (*FuncTypeNode) exprNode()          {}
+func (*TypeInstantiationNode) exprNode() {}
+func (*UnionTypeNode) exprNode()         {}
+func (*TildeTypeNode) exprNode()         {}
+func (*EllipsisTypeNode) exprNode()      {}
+
+func (*BadNode) typeNode()               {}
+func (*TypeIdentifierNode) typeNode()    {}
+func (*SliceTypeNode) typeNode()         {}
+func (*ArrayTypeNode) typeNode()         {}
+func (*PointerTypeNode) typeNode()       {}
+func (*InterfaceTypeNode) typeNode()     {}
+func (*MapTypeNode) typeNode()           {}
+func (*ChanTypeNode) typeNode()          {}
+func (*StructTypeNode) typeNode()        {}
+func (*FuncTypeNode) typeNode()          {}
+func (*TypeInstantiationNode) typeNode() {}
+func (*UnionTypeNode) typeNode()         {}
+func (*TildeTypeNode) typeNode()         {}
+func (*EllipsisTypeNode) typeNode()      {}
+
+func (*ReturnNode) stmtNode()       {}
+func (*AssignNode) stmtNode()       {}
+func (*EmptyNode) stmtNode()        {}
+func (*ExprStmtNode) stmtNode()     {}
+func (*LabeledNode) stmtNode()      {}
+func (*BranchNode) stmtNode()       {}
+func (*FallthroughNode) stmtNode()  {}
+func (*RangeNode) stmtNode()        {}
+func (*DeclStmtNode) stmtNode()     {}
+func (*DeferNode) stmtNode()        {}
+func (*GoStmtNode) stmtNode()       {}
+func (*IfNode) stmtNode()           {}
+func (*BlockNode) stmtNode()        {}
+func (*ForNode) stmtNode()          {}
+func (*SendNode) stmtNode()         {}
+func (*SelectNode) stmtNode()       {}
+func (*CrementNode) stmtNode()      {}
+func (*SwitchNode) stmtNode()       {}
+func (*TypeSwitchNode) stmtNode()   {}
+func (*SelectClauseNode) stmtNode() {}
+func (*CaseClauseNode) stmtNode()   {}
+func (*BadNode) stmtNode()          {}
+
+func (*FuncDeclNode) declNode()   {}
+func (*MethodDeclNode) declNode() {}
+func (*GenDeclNode) declNode()    {}
+func (*BadNode) declNode()        {}
+
+func (*ImportSpecNode) specNode() {}
+func (*ValueSpecNode) specNode()  {}
+func (*TypeSpecNode) specNode()   {}
+
+func (*ArrayGoType) goTypeNode()     {}
+func (*BasicGoType) goTypeNode()     {}
+func (*ChanGoType) goTypeNode()      {}
+func (*InterfaceGoType) goTypeNode() {}
+func (*MapGoType) goTypeNode()       {}
+func (*NamedGoType) goTypeNode()     {}
+func (*PointerGoType) goTypeNode()   {}
+func (*SignatureGoType) goTypeNode() {}
+func (*SliceGoType) goTypeNode()     {}
+func (*StructGoType) goTypeNode()    {}
+func (*TupleGoType) goTypeNode()     {}
+func (*TypeParamGoType) goTypeNode() {}
+func (*UnionGoType) goTypeNode()     {}
@@ -0,0 +1,136 @@
+package goblin
+
+import (
+	"go/ast"
+	"go/token"
+	"sort"
+	"strings"
+)
+
+// With Dumper.CompactPositions, positions are dumped as packed
+// integers instead of objects: the token.Pos of the position, which is
+// the base of its file plus its offset in it (0 for an unknown
+// position). Each file dump then has a "file-table" with the name,
+// base, size and line table (the offset at which each line starts) of
+// its file, and of the files of the specialized copies made from it
+// with Config.Monomorphize, which is enough to recover the filename,
+// line, offset and column of any position in it. Unlike full
+// positions, packed ones aren't adjusted by //line directives.
+
+func (d *Dumper) dumpPos(p token.Pos) interface{} {
+	if d.CompactPositions {
+		return float64(p)
+	}
+	return DumpPosition(d.Fset.Position(p))
+}
+
+// The file table of f, if positions are packed.
+func (d *Dumper) withFileTable(res map[string]interface{}, f *ast.File) map[string]interface{} {
+	if d.CompactPositions {
+		res["file-table"] = d.dumpFileTable(f)
+	}
+	return res
+}
+
+func (d *Dumper) dumpFileTable(f *ast.File) []map[string]interface{} {
+	file := d.Fset.File(f.FileStart)
+	if file == nil {
+		file = d.Fset.File(f.Pos())
+	}
+	if file == nil {
+		return []map[string]interface{}{}
+	}
+	files := []*token.File{file}
+	if d.specializations != nil {
+		d.Fset.Iterate(func(g *token.File) bool {
+			if strings.HasPrefix(g.Name(), file.Name()+"#") {
+				files = append(files, g)
+			}
+			return true
+		})
+	}
+
+	table := make([]map[string]interface{}, len(files))
+	for i, g := range files {
+		lines := make([]float64, g.LineCount())
+		for j, offset := range g.Lines() {
+			lines[j] = float64(offset)
+		}
+		table[i] = map[string]interface{}{
+			"name":  g.Name(),
+			"base":  float64(g.Base()),
+			"size":  float64(g.Size()),
+			"lines": lines,
+		}
+	}
+	return table
+}
+
+// The position a packed position stands for, given the file table it
+// is in (or an invalid one if it isn't in any of its files).
+func (t FileTable) Unpack(p int) Position {
+	for _, f := range t {
+		if p < f.Base || p > f.Base+f.Size {
+			continue
+		}
+		offset := p - f.Base
+		line := sort.Search(len(f.Lines), func(i int) bool { return f.Lines[i] > offset })
+		column := offset + 1
+		if line > 0 {
+			column -= f.Lines[line-1]
+		}
+		return Position{Filename: f.Name, Line: line, Offset: offset, Column: column}
+	}
+	return Position{}
+}
+
+// The file table of a dumped file, as a FileTable.
+func fileTableOf(node map[string]interface{}) FileTable {
+	var table FileTable
+	for _, e := range asList(node["file-table"]) {
+		e := asNode(e)
+		f := &FileTableEntry{
+			Name: asString(e["name"]),
+			Base: int(asNumber(e["base"])),
+			Size: int(asNumber(e["size"])),
+		}
+		for _, l := range asList(e["lines"]) {
+			f.Lines = append(f.Lines, int(asNumber(l)))
+		}
+		table = append(table, f)
+	}
+	return table
+}
+
+// A copy of a dumped tree with the packed positions in it turned back
+// into full ones, using table.
+func unpackPositions(v interface{}, table FileTable) interface{} {
+	switch n := v.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(n))
+		for k, c := range n {
+			if isPositionKey(k) {
+				if _, ok := c.(map[string]interface{}); !ok && c != nil {
+					p := table.Unpack(int(asNumber(c)))
+					res[k] = DumpPosition(token.Position{Filename: p.Filename, Offset: p.Offset, Line: p.Line, Column: p.Column})
+					continue
+				}
+			}
+			res[k] = unpackPositions(c, table)
+		}
+		return res
+	case []interface{}, []map[string]interface{}:
+		list := asList(n)
+		res := make([]interface{}, len(list))
+		for i, c := range list {
+			res[i] = unpackPositions(c, table)
+		}
+		return res
+	}
+	return v
+}
+
+// The keys the positions of nodes are dumped under.
+func isPositionKey(k string) bool {
+	return k == "position" || k == "end" || k == "from" || k == "to"
+}
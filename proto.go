@@ -0,0 +1,365 @@
+package goblin
+
+import (
+	_ "embed"
+	"encoding/binary"
+	"fmt"
+	"go/token"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Dumps can also be written as Protocol Buffers, following
+// schema/goblin.proto. That file is maintained by hand and has to be
+// kept in step with schema/goblin.schema.json: it has a message for
+// each definition and a field for each of its properties (which the
+// tests check), and is the authority on field numbers. The encoder
+// reads it to find them, and uses the schema's constants (the kind and
+// type of each node) to tell which message of a oneof a node is.
+
+//go:embed schema/goblin.proto
+var protoSource []byte
+
+// The Protocol Buffers definition of goblin's output, for the Proto
+// format.
+func ProtoSchema() []byte {
+	return append([]byte(nil), protoSource...)
+}
+
+type protoMessage struct {
+	name   string
+	fields []*protoField          // in the order they are declared
+	byKey  map[string]*protoField // by JSON key
+	consts map[string]interface{} // the kind and type of a node
+	oneof  bool                   // fields are the alternatives of a union
+	list   bool                   // wraps a list, such as CommentGroup
+	bare   *protoField            // holds a value that isn't an object (a packed Position)
+}
+
+type protoField struct {
+	key      string // the JSON key (or definition, for alternatives)
+	number   int
+	typ      string // a scalar type, or the name of msg
+	msg      *protoMessage
+	repeated bool
+	optional bool
+	isMap    bool
+	element  bool // an element of a repeated field, so zero is written
+}
+
+var (
+	protoOnce     sync.Once
+	protoMessages map[string]*protoMessage
+)
+
+var (
+	protoMessageLine = regexp.MustCompile(`^message (\w+) \{`)
+	protoFieldLine   = regexp.MustCompile(`^\s+(repeated |optional )?(map<string, (\w+)>|\w+) (\w+) = (\d+)(?: \[json_name = "([^"]*)"\])?;`)
+)
+
+// Definitions in the schema are named like "expression-identifier",
+// and messages like "ExpressionIdentifier".
+func protoName(definition string) string {
+	parts := strings.Split(definition, "-")
+	for i, p := range parts {
+		parts[i] = strings.ToUpper(p[:1]) + p[1:]
+	}
+	return strings.Join(parts, "")
+}
+
+func loadProto() map[string]*protoMessage {
+	protoOnce.Do(func() {
+		definitions := map[string]map[string]interface{}{}
+		for name, def := range loadSchema()["definitions"].(map[string]interface{}) {
+			definitions[protoName(name)] = def.(map[string]interface{})
+		}
+
+		messages := map[string]*protoMessage{}
+		bare := map[*protoMessage]string{}
+		var m *protoMessage
+		for _, line := range strings.Split(string(protoSource), "\n") {
+			if match := protoMessageLine.FindStringSubmatch(line); match != nil {
+				m = &protoMessage{name: match[1], byKey: map[string]*protoField{}, consts: map[string]interface{}{}}
+				messages[m.name] = m
+				def := definitions[m.name]
+				if def == nil {
+					panic("goblin: no schema definition for message " + m.name)
+				}
+				m.list = def["type"] == "array"
+				props, _ := def["properties"].(map[string]interface{})
+				for k, p := range props {
+					if c, ok := p.(map[string]interface{})["const"]; ok {
+						m.consts[k] = c
+					}
+				}
+				// A titled alternative of a definition (a
+				// packed position) is the field of that name.
+				alts, _ := def["anyOf"].([]interface{})
+				for _, alt := range alts {
+					if title, ok := alt.(map[string]interface{})["title"].(string); ok {
+						bare[m] = title
+					}
+				}
+			} else if strings.HasPrefix(strings.TrimSpace(line), "oneof ") {
+				m.oneof = true
+			} else if match := protoFieldLine.FindStringSubmatch(line); match != nil {
+				f := &protoField{key: match[4], typ: match[2]}
+				f.number, _ = strconv.Atoi(match[5])
+				f.repeated = match[1] == "repeated "
+				f.optional = match[1] == "optional "
+				if match[3] != "" {
+					f.isMap = true
+					f.typ = match[3]
+				}
+				if match[6] != "" {
+					f.key = match[6]
+				}
+				m.fields = append(m.fields, f)
+				m.byKey[f.key] = f
+			}
+		}
+
+		for m, key := range bare {
+			m.bare = m.byKey[key]
+		}
+		for _, m := range messages {
+			for _, f := range m.fields {
+				switch f.typ {
+				case "string", "bool", "int64", "sint64":
+				default:
+					f.msg = messages[f.typ]
+					if f.msg == nil {
+						panic("goblin: no message " + f.typ + " in goblin.proto")
+					}
+				}
+			}
+		}
+		protoMessages = messages
+	})
+	return protoMessages
+}
+
+// The message for a schema definition.
+func protoMessageFor(definition string) *protoMessage {
+	m := loadProto()[protoName(definition)]
+	if m == nil {
+		panic("goblin: no message for " + definition + " in goblin.proto")
+	}
+	return m
+}
+
+// The message for a value of any of the kinds of output the schema
+// allows at its top.
+func protoMessageOf(v interface{}) *protoMessage {
+	obj := protoObject(v)
+	for _, alt := range loadSchema()["anyOf"].([]interface{}) {
+		ref := alt.(map[string]interface{})["$ref"].(string)
+		m := protoMessageFor(strings.TrimPrefix(ref, "#/definitions/"))
+		if m.matches(obj) {
+			return m
+		}
+	}
+	fail(protoPosition(obj), "encode_error", "no message in goblin.proto for "+describeNode(obj))
+	return nil
+}
+
+// Reports whether obj is an instance of m (or, for a union, of one of
+// its alternatives): it has m's kind and type, and no keys m lacks.
+func (m *protoMessage) matches(obj map[string]interface{}) bool {
+	if m.oneof {
+		return m.choose(obj) != nil
+	}
+	for k, c := range m.consts {
+		if obj[k] != c {
+			return false
+		}
+	}
+	for k := range obj {
+		if m.byKey[k] == nil && m.consts[k] == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *protoMessage) choose(obj map[string]interface{}) *protoField {
+	for _, f := range m.fields {
+		if f.msg.matches(obj) {
+			return f
+		}
+	}
+	return nil
+}
+
+// Append the encoding of v as an m, without a tag or length.
+func (m *protoMessage) appendBody(b []byte, v interface{}) []byte {
+	if m.list {
+		return m.fields[0].append(b, v)
+	}
+	if m.bare != nil {
+		x := v
+		if k := reflect.ValueOf(v).Kind(); k == reflect.Struct || k == reflect.Ptr {
+			x = jsonValue(v)
+		}
+		if _, ok := x.(map[string]interface{}); !ok {
+			return m.bare.append(b, x)
+		}
+	}
+	obj := protoObject(v)
+	if m.oneof {
+		alt := m.choose(obj)
+		if alt == nil {
+			fail(protoPosition(obj), "encode_error", "no message in "+m.name+" for "+describeNode(obj))
+		}
+		return alt.appendOne(b, obj)
+	}
+	for _, k := range sortedKeys(obj) {
+		if m.byKey[k] == nil && m.consts[k] == nil {
+			fail(protoPosition(obj), "encode_error", fmt.Sprintf("no field in %s for key %q", m.name, k))
+		}
+	}
+	for _, f := range m.fields {
+		b = f.append(b, obj[f.key])
+	}
+	return b
+}
+
+// Append the value of a field, with its tag. Nulls, and zero values of
+// singular fields, are left out.
+func (f *protoField) append(b []byte, v interface{}) []byte {
+	if isNull(v) {
+		return b
+	}
+	rv := reflect.ValueOf(v)
+	switch {
+	case f.isMap:
+		if rv.Kind() != reflect.Map {
+			fail(INVALID_POSITION, "encode_error", fmt.Sprintf("expected an object for %q, got %T", f.key, v))
+		}
+		keys := make([]string, 0, rv.Len())
+		for _, k := range rv.MapKeys() {
+			keys = append(keys, k.String())
+		}
+		sort.Strings(keys)
+		key := protoField{number: 1, typ: "string", element: true}
+		value := protoField{number: 2, typ: f.typ, msg: f.msg, element: true}
+		for _, k := range keys {
+			entry := key.appendOne(nil, k)
+			entry = value.appendOne(entry, rv.MapIndex(reflect.ValueOf(k).Convert(rv.Type().Key())).Interface())
+			b = appendProtoBytes(b, f.number, entry)
+		}
+		return b
+	case f.repeated:
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			fail(INVALID_POSITION, "encode_error", fmt.Sprintf("expected a list for %q, got %T", f.key, v))
+		}
+		e := *f
+		e.repeated, e.element = false, true
+		for i := 0; i < rv.Len(); i++ {
+			if item := rv.Index(i).Interface(); !isNull(item) {
+				b = e.appendOne(b, item)
+			}
+		}
+		return b
+	case f.msg == nil && !f.optional && !f.element && rv.IsZero():
+		return b
+	}
+	return f.appendOne(b, v)
+}
+
+func (f *protoField) appendOne(b []byte, v interface{}) []byte {
+	switch f.typ {
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			fail(INVALID_POSITION, "encode_error", fmt.Sprintf("expected a string for %q, got %T", f.key, v))
+		}
+		return appendProtoBytes(b, f.number, []byte(toValidUTF8(s)))
+	case "bool":
+		x, ok := v.(bool)
+		if !ok {
+			fail(INVALID_POSITION, "encode_error", fmt.Sprintf("expected a boolean for %q, got %T", f.key, v))
+		}
+		n := uint64(0)
+		if x {
+			n = 1
+		}
+		return binary.AppendUvarint(appendProtoTag(b, f.number, 0), n)
+	case "int64":
+		return binary.AppendUvarint(appendProtoTag(b, f.number, 0), uint64(protoInt(f, v)))
+	case "sint64":
+		return binary.AppendVarint(appendProtoTag(b, f.number, 0), protoInt(f, v))
+	}
+	return appendProtoBytes(b, f.number, f.msg.appendBody(nil, v))
+}
+
+func protoInt(f *protoField, v interface{}) int64 {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		if n := rv.Float(); n == float64(int64(n)) {
+			return int64(n)
+		}
+	case reflect.String:
+		// A json.Number.
+		if n, err := strconv.ParseInt(rv.String(), 10, 64); err == nil {
+			return n
+		}
+	}
+	fail(INVALID_POSITION, "encode_error", fmt.Sprintf("expected an integer for %q, got %v", f.key, v))
+	return 0
+}
+
+func appendProtoTag(b []byte, number int, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(number)<<3|uint64(wireType))
+}
+
+func appendProtoBytes(b []byte, number int, data []byte) []byte {
+	b = appendProtoTag(b, number, 2)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// Like encoding/json, take nil maps, slices and pointers for null.
+func isNull(v interface{}) bool {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Invalid:
+		return true
+	case reflect.Map, reflect.Slice, reflect.Ptr, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
+
+// Dumps are maps, but anything else that marshals to a JSON object
+// will do.
+func protoObject(v interface{}) map[string]interface{} {
+	if obj, ok := v.(map[string]interface{}); ok {
+		return obj
+	}
+	if obj, ok := jsonValue(v).(map[string]interface{}); ok {
+		return obj
+	}
+	fail(INVALID_POSITION, "encode_error", fmt.Sprintf("expected an object, got %T", v))
+	return nil
+}
+
+func protoPosition(obj map[string]interface{}) token.Position {
+	if _, ok := obj["position"].(map[string]interface{}); ok {
+		return position(obj)
+	}
+	return INVALID_POSITION
+}
+
+func describeNode(obj map[string]interface{}) string {
+	return fmt.Sprintf("node (kind %s, type %s)", encode(obj["kind"]), encode(obj["type"]))
+}
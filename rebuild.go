@@ -0,0 +1,1207 @@
+package goblin
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Rebuilding goes the other way: it turns the objects produced by
+// DumpFile and DumpExpr (or their JSON encoding) back into a go/ast
+// tree, which can then be printed with go/printer or go/format.
+//
+// Positions are optional. When they are present, they are used to
+// reconstruct the line structure of the original source (so printing
+// keeps blank lines, multi-line composites and doc comments in
+// place); when they are missing, e.g. in generated or hand-edited
+// JSON, the printer picks the layout (and may not put comments back
+// where they belong). Type information (go-type, ident-kind, the type
+// table) is ignored. Comments attached to a node (leading-comments and
+// trailing-comments) go back where they were if they have positions;
+// otherwise only doc and line comments are kept, and all-comments is
+// ignored, since there's no telling where they went.
+
+type rebuilder struct {
+	file *token.File
+
+	// The offsets of the line starts seen in positions (by line
+	// number), and the doc and line comments to place once the line
+	// table is known.
+	lines    map[int]int
+	comments []*ast.CommentGroup
+	trailing []trailingComment
+
+	// The comment groups attached to nodes, at their own positions,
+	// and those of them that doc and line comments turned out to be.
+	attached []*ast.CommentGroup
+	claimed  map[*ast.CommentGroup]bool
+
+	// Closing braces and parens aren't dumped either, but we can
+	// tell which line they're on from the positions before them.
+	closers []closer
+	last    int
+}
+
+// A line comment for the line containing offset, to be stored in
+// field once placed.
+type trailingComment struct {
+	group  *ast.CommentGroup
+	offset int
+	field  **ast.CommentGroup
+}
+
+// A closing token whose opening token is at open, and whose contents
+// end at offset last.
+type closer struct {
+	open  token.Pos
+	close *token.Pos
+	last  int
+}
+
+// Rebuild a file dumped by DumpFile. The file (and the positions of
+// its nodes) is added to fset. Packed positions are unpacked with the
+// file's table.
+func RebuildFile(node map[string]interface{}, fset *token.FileSet) (f *ast.File, err error) {
+	defer catch(&err)
+	if table := fileTableOf(node); table != nil {
+		node = unpackPositions(node, table).(map[string]interface{})
+	}
+	r := newRebuilder(node, fset)
+	f = r.rebuildFile(node)
+	r.finish(f)
+	return f, nil
+}
+
+// Rebuild an expression dumped by DumpExpr. Type nodes are rebuilt as
+// the corresponding type expressions.
+func RebuildExpr(node map[string]interface{}, fset *token.FileSet) (e ast.Expr, err error) {
+	defer catch(&err)
+	r := newRebuilder(node, fset)
+	e = r.rebuildExpr(node)
+	r.finish(nil)
+	return e, nil
+}
+
+func newRebuilder(node map[string]interface{}, fset *token.FileSet) *rebuilder {
+	size := 0
+	walkPositions(node, func(p map[string]interface{}) {
+		if offset := int(asNumber(p["offset"])); offset >= size {
+			size = offset + 1
+		}
+	})
+	name, _ := node["path"].(string)
+	return &rebuilder{
+		// A few extra bytes give positions to tokens we have to
+		// synthesize, after the last position.
+		file:    fset.AddFile(name, -1, size+3),
+		lines:   map[int]int{1: 0},
+		claimed: map[*ast.CommentGroup]bool{},
+	}
+}
+
+// Call fn on every valid position in a dumped tree.
+func walkPositions(v interface{}, fn func(map[string]interface{})) {
+	switch n := v.(type) {
+	case map[string]interface{}:
+		for k, c := range n {
+			if p, ok := c.(map[string]interface{}); ok && isPositionKey(k) {
+				if asNumber(p["line"]) > 0 {
+					fn(p)
+				}
+				continue
+			}
+			walkPositions(c, fn)
+		}
+	case []interface{}:
+		for _, c := range n {
+			walkPositions(c, fn)
+		}
+	case []map[string]interface{}:
+		for _, c := range n {
+			walkPositions(c, fn)
+		}
+	}
+}
+
+// Numbers are float64 after a trip through encoding/json, but may be
+// any number type in a tree that was built in memory.
+func asNumber(v interface{}) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	}
+	return 0
+}
+
+func (r *rebuilder) fail(node map[string]interface{}, reason string) {
+	pos := position(node)
+	if !pos.IsValid() {
+		pos = INVALID_POSITION
+	}
+	fail(pos, "rebuild_error", reason)
+}
+
+// The position of a node as dumped, which may be invalid.
+func position(node map[string]interface{}) token.Position {
+	p := asNode(node["position"])
+	return token.Position{
+		Filename: asString(p["filename"]),
+		Offset:   int(asNumber(p["offset"])),
+		Line:     int(asNumber(p["line"])),
+		Column:   int(asNumber(p["column"])),
+	}
+}
+
+// The position stored under key, or NoPos if there is none. Every
+// valid position also tells us where a line starts.
+func (r *rebuilder) posOf(node map[string]interface{}, key string) token.Pos {
+	p, ok := node[key].(map[string]interface{})
+	if !ok || asNumber(p["line"]) <= 0 {
+		return token.NoPos
+	}
+	offset := int(asNumber(p["offset"]))
+	if offset < 0 || offset >= r.file.Size() {
+		return token.NoPos
+	}
+	if start := offset - int(asNumber(p["column"])) + 1; start >= 0 {
+		r.lines[int(asNumber(p["line"]))] = start
+	}
+	if offset > r.last {
+		r.last = offset
+	}
+	return r.file.Pos(offset)
+}
+
+func (r *rebuilder) pos(node map[string]interface{}) token.Pos {
+	return r.posOf(node, "position")
+}
+
+// Some tokens are only printed if they have a valid position (e.g. the
+// "..." of a variadic call), even though the dump only records that
+// they are there. The position we make up comes after offset 0, where
+// the printer puts comments without a position.
+func (r *rebuilder) somePos(near token.Pos) token.Pos {
+	if near.IsValid() {
+		return near
+	}
+	return r.file.Pos(1)
+}
+
+// Set up the line table and place the comments. Comments are only
+// printed if they are in f.Comments at a valid position, so doc
+// comments are placed on the lines just before the node they document,
+// and line comments at the end of the line of their node.
+func (r *rebuilder) finish(f *ast.File) {
+	numbers := make([]int, 0, len(r.lines))
+	for l := range r.lines {
+		numbers = append(numbers, l)
+	}
+	sort.Ints(numbers)
+
+	// Lines without positions on them (blank lines, say) get the
+	// last offsets before the next line we know of, so that the
+	// printer sees the same line numbers as in the original.
+	starts := []int{}
+	for i, l := range numbers {
+		start := r.lines[l]
+		if i > 0 {
+			prev := numbers[i-1]
+			for k := prev + 1; k < l; k++ {
+				if gap := start - (l - k); gap > starts[len(starts)-1] {
+					starts = append(starts, gap)
+				}
+			}
+		}
+		if len(starts) == 0 || start > starts[len(starts)-1] {
+			starts = append(starts, start)
+		}
+	}
+	// There's one more line after the last position, for the closing
+	// tokens and line comments at the end.
+	if end := r.last + 2; end > starts[len(starts)-1] && end < r.file.Size() {
+		starts = append(starts, end)
+	}
+
+	// The line starting after offset, if there is one.
+	nextLine := func(offset int) (int, bool) {
+		i := sort.SearchInts(starts, offset+1)
+		if i == len(starts) {
+			return 0, false
+		}
+		return starts[i], true
+	}
+
+	for _, t := range r.trailing {
+		next, ok := nextLine(t.offset)
+		if !ok {
+			continue
+		}
+		for _, c := range t.group.List {
+			c.Slash = r.file.Pos(next - 1)
+		}
+		*t.field = t.group
+		r.comments = append(r.comments, t.group)
+	}
+
+	// A closing token goes on the line after its contents, unless
+	// they're all on the line of the opening token. Closers are
+	// recorded innermost first, and nested ones that end at the same
+	// place get a line each.
+	used := map[int]int{}
+	for _, c := range r.closers {
+		if !c.open.IsValid() {
+			continue
+		}
+		open := r.file.Offset(c.open)
+		if openLine, ok := nextLine(open); !ok || c.last < openLine {
+			*c.close = c.open
+			continue
+		}
+		i := sort.SearchInts(starts, c.last+1)
+		if prev, ok := used[c.last]; ok && i <= prev {
+			i = prev + 1
+		}
+		if i < len(starts) {
+			*c.close = r.file.Pos(starts[i])
+			used[c.last] = i
+		}
+	}
+
+	r.file.SetLines(starts)
+	if f == nil {
+		return
+	}
+	for _, g := range append(r.attached, r.comments...) {
+		if g.Pos().IsValid() {
+			f.Comments = append(f.Comments, g)
+		}
+	}
+	sort.Slice(f.Comments, func(i, j int) bool {
+		return f.Comments[i].Pos() < f.Comments[j].Pos()
+	})
+}
+
+// Rebuild the doc comment of a node that starts at p. Doc comments
+// end on the line before their node, at the same indentation, which
+// tells us where they were.
+func (r *rebuilder) docComment(v interface{}, p token.Position) *ast.CommentGroup {
+	if g := r.claim(v); g != nil {
+		return g
+	}
+	g := r.commentGroup(v)
+	if g == nil || !p.IsValid() || p.Offset >= r.file.Size() {
+		return g
+	}
+	line, indent := p.Line, p.Column-1
+	start := p.Offset - indent
+	for _, c := range g.List {
+		line -= 1 + strings.Count(c.Text, "\n")
+		start -= indent + len(c.Text) + 1
+	}
+	if start < 0 || line < 1 {
+		return g
+	}
+	for _, c := range g.List {
+		r.lines[line] = start
+		c.Slash = r.file.Pos(start + indent)
+		line += 1 + strings.Count(c.Text, "\n")
+		start += indent + len(c.Text) + 1
+	}
+	r.comments = append(r.comments, g)
+	return g
+}
+
+// Rebuild the line comment of a node that starts at pos, which goes at
+// the end of the node's last line. Line comments can only be printed
+// in the right place if we know where that is, so they're dropped
+// otherwise.
+func (r *rebuilder) lineComment(v interface{}, pos token.Pos, field **ast.CommentGroup) {
+	if g := r.claim(v); g != nil {
+		*field = g
+		return
+	}
+	g := r.commentGroup(v)
+	if g != nil && pos.IsValid() {
+		r.trailing = append(r.trailing, trailingComment{g, r.last, field})
+	}
+}
+
+// Rebuild the comment groups attached to a node, which are placed
+// where their positions say. Groups with a comment that has no
+// position are dropped, leaving doc and line comments to docComment
+// and lineComment.
+//
+// Comments inside the node push its closing tokens past them, but
+// those after it (on the line it ends, say) must only push the closing
+// tokens around it, so they're accounted for by the function returned,
+// to be called once the node is rebuilt.
+func (r *rebuilder) attachComments(n map[string]interface{}) func() {
+	end := int(asNumber(asNode(n["end"])["offset"]))
+	after := 0
+	for _, key := range [...]string{"leading-comments", "trailing-comments"} {
+		for _, v := range asList(n[key]) {
+			last := r.last
+			g := &ast.CommentGroup{}
+			for _, c := range asList(v) {
+				c := asNode(c)
+				g.List = append(g.List, &ast.Comment{Slash: r.pos(c), Text: asString(c["text"])})
+			}
+			if r.last >= end {
+				if r.last > after {
+					after = r.last
+				}
+				r.last = last
+			}
+			if placed(g) {
+				r.attached = append(r.attached, g)
+			}
+		}
+	}
+	return func() {
+		if after > r.last {
+			r.last = after
+		}
+	}
+}
+
+func placed(g *ast.CommentGroup) bool {
+	for _, c := range g.List {
+		if !c.Slash.IsValid() {
+			return false
+		}
+	}
+	return len(g.List) > 0
+}
+
+// The attached comment group a doc or line comment (as a list of
+// texts) is, if any. Doc and line comments are also attached to their
+// node or the one around it, so the latest unclaimed group with the
+// same texts is the one.
+func (r *rebuilder) claim(v interface{}) *ast.CommentGroup {
+	texts := asList(v)
+	if len(texts) == 0 {
+		return nil
+	}
+search:
+	for i := len(r.attached) - 1; i >= 0; i-- {
+		g := r.attached[i]
+		if r.claimed[g] || len(g.List) != len(texts) {
+			continue
+		}
+		for j, c := range g.List {
+			if c.Text != asString(texts[j]) {
+				continue search
+			}
+		}
+		r.claimed[g] = true
+		return g
+	}
+	return nil
+}
+
+// Find the position of a closing token once the line table is known.
+func (r *rebuilder) closeAfter(open token.Pos, close *token.Pos) {
+	r.closers = append(r.closers, closer{open, close, r.last})
+}
+
+func (r *rebuilder) commentGroup(v interface{}) *ast.CommentGroup {
+	texts := asList(v)
+	if len(texts) == 0 {
+		return nil
+	}
+	g := &ast.CommentGroup{}
+	for _, t := range texts {
+		text, _ := t.(string)
+		g.List = append(g.List, &ast.Comment{Text: text})
+	}
+	return g
+}
+
+// Lists are []interface{} after a trip through encoding/json, but may
+// be more specific in a tree that was built in memory.
+func asList(v interface{}) []interface{} {
+	switch l := v.(type) {
+	case []interface{}:
+		return l
+	case []map[string]interface{}:
+		res := make([]interface{}, len(l))
+		for i, n := range l {
+			res[i] = n
+		}
+		return res
+	case [][]map[string]interface{}:
+		res := make([]interface{}, len(l))
+		for i, n := range l {
+			res[i] = n
+		}
+		return res
+	case []string:
+		res := make([]interface{}, len(l))
+		for i, n := range l {
+			res[i] = n
+		}
+		return res
+	}
+	return nil
+}
+
+func asNode(v interface{}) map[string]interface{} {
+	n, _ := v.(map[string]interface{})
+	return n
+}
+
+func asString(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+// Operators are dumped as their token strings.
+var operators = map[string]token.Token{}
+
+func init() {
+	for t := token.ADD; t <= token.TILDE; t++ {
+		operators[t.String()] = t
+	}
+}
+
+func (r *rebuilder) operator(n map[string]interface{}, s string) token.Token {
+	t, ok := operators[s]
+	if !ok {
+		r.fail(n, "unknown operator "+strconv.Quote(s))
+	}
+	return t
+}
+
+func (r *rebuilder) rebuildFile(n map[string]interface{}) *ast.File {
+	if n["kind"] != "file" {
+		r.fail(n, fmt.Sprintf("expected a file, got %v", n["kind"]))
+	}
+	defer r.attachComments(n)()
+	f := &ast.File{Name: r.rebuildIdent(asNode(n["package-name"]))}
+	// A file's position is its package clause. Older dumps don't
+	// have one, but gofmt'd code has it just before the package name.
+	p := position(n)
+	if !p.IsValid() {
+		p = position(asNode(n["package-name"]))
+		p.Offset -= len("package ")
+		p.Column -= len("package ")
+		if p.Column < 1 {
+			p = token.Position{}
+		}
+	}
+	f.Package = r.somePos(token.NoPos)
+	if p.IsValid() {
+		f.Package = r.file.Pos(p.Offset)
+	}
+	f.Doc = r.docComment(n["comments"], p)
+	for _, d := range asList(n["declarations"]) {
+		decl := r.rebuildDecl(asNode(d))
+		f.Decls = append(f.Decls, decl)
+		if g, ok := decl.(*ast.GenDecl); ok && g.Tok == token.IMPORT {
+			for _, s := range g.Specs {
+				f.Imports = append(f.Imports, s.(*ast.ImportSpec))
+			}
+		}
+	}
+	return f
+}
+
+func (r *rebuilder) rebuildIdent(n map[string]interface{}) *ast.Ident {
+	if n == nil {
+		return nil
+	}
+	ident := &ast.Ident{NamePos: r.pos(n)}
+	switch {
+	case n["kind"] == "ident":
+		ident.Name = asString(n["value"])
+	case n["kind"] == "literal" && n["type"] == "BOOL":
+		ident.Name = asString(n["value"])
+	case n["kind"] == "literal" && n["type"] == "IOTA":
+		ident.Name = "iota"
+	default:
+		r.fail(n, fmt.Sprintf("expected an identifier, got %v %v", n["kind"], n["type"]))
+	}
+	return ident
+}
+
+// An identifier with an optional package qualifier.
+func (r *rebuilder) rebuildQualified(n map[string]interface{}) ast.Expr {
+	ident := r.rebuildIdent(asNode(n["value"]))
+	if q := asNode(n["qualifier"]); q != nil {
+		return &ast.SelectorExpr{X: r.rebuildIdent(q), Sel: ident}
+	}
+	return ident
+}
+
+func (r *rebuilder) rebuildExprs(v interface{}) []ast.Expr {
+	var exprs []ast.Expr
+	for _, e := range asList(v) {
+		exprs = append(exprs, r.rebuildExpr(asNode(e)))
+	}
+	return exprs
+}
+
+func (r *rebuilder) rebuildExpr(n map[string]interface{}) ast.Expr {
+	if n == nil {
+		return nil
+	}
+
+	switch n["kind"] {
+	case "type":
+		return r.rebuildType(n)
+	case "constant":
+		return r.rebuildConstant(n, asNode(n["value"]))
+	case "literal":
+		return r.rebuildLiteral(n)
+	case "expression":
+	default:
+		r.fail(n, fmt.Sprintf("expected an expression, got %v", n["kind"]))
+	}
+
+	pos := r.pos(n)
+	switch n["type"] {
+	case "identifier":
+		return r.rebuildQualified(n)
+
+	case "ellipsis":
+		return &ast.Ellipsis{Ellipsis: pos, Elt: r.rebuildExpr(asNode(n["value"]))}
+
+	case "binary":
+		return &ast.BinaryExpr{
+			X:  r.rebuildExpr(asNode(n["left"])),
+			Op: r.operator(n, asString(n["operator"])),
+			Y:  r.rebuildExpr(asNode(n["right"])),
+		}
+
+	case "instantiation":
+		return r.rebuildInstantiation(n)
+
+	case "index":
+		return &ast.IndexExpr{
+			X:     r.rebuildExpr(asNode(n["target"])),
+			Index: r.rebuildExpr(asNode(n["index"])),
+		}
+
+	case "star":
+		return &ast.StarExpr{Star: pos, X: r.rebuildExpr(asNode(n["target"]))}
+
+	case "call":
+		c := &ast.CallExpr{
+			Fun:  r.rebuildExpr(asNode(n["function"])),
+			Args: r.rebuildExprs(n["arguments"]),
+		}
+		if n["ellipsis"] == true {
+			c.Ellipsis = r.somePos(pos)
+		}
+		return c
+
+	case "new", "make":
+		fun := asString(n["type"])
+		args := []ast.Expr{r.rebuildType(asNode(n["argument"]))}
+		args = append(args, r.rebuildExprs(n["rest"])...)
+		return &ast.CallExpr{Fun: &ast.Ident{NamePos: pos, Name: fun}, Args: args}
+
+	case "cast":
+		return &ast.CallExpr{
+			Fun:  r.rebuildCastType(asNode(n["coerced-to"])),
+			Args: []ast.Expr{r.rebuildExpr(asNode(n["target"]))},
+		}
+
+	case "paren":
+		return &ast.ParenExpr{Lparen: pos, X: r.rebuildExpr(asNode(n["target"]))}
+
+	case "selector":
+		return &ast.SelectorExpr{
+			X:   r.rebuildExpr(asNode(n["target"])),
+			Sel: r.rebuildIdent(asNode(n["field"])),
+		}
+
+	case "type-assert":
+		var asserted ast.Expr
+		if a := asNode(n["asserted"]); a != nil {
+			asserted = r.rebuildType(a)
+		}
+		return &ast.TypeAssertExpr{X: r.rebuildExpr(asNode(n["target"])), Type: asserted}
+
+	case "unary":
+		return &ast.UnaryExpr{
+			OpPos: pos,
+			Op:    r.operator(n, asString(n["operator"])),
+			X:     r.rebuildExpr(asNode(n["target"])),
+		}
+
+	case "slice":
+		return &ast.SliceExpr{
+			X:      r.rebuildExpr(asNode(n["target"])),
+			Low:    r.rebuildExpr(asNode(n["low"])),
+			High:   r.rebuildExpr(asNode(n["high"])),
+			Max:    r.rebuildExpr(asNode(n["max"])),
+			Slice3: n["three"] == true,
+		}
+
+	case "key-value":
+		return &ast.KeyValueExpr{
+			Key:   r.rebuildExpr(asNode(n["key"])),
+			Value: r.rebuildExpr(asNode(n["value"])),
+		}
+
+	case "bad":
+		return &ast.BadExpr{From: pos, To: r.posOf(n, "to")}
+	}
+
+	r.fail(n, fmt.Sprintf("unknown expression type %v", n["type"]))
+	panic("unreachable")
+}
+
+// Casts to types that don't start with an identifier or a bracket have
+// to be parenthesized, e.g. (*T)(x) or (<-chan int)(x).
+func (r *rebuilder) rebuildCastType(n map[string]interface{}) ast.Expr {
+	t := r.rebuildType(n)
+	switch t.(type) {
+	case *ast.StarExpr, *ast.ChanType, *ast.FuncType:
+		return &ast.ParenExpr{X: t}
+	}
+	return t
+}
+
+func (r *rebuilder) rebuildInstantiation(n map[string]interface{}) ast.Expr {
+	var target ast.Expr
+	if n["kind"] == "type" {
+		target = r.rebuildType(asNode(n["target"]))
+	} else {
+		target = r.rebuildExpr(asNode(n["target"]))
+	}
+
+	var args []ast.Expr
+	for _, a := range asList(n["arguments"]) {
+		args = append(args, r.rebuildType(asNode(a)))
+	}
+	if len(args) == 1 {
+		return &ast.IndexExpr{X: target, Index: args[0]}
+	}
+	return &ast.IndexListExpr{X: target, Indices: args}
+}
+
+func (r *rebuilder) rebuildLiteral(n map[string]interface{}) ast.Expr {
+	pos := r.pos(n)
+	switch n["type"] {
+	case "BOOL":
+		return &ast.Ident{NamePos: pos, Name: asString(n["value"])}
+
+	case "IOTA":
+		return &ast.Ident{NamePos: pos, Name: "iota"}
+
+	case "INT", "FLOAT", "IMAG", "CHAR", "STRING":
+		var kind token.Token
+		for t := token.INT; t <= token.STRING; t++ {
+			if t.String() == n["type"] {
+				kind = t
+			}
+		}
+		return &ast.BasicLit{ValuePos: pos, Kind: kind, Value: asString(n["value"])}
+
+	case "function":
+		return &ast.FuncLit{
+			Type: r.rebuildFuncType(n, pos),
+			Body: r.rebuildBody(n["body"], pos),
+		}
+
+	case "composite":
+		c := &ast.CompositeLit{Lbrace: pos}
+		if t := asNode(n["declared"]); t != nil {
+			c.Type = r.rebuildType(t)
+		}
+		c.Elts = r.rebuildExprs(n["values"])
+		r.closeAfter(pos, &c.Rbrace)
+		return c
+	}
+
+	r.fail(n, fmt.Sprintf("unknown literal type %v", n["type"]))
+	panic("unreachable")
+}
+
+// Constants are what's left of constant expressions in a typed dump.
+func (r *rebuilder) rebuildConstant(n map[string]interface{}, c map[string]interface{}) ast.Expr {
+	pos := r.pos(n)
+	switch c["type"] {
+	case "BOOL":
+		return &ast.Ident{NamePos: pos, Name: asString(c["value"])}
+
+	case "STRING":
+		return &ast.BasicLit{ValuePos: pos, Kind: token.STRING, Value: strconv.Quote(asString(c["value"]))}
+
+	case "INT":
+		return &ast.BasicLit{ValuePos: pos, Kind: token.INT, Value: asString(c["value"])}
+
+	case "FLOAT":
+		return &ast.BasicLit{ValuePos: pos, Kind: token.FLOAT, Value: r.floatValue(n, c)}
+
+	case "COMPLEX":
+		imag := r.floatValue(n, asNode(c["imag"])) + "i"
+		return &ast.ParenExpr{Lparen: pos, X: &ast.BinaryExpr{
+			X:  &ast.BasicLit{Kind: token.FLOAT, Value: r.floatValue(n, asNode(c["real"]))},
+			Op: token.ADD,
+			Y:  &ast.BasicLit{Kind: token.IMAG, Value: imag},
+		}}
+	}
+
+	r.fail(n, fmt.Sprintf("unknown constant type %v", c["type"]))
+	panic("unreachable")
+}
+
+// Float constants are dumped as fractions.
+func (r *rebuilder) floatValue(n map[string]interface{}, c map[string]interface{}) string {
+	num := constant.MakeFromLiteral(asString(asNode(c["numerator"])["value"]), token.INT, 0)
+	denom := constant.MakeFromLiteral(asString(asNode(c["denominator"])["value"]), token.INT, 0)
+	if num.Kind() != constant.Int || denom.Kind() != constant.Int {
+		r.fail(n, "malformed float constant")
+	}
+	f, _ := constant.Float64Val(constant.BinaryOp(num, token.QUO, denom))
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eEn") {
+		s += ".0"
+	}
+	return s
+}
+
+func (r *rebuilder) rebuildType(n map[string]interface{}) ast.Expr {
+	if n == nil {
+		return nil
+	}
+	if n["kind"] != "type" {
+		// e.g. the ellipsis in [...]T, or an expression in a
+		// place where the dumper expected a type.
+		return r.rebuildExpr(n)
+	}
+
+	pos := r.pos(n)
+	switch n["type"] {
+	case "identifier":
+		return r.rebuildQualified(n)
+
+	case "slice":
+		return &ast.ArrayType{Lbrack: pos, Elt: r.rebuildType(asNode(n["element"]))}
+
+	case "array":
+		return &ast.ArrayType{
+			Lbrack: pos,
+			Len:    r.rebuildExpr(asNode(n["length"])),
+			Elt:    r.rebuildType(asNode(n["element"])),
+		}
+
+	case "pointer":
+		return &ast.StarExpr{Star: pos, X: r.rebuildType(asNode(n["contained"]))}
+
+	case "interface":
+		return &ast.InterfaceType{
+			Interface:  pos,
+			Methods:    r.rebuildBraced(n["methods"], pos),
+			Incomplete: n["incomplete"] == true,
+		}
+
+	case "map":
+		return &ast.MapType{
+			Map:   pos,
+			Key:   r.rebuildType(asNode(n["key"])),
+			Value: r.rebuildType(asNode(n["value"])),
+		}
+
+	case "chan":
+		dir := ast.SEND | ast.RECV
+		switch n["direction"] {
+		case "send":
+			dir = ast.SEND
+		case "recv":
+			dir = ast.RECV
+		}
+		return &ast.ChanType{Begin: pos, Dir: dir, Value: r.rebuildType(asNode(n["value"]))}
+
+	case "struct":
+		return &ast.StructType{Struct: pos, Fields: r.rebuildBraced(n["fields"], pos)}
+
+	case "function":
+		return r.rebuildFuncType(n, pos)
+
+	case "ellipsis":
+		return &ast.Ellipsis{Ellipsis: pos, Elt: r.rebuildType(asNode(n["value"]))}
+
+	case "instantiation":
+		return r.rebuildInstantiation(n)
+
+	case "union":
+		terms := asList(n["terms"])
+		if len(terms) == 0 {
+			r.fail(n, "empty union")
+		}
+		union := r.rebuildType(asNode(terms[0]))
+		for _, t := range terms[1:] {
+			union = &ast.BinaryExpr{X: union, Op: token.OR, Y: r.rebuildType(asNode(t))}
+		}
+		return union
+
+	case "tilde":
+		return &ast.UnaryExpr{OpPos: pos, Op: token.TILDE, X: r.rebuildType(asNode(n["term"]))}
+
+	case "bad":
+		return &ast.BadExpr{From: pos, To: r.posOf(n, "to")}
+	}
+
+	r.fail(n, fmt.Sprintf("unknown type %v", n["type"]))
+	panic("unreachable")
+}
+
+func (r *rebuilder) rebuildField(n map[string]interface{}) *ast.Field {
+	defer r.attachComments(n)()
+	f := &ast.Field{Type: r.rebuildType(asNode(n["declared-type"]))}
+	for _, name := range asList(n["names"]) {
+		f.Names = append(f.Names, r.rebuildIdent(asNode(name)))
+	}
+	if tag := asNode(n["tag"]); tag != nil {
+		f.Tag = r.rebuildLiteral(tag).(*ast.BasicLit)
+	}
+	return f
+}
+
+func (r *rebuilder) rebuildFields(v interface{}) *ast.FieldList {
+	if v == nil {
+		return nil
+	}
+	fs := &ast.FieldList{List: []*ast.Field{}}
+	for _, f := range asList(v) {
+		fs.List = append(fs.List, r.rebuildField(asNode(f)))
+	}
+	return fs
+}
+
+// The fields of a struct or interface type at pos, whose closing brace
+// is placed like a block's (so that comments after the last field stay
+// inside it).
+func (r *rebuilder) rebuildBraced(v interface{}, pos token.Pos) *ast.FieldList {
+	fs := r.rebuildFields(v)
+	if fs == nil {
+		fs = &ast.FieldList{}
+	}
+	fs.Opening = pos
+	r.closeAfter(pos, &fs.Closing)
+	return fs
+}
+
+// Functions, function literals and function types all share the
+// params/variadic/results layout.
+func (r *rebuilder) rebuildFuncType(n map[string]interface{}, pos token.Pos) *ast.FuncType {
+	params := r.rebuildFields(n["params"])
+	if params == nil {
+		params = &ast.FieldList{}
+	}
+	if v := asNode(n["variadic"]); v != nil {
+		params.List = append(params.List, r.rebuildField(v))
+	}
+	return &ast.FuncType{
+		Func:       pos,
+		TypeParams: r.rebuildFields(n["type-params"]),
+		Params:     params,
+		Results:    r.rebuildFields(n["results"]),
+	}
+}
+
+func (r *rebuilder) rebuildStmts(v interface{}) []ast.Stmt {
+	var stmts []ast.Stmt
+	for _, s := range asList(v) {
+		stmts = append(stmts, r.rebuildStmt(asNode(s)))
+	}
+	return stmts
+}
+
+// A missing body (as opposed to an empty one) is dumped as null. The
+// opening brace of a body is usually on the line of its statement, at
+// open.
+func (r *rebuilder) rebuildBody(v interface{}, open token.Pos) *ast.BlockStmt {
+	if v == nil {
+		return nil
+	}
+	b := &ast.BlockStmt{Lbrace: open, List: r.rebuildStmts(v)}
+	r.closeAfter(open, &b.Rbrace)
+	return b
+}
+
+func (r *rebuilder) rebuildBlock(n map[string]interface{}) *ast.BlockStmt {
+	return r.rebuildBody(n["body"], r.pos(n))
+}
+
+func (r *rebuilder) rebuildStmt(n map[string]interface{}) ast.Stmt {
+	if n == nil {
+		return nil
+	}
+	if n["kind"] != "statement" {
+		r.fail(n, fmt.Sprintf("expected a statement, got %v", n["kind"]))
+	}
+
+	pos := r.pos(n)
+	defer r.attachComments(n)()
+	switch n["type"] {
+	case "return":
+		return &ast.ReturnStmt{Return: pos, Results: r.rebuildExprs(n["values"])}
+
+	case "assign", "define", "assign-operator":
+		tok := token.ASSIGN
+		if n["type"] == "define" {
+			tok = token.DEFINE
+		} else if n["type"] == "assign-operator" {
+			tok = r.operator(n, asString(n["operator"])+"=")
+		}
+		return &ast.AssignStmt{
+			Lhs: r.rebuildExprs(n["left"]),
+			Tok: tok,
+			Rhs: r.rebuildExprs(n["right"]),
+		}
+
+	case "empty":
+		return &ast.EmptyStmt{Semicolon: pos}
+
+	case "expression":
+		return &ast.ExprStmt{X: r.rebuildExpr(asNode(n["value"]))}
+
+	case "labeled":
+		return &ast.LabeledStmt{
+			Label: r.rebuildIdent(asNode(n["label"])),
+			Stmt:  r.rebuildStmt(asNode(n["statement"])),
+		}
+
+	case "break", "continue", "goto", "fallthrough":
+		return &ast.BranchStmt{
+			TokPos: pos,
+			Tok:    token.Lookup(asString(n["type"])),
+			Label:  r.rebuildIdent(asNode(n["label"])),
+		}
+
+	case "range":
+		s := &ast.RangeStmt{
+			For:  pos,
+			Key:  r.rebuildExpr(asNode(n["key"])),
+			X:    r.rebuildExpr(asNode(n["target"])),
+			Body: r.rebuildBody(n["body"], pos),
+		}
+		s.Value = r.rebuildExpr(asNode(n["value"]))
+		if s.Key != nil {
+			s.Tok = token.DEFINE
+			if n["is-assign"] == true {
+				s.Tok = token.ASSIGN
+			}
+		}
+		return s
+
+	case "declaration":
+		return &ast.DeclStmt{Decl: r.rebuildDecl(asNode(n["target"]))}
+
+	case "defer":
+		return &ast.DeferStmt{Defer: pos, Call: r.rebuildCall(asNode(n["target"]))}
+
+	case "go":
+		return &ast.GoStmt{Go: pos, Call: r.rebuildCall(asNode(n["target"]))}
+
+	case "if":
+		return &ast.IfStmt{
+			If:   pos,
+			Init: r.rebuildStmt(asNode(n["init"])),
+			Cond: r.rebuildExpr(asNode(n["condition"])),
+			Body: r.rebuildBody(n["body"], pos),
+			Else: r.rebuildStmt(asNode(n["else"])),
+		}
+
+	case "block":
+		return r.rebuildBlock(n)
+
+	case "for":
+		return &ast.ForStmt{
+			For:  pos,
+			Init: r.rebuildStmt(asNode(n["init"])),
+			Cond: r.rebuildExpr(asNode(n["condition"])),
+			Post: r.rebuildStmt(asNode(n["post"])),
+			Body: r.rebuildBody(n["body"], pos),
+		}
+
+	case "send":
+		return &ast.SendStmt{
+			Chan:  r.rebuildExpr(asNode(n["channel"])),
+			Value: r.rebuildExpr(asNode(n["value"])),
+		}
+
+	case "select":
+		return &ast.SelectStmt{Select: pos, Body: r.rebuildBody(n["body"], pos)}
+
+	case "crement":
+		return &ast.IncDecStmt{
+			X:   r.rebuildExpr(asNode(n["target"])),
+			Tok: r.operator(n, asString(n["operation"])),
+		}
+
+	case "switch":
+		return &ast.SwitchStmt{
+			Switch: pos,
+			Init:   r.rebuildStmt(asNode(n["init"])),
+			Tag:    r.rebuildExpr(asNode(n["condition"])),
+			Body:   r.rebuildBody(n["body"], pos),
+		}
+
+	case "type-switch":
+		return &ast.TypeSwitchStmt{
+			Switch: pos,
+			Init:   r.rebuildStmt(asNode(n["init"])),
+			Assign: r.rebuildStmt(asNode(n["assign"])),
+			Body:   r.rebuildBody(n["body"], pos),
+		}
+
+	case "select-clause":
+		return &ast.CommClause{
+			Case: pos,
+			Comm: r.rebuildStmt(asNode(n["statement"])),
+			Body: r.rebuildStmts(n["body"]),
+		}
+
+	case "case-clause":
+		// The default clause is the one without expressions.
+		return &ast.CaseClause{
+			Case: pos,
+			List: r.rebuildExprs(n["expressions"]),
+			Body: r.rebuildStmts(n["body"]),
+		}
+
+	case "bad":
+		return &ast.BadStmt{From: pos, To: r.posOf(n, "to")}
+	}
+
+	r.fail(n, fmt.Sprintf("unknown statement type %v", n["type"]))
+	panic("unreachable")
+}
+
+// The calls of go and defer statements.
+func (r *rebuilder) rebuildCall(n map[string]interface{}) *ast.CallExpr {
+	e := r.rebuildExpr(n)
+	for {
+		p, ok := e.(*ast.ParenExpr)
+		if !ok {
+			break
+		}
+		e = p.X
+	}
+	c, ok := e.(*ast.CallExpr)
+	if !ok {
+		r.fail(n, "expected a call")
+	}
+	return c
+}
+
+func (r *rebuilder) rebuildDecl(n map[string]interface{}) ast.Decl {
+	if n["kind"] != "decl" {
+		r.fail(n, fmt.Sprintf("expected a declaration, got %v", n["kind"]))
+	}
+
+	pos := r.pos(n)
+	defer r.attachComments(n)()
+	switch n["type"] {
+	case "function", "method":
+		f := &ast.FuncDecl{
+			Doc:  r.docComment(n["comments"], position(n)),
+			Name: r.rebuildIdent(asNode(n["name"])),
+			Type: r.rebuildFuncType(n, pos),
+			Body: r.rebuildBody(n["body"], pos),
+		}
+		if recv := asNode(n["receiver"]); recv != nil {
+			f.Recv = &ast.FieldList{List: []*ast.Field{r.rebuildField(recv)}}
+		}
+		return f
+
+	case "import", "const", "var", "type":
+		d := &ast.GenDecl{TokPos: pos, Tok: token.Lookup(asString(n["type"]))}
+		specs := asList(n["specs"])
+		// A group of one spec ends after the spec does, at its
+		// closing paren.
+		grouped := len(specs) > 1
+		if len(specs) == 1 {
+			end := asNumber(asNode(n["end"])["offset"])
+			grouped = end > asNumber(asNode(asNode(specs[0])["end"])["offset"])
+		}
+		for _, s := range specs {
+			// The doc comment of an ungrouped type declaration
+			// is dumped with its spec, but precedes the decl.
+			docPos := position(asNode(s))
+			if !grouped {
+				docPos = position(n)
+			}
+			d.Specs = append(d.Specs, r.rebuildSpec(asNode(s), docPos))
+		}
+		if len(d.Specs) == 1 && !grouped {
+			if t, ok := d.Specs[0].(*ast.TypeSpec); ok {
+				d.Doc, t.Doc = t.Doc, nil
+			}
+		}
+		if grouped {
+			d.Lparen = r.somePos(pos)
+			r.closeAfter(pos, &d.Rparen)
+		}
+		return d
+
+	case "bad":
+		return &ast.BadDecl{From: pos, To: r.posOf(n, "to")}
+	}
+
+	r.fail(n, fmt.Sprintf("unknown declaration type %v", n["type"]))
+	panic("unreachable")
+}
+
+func (r *rebuilder) rebuildSpec(n map[string]interface{}, docPos token.Position) ast.Spec {
+	pos := r.pos(n)
+	defer r.attachComments(n)()
+	switch n["type"] {
+	case "import":
+		// Import specs have no kind.
+		s := &ast.ImportSpec{
+			Doc:  r.docComment(n["doc"], docPos),
+			Name: r.rebuildIdent(asNode(n["name"])),
+		}
+		s.Path = &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(asString(n["path"]))}
+		if s.Name == nil {
+			s.Path.ValuePos = pos
+		}
+		r.lineComment(n["comments"], pos, &s.Comment)
+		return s
+
+	case "const", "var":
+		s := &ast.ValueSpec{Values: r.rebuildExprs(n["values"])}
+		for _, name := range asList(n["names"]) {
+			s.Names = append(s.Names, r.rebuildIdent(asNode(name)))
+		}
+		if t := asNode(n["declared-type"]); t != nil {
+			s.Type = r.rebuildType(t)
+		}
+		r.lineComment(n["comments"], pos, &s.Comment)
+		return s
+
+	case "type-alias", "type-definition":
+		s := &ast.TypeSpec{
+			Doc:        r.docComment(n["doc"], docPos),
+			Name:       r.rebuildIdent(asNode(n["name"])),
+			TypeParams: r.rebuildFields(n["type-params"]),
+			Type:       r.rebuildType(asNode(n["value"])),
+		}
+		if n["type"] == "type-alias" {
+			s.Assign = r.somePos(token.NoPos)
+			if s.Name.Pos().IsValid() {
+				s.Assign = s.Name.End()
+			}
+		}
+		r.lineComment(n["comments"], pos, &s.Comment)
+		return s
+	}
+
+	r.fail(n, fmt.Sprintf("unknown spec type %v", n["type"]))
+	panic("unreachable")
+}
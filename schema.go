@@ -0,0 +1,358 @@
+package goblin
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The output format is described by a JSON Schema (draft-07), which
+// lists every node goblin emits along with its fields. Validate checks
+// a dump against it. Only the parts of JSON Schema that the schema
+// itself uses are implemented: $ref, type, const, enum, minimum,
+// properties, required, additionalProperties, items and anyOf. Any
+// other keyword in the schema is an error, so that one can't be added
+// without the validator checking it.
+
+//go:embed schema/goblin.schema.json
+var schemaSource []byte
+
+// The version of the output format described by the schema. It is
+// bumped whenever a node changes in a way that could break existing
+// consumers (but not for new optional fields).
+const SchemaVersion = 3
+
+// The JSON Schema for goblin's output.
+func Schema() []byte {
+	return append([]byte(nil), schemaSource...)
+}
+
+var (
+	schemaOnce sync.Once
+	schemaRoot map[string]interface{}
+)
+
+func loadSchema() map[string]interface{} {
+	schemaOnce.Do(func() {
+		if err := json.Unmarshal(schemaSource, &schemaRoot); err != nil {
+			panic("goblin: malformed schema: " + err.Error())
+		}
+		for name, def := range schemaRoot["definitions"].(map[string]interface{}) {
+			checkKeywords(def, "#/definitions/"+name)
+		}
+		checkKeywords(schemaRoot, "#")
+	})
+	return schemaRoot
+}
+
+// Check a dump against the schema. It may be any goblin output (a
+// Load result, package, file, declaration, statement, expression or
+// go-type), either as returned by the library or decoded from JSON.
+// Every violation is reported as a "schema_error" whose reason starts
+// with the JSON pointer of the offending value and whose position is
+// that of the closest enclosing node; the result is empty if v is
+// valid.
+func Validate(v interface{}) []*Error {
+	return ValidateAs(v, "")
+}
+
+// Like Validate, but check v against a single definition of the
+// schema, such as "file" or "expression". The empty string stands for
+// the whole schema.
+func ValidateAs(v interface{}, definition string) []*Error {
+	root := loadSchema()
+	var schema interface{} = root
+	if definition != "" {
+		def, ok := root["definitions"].(map[string]interface{})[definition]
+		if !ok {
+			return []*Error{{Type: "schema_error", Reason: "no definition named " + definition, Position: INVALID_POSITION}}
+		}
+		schema = def
+	}
+
+	// Dumps are made of maps, typed slices and Go numbers, so bring
+	// them into the shape of decoded JSON first.
+	data, err := json.Marshal(v)
+	if err != nil {
+		return []*Error{{Type: "json_error", Reason: err.Error(), Position: INVALID_POSITION}}
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return []*Error{{Type: "json_error", Reason: err.Error(), Position: INVALID_POSITION}}
+	}
+
+	c := &checker{root: root}
+	return c.check(schema, decoded, "#", TOPLEVEL_POSITION)
+}
+
+// The keywords check understands, and the annotations it can ignore.
+var schemaKeywords = map[string]bool{
+	"$ref": true, "type": true, "const": true, "enum": true, "minimum": true,
+	"properties": true, "required": true, "additionalProperties": true,
+	"items": true, "anyOf": true,
+
+	"$schema": true, "$id": true, "title": true, "description": true,
+	"version": true, "definitions": true,
+}
+
+// Panic if a (sub)schema uses a keyword the validator doesn't
+// implement, which it would otherwise silently ignore.
+func checkKeywords(schema interface{}, path string) {
+	s, ok := schema.(map[string]interface{})
+	if !ok {
+		return
+	}
+	for k := range s {
+		if !schemaKeywords[k] {
+			panic("goblin: unsupported schema keyword " + k + " at " + path)
+		}
+	}
+	if props, ok := s["properties"].(map[string]interface{}); ok {
+		for k, p := range props {
+			checkKeywords(p, path+"/properties/"+k)
+		}
+	}
+	checkKeywords(s["items"], path+"/items")
+	checkKeywords(s["additionalProperties"], path+"/additionalProperties")
+	if branches, ok := s["anyOf"].([]interface{}); ok {
+		for i, b := range branches {
+			checkKeywords(b, fmt.Sprintf("%s/anyOf/%d", path, i))
+		}
+	}
+}
+
+type checker struct {
+	root map[string]interface{}
+}
+
+func (c *checker) errorf(path string, pos token.Position, format string, args ...interface{}) []*Error {
+	reason := path + ": " + fmt.Sprintf(format, args...)
+	return []*Error{{Type: "schema_error", Reason: reason, Position: pos}}
+}
+
+// Follow a $ref, which must point into the schema's definitions.
+func (c *checker) resolve(schema interface{}) map[string]interface{} {
+	s, _ := schema.(map[string]interface{})
+	for s != nil {
+		ref, ok := s["$ref"].(string)
+		if !ok {
+			break
+		}
+		name := strings.TrimPrefix(ref, "#/definitions/")
+		s, _ = c.root["definitions"].(map[string]interface{})[name].(map[string]interface{})
+		if s == nil {
+			panic("goblin: dangling schema reference " + ref)
+		}
+	}
+	return s
+}
+
+func (c *checker) check(schema interface{}, v interface{}, path string, pos token.Position) []*Error {
+	s := c.resolve(schema)
+	if s == nil {
+		return nil
+	}
+
+	// Errors inside a node are reported at its position.
+	if obj, ok := v.(map[string]interface{}); ok {
+		if _, ok := obj["position"].(map[string]interface{}); ok {
+			pos = position(obj)
+		}
+	}
+
+	if branches, ok := s["anyOf"].([]interface{}); ok {
+		return c.anyOf(branches, v, path, pos)
+	}
+
+	if t, ok := s["type"]; ok && !hasType(t, v) {
+		return c.errorf(path, pos, "expected %s, got %s", describeType(t), jsonType(v))
+	}
+	if want, ok := s["const"]; ok && !reflect.DeepEqual(want, v) {
+		return c.errorf(path, pos, "expected %s, got %s", encode(want), encode(v))
+	}
+	if enum, ok := s["enum"].([]interface{}); ok && !contains(enum, v) {
+		return c.errorf(path, pos, "expected one of %s, got %s", encode(enum), encode(v))
+	}
+	if min, ok := s["minimum"].(float64); ok {
+		if n, ok := v.(float64); ok && n < min {
+			return c.errorf(path, pos, "expected at least %s, got %s", encode(min), encode(n))
+		}
+	}
+
+	errs := []*Error{}
+	switch val := v.(type) {
+	case map[string]interface{}:
+		props, _ := s["properties"].(map[string]interface{})
+		required, _ := s["required"].([]interface{})
+		for _, k := range required {
+			if _, ok := val[k.(string)]; !ok {
+				errs = append(errs, c.errorf(path, pos, "missing key %q", k)...)
+			}
+		}
+		for _, k := range sortedKeys(val) {
+			sub := path + "/" + pointerEscaper.Replace(k)
+			if p, ok := props[k]; ok {
+				errs = append(errs, c.check(p, val[k], sub, pos)...)
+				continue
+			}
+			switch extra := s["additionalProperties"].(type) {
+			case bool:
+				if !extra {
+					errs = append(errs, c.errorf(path, pos, "unexpected key %q", k)...)
+				}
+			case map[string]interface{}:
+				errs = append(errs, c.check(extra, val[k], sub, pos)...)
+			}
+		}
+	case []interface{}:
+		if items, ok := s["items"]; ok {
+			for i, item := range val {
+				errs = append(errs, c.check(items, item, fmt.Sprintf("%s/%d", path, i), pos)...)
+			}
+		}
+	}
+	return errs
+}
+
+// A value matching none of the alternatives is reported against the
+// alternative it comes closest to: of those whose constants (the kind
+// and type of a node) agree with it, the one with the fewest errors.
+func (c *checker) anyOf(branches []interface{}, v interface{}, path string, pos token.Position) []*Error {
+	var best []*Error
+	for _, b := range branches {
+		if !c.admits(b, v) {
+			continue
+		}
+		errs := c.check(b, v, path, pos)
+		if len(errs) == 0 {
+			return nil
+		}
+		if best == nil || len(errs) < len(best) {
+			best = errs
+		}
+	}
+	if best != nil {
+		return best
+	}
+
+	if obj, ok := v.(map[string]interface{}); ok && (obj["kind"] != nil || obj["type"] != nil) {
+		return c.errorf(path, pos, "unexpected node (kind %s, type %s)", encode(obj["kind"]), encode(obj["type"]))
+	}
+	return c.errorf(path, pos, "unexpected %s", jsonType(v))
+}
+
+// Reports whether v could be an instance of schema, judging only by
+// its JSON type and keys: an object must have at least one of the
+// keys the schema describes, and agree with it on the constant ones.
+func (c *checker) admits(schema interface{}, v interface{}) bool {
+	s := c.resolve(schema)
+	if s == nil {
+		return true
+	}
+	if branches, ok := s["anyOf"].([]interface{}); ok {
+		for _, b := range branches {
+			if c.admits(b, v) {
+				return true
+			}
+		}
+		return false
+	}
+	if t, ok := s["type"]; ok && !hasType(t, v) {
+		return false
+	}
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return true
+	}
+	props, _ := s["properties"].(map[string]interface{})
+	shared := false
+	for k, p := range props {
+		val, present := obj[k]
+		if !present {
+			continue
+		}
+		shared = true
+		if want, ok := c.resolve(p)["const"]; ok && !reflect.DeepEqual(want, val) {
+			return false
+		}
+	}
+	return shared || len(props) == 0 || len(obj) == 0
+}
+
+func jsonType(v interface{}) string {
+	switch n := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if n == float64(int64(n)) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func hasType(t interface{}, v interface{}) bool {
+	switch want := t.(type) {
+	case string:
+		got := jsonType(v)
+		return got == want || (want == "number" && got == "integer")
+	case []interface{}:
+		for _, w := range want {
+			if hasType(w, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func describeType(t interface{}) string {
+	if list, ok := t.([]interface{}); ok {
+		names := make([]string, len(list))
+		for i, n := range list {
+			names[i] = fmt.Sprint(n)
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(t)
+}
+
+func contains(list []interface{}, v interface{}) bool {
+	for _, x := range list {
+		if reflect.DeepEqual(x, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func encode(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Escapes keys for use in a JSON pointer (RFC 6901).
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
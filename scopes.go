@@ -0,0 +1,174 @@
+package goblin
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"path/filepath"
+)
+
+// Lexical scopes, from types.Info.Scopes. Each package is dumped with
+// a flat list of its scopes (the package scope, then each file's scope
+// and the scopes nested in it, in source order), and the universe is
+// dumped once in Load's result. Scopes refer to their parent by ID, and
+// nodes that open a scope (files, functions, blocks, if, for, switch
+// and the like, and clauses) refer to it by ID as well.
+//
+// Scope IDs follow ObjectID: "universe", the package's path for the
+// package scope, "path@file.go" for a file scope, and
+// "path@file.go:line:col" (where the node opening it starts) for any
+// other.
+
+// The kind of scope a node opens, named like the node.
+func scopeType(n ast.Node) string {
+	switch n.(type) {
+	case *ast.File:
+		return "file"
+	case *ast.FuncType:
+		return "function"
+	case *ast.TypeSpec:
+		return "type-params"
+	case *ast.BlockStmt:
+		return "block"
+	case *ast.IfStmt:
+		return "if"
+	case *ast.ForStmt:
+		return "for"
+	case *ast.RangeStmt:
+		return "range"
+	case *ast.SwitchStmt:
+		return "switch"
+	case *ast.TypeSwitchStmt:
+		return "type-switch"
+	case *ast.CaseClause:
+		return "case-clause"
+	case *ast.CommClause:
+		return "select-clause"
+	}
+	return "unknown"
+}
+
+// The package scope s is (or is nested in).
+func packageScope(s *types.Scope) *types.Scope {
+	for s.Parent() != nil && s.Parent() != types.Universe {
+		s = s.Parent()
+	}
+	return s
+}
+
+// The package of a package scope, if it can be told from its objects
+// (or, failing that, the imports of its files).
+func scopePackage(s *types.Scope) *types.Package {
+	if names := s.Names(); len(names) > 0 {
+		return s.Lookup(names[0]).Pkg()
+	}
+	for i := 0; i < s.NumChildren(); i++ {
+		if file := s.Child(i); file.Len() > 0 {
+			return file.Lookup(file.Names()[0]).Pkg()
+		}
+	}
+	return nil
+}
+
+func (d *Dumper) scopeID(s *types.Scope) string {
+	if s == types.Universe {
+		return "universe"
+	}
+	prefix := ""
+	if pkg := d.Types.scopePackage(packageScope(s)); pkg != nil {
+		prefix = packageQualifier(pkg)
+	}
+	if s.Parent() == types.Universe {
+		return prefix
+	}
+	pos := d.Fset.Position(s.Pos())
+	if s.Parent().Parent() == types.Universe {
+		return fmt.Sprintf("%s@%s", prefix, filepath.Base(pos.Filename))
+	}
+	return fmt.Sprintf("%s@%s:%d:%d", prefix, filepath.Base(pos.Filename), pos.Line, pos.Column)
+}
+
+// Add a reference to the scope n opens (if there's type information
+// about it) to its dump.
+func (d *Dumper) linkScope(res map[string]interface{}, n ast.Node) map[string]interface{} {
+	if d.Info != nil {
+		if s := d.Info.Scopes[n]; s != nil {
+			res["scope"] = d.scopeID(s)
+		}
+	}
+	return res
+}
+
+func (d *Dumper) dumpScope(s *types.Scope, typ string) map[string]interface{} {
+	objects := make([]string, len(s.Names()))
+	for i, name := range s.Names() {
+		objects[i] = objectID(s.Lookup(name), d.Fset, d.Types.fieldOwner)
+	}
+
+	var parent interface{}
+	if s.Parent() != nil {
+		parent = d.scopeID(s.Parent())
+	}
+
+	return map[string]interface{}{
+		"kind":    "scope",
+		"type":    typ,
+		"id":      d.scopeID(s),
+		"parent":  parent,
+		"start":   d.dumpScopePosition(s.Pos()),
+		"end":     d.dumpScopePosition(s.End()),
+		"objects": objects,
+	}
+}
+
+// The universe and package scopes have no position.
+func (d *Dumper) dumpScopePosition(pos token.Pos) interface{} {
+	if !pos.IsValid() {
+		return nil
+	}
+	return d.dumpPos(pos)
+}
+
+// Dump the universe scope, the parent of every package scope.
+func (d *Dumper) DumpUniverse() (res map[string]interface{}, err error) {
+	defer catch(&err)
+	return d.dumpScope(types.Universe, "universe"), nil
+}
+
+// Dump the scopes of the package that files belong to (see
+// DumpPackage).
+func (d *Dumper) DumpScopes(files []*ast.File) (res []map[string]interface{}, err error) {
+	defer catch(&err)
+	return d.dumpScopes(files), nil
+}
+
+func (d *Dumper) dumpScopes(files []*ast.File) []map[string]interface{} {
+	scopes := []map[string]interface{}{}
+	if d.Info == nil || d.Info.Scopes == nil {
+		return scopes
+	}
+	kinds := map[*types.Scope]string{}
+	for n, s := range d.Info.Scopes {
+		kinds[s] = scopeType(n)
+	}
+
+	var walk func(s *types.Scope)
+	walk = func(s *types.Scope) {
+		scopes = append(scopes, d.dumpScope(s, kinds[s]))
+		for i := 0; i < s.NumChildren(); i++ {
+			walk(s.Child(i))
+		}
+	}
+	for _, f := range files {
+		s := d.Info.Scopes[f]
+		if s == nil {
+			continue
+		}
+		if len(scopes) == 0 {
+			scopes = append(scopes, d.dumpScope(s.Parent(), "package"))
+		}
+		walk(s)
+	}
+	return scopes
+}
@@ -0,0 +1,290 @@
+package goblin
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Dumps can be written as s-expressions, for Lisp and ML family tools.
+// Every object is a form
+//
+//	(kind type :key value ...)
+//
+// with its kind and type as symbols (or _ if it has none) and the other
+// keys in sorted order. Keys, kinds and types that aren't plain symbols
+// are written as strings instead (a kind or type then goes with the
+// other keys). Lists are (value ...), strings are double-quoted with
+// only \ and " escaped, numbers are as in JSON, and true, false and
+// null are true, false and nil. Since no other value is a bare symbol,
+// a form can be told from a list by its first element.
+
+// Write the start of a form for an object with the given keys, and
+// return the keys that aren't in its head.
+func (s *stream) sexpHead(fields map[string]interface{}, keys []string) []string {
+	s.write('(')
+	heads := map[string]bool{}
+	for i, k := range []string{"kind", "type"} {
+		if i > 0 {
+			s.write(' ')
+		}
+		if str, ok := fields[k].(string); ok && isSexpSymbol(str) {
+			s.write([]byte(str)...)
+			heads[k] = true
+		} else {
+			s.write('_')
+		}
+	}
+	rest := keys[:0:0]
+	for _, k := range keys {
+		if !heads[k] {
+			rest = append(rest, k)
+		}
+	}
+	return rest
+}
+
+func (s *stream) sexpKey(k string) {
+	s.write(' ')
+	if isSexpSymbol(k) {
+		s.write(':')
+		s.write([]byte(k)...)
+	} else {
+		s.sexpString(k)
+	}
+	s.write(' ')
+}
+
+func (s *stream) sexpString(str string) {
+	str = toValidUTF8(str)
+	s.write('"')
+	for i := 0; i < len(str); i++ {
+		if str[i] == '"' || str[i] == '\\' {
+			s.write('\\')
+		}
+		s.write(str[i])
+	}
+	s.write('"')
+}
+
+// Write v as encoding/json would see it.
+func (s *stream) sexpValue(v interface{}) {
+	switch x := v.(type) {
+	case nil:
+		s.write([]byte("nil")...)
+	case string:
+		s.sexpString(x)
+	case bool:
+		s.write([]byte(strconv.FormatBool(x))...)
+	case int:
+		s.write([]byte(strconv.Itoa(x))...)
+	case json.Number:
+		s.write([]byte(x)...)
+	case map[string]interface{}:
+		if x == nil {
+			s.sexpValue(nil)
+		} else {
+			s.object(x, nil)
+		}
+	case []interface{}:
+		if x == nil {
+			s.sexpValue(nil)
+		} else {
+			s.array(len(x), func(i int) { s.sexpValue(x[i]) })
+		}
+	case []map[string]interface{}:
+		if x == nil {
+			s.sexpValue(nil)
+		} else {
+			s.array(len(x), func(i int) { s.sexpValue(x[i]) })
+		}
+	case []string:
+		if x == nil {
+			s.sexpValue(nil)
+		} else {
+			s.array(len(x), func(i int) { s.sexpString(x[i]) })
+		}
+	default:
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8 {
+			if rv.IsNil() {
+				s.sexpValue(nil)
+			} else {
+				s.array(rv.Len(), func(i int) { s.sexpValue(rv.Index(i).Interface()) })
+			}
+			return
+		}
+		// Numbers, structs and the like.
+		s.sexpValue(jsonValue(v))
+	}
+}
+
+// Reports whether str can be written as a bare symbol (or keyword)
+// without being mistaken for anything else.
+func isSexpSymbol(str string) bool {
+	switch str {
+	case "", "_", "nil", "true", "false", ".":
+		return false
+	}
+	if _, ok := sexpNumber(str); ok || str[0] >= '0' && str[0] <= '9' {
+		return false
+	}
+	for _, c := range str {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.ContainsRune("-_.+*/<>=!?&%~^$@", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+type sexpReader struct {
+	r *bufio.Reader
+}
+
+func (d *sexpReader) failf(format string, args ...interface{}) {
+	fail(INVALID_POSITION, "decode_error", fmt.Sprintf(format, args...))
+}
+
+// The next byte that isn't white space.
+func (d *sexpReader) peek() byte {
+	for {
+		c, err := d.r.ReadByte()
+		if err != nil {
+			d.failf("%s", unexpectedEOF(err))
+		}
+		switch c {
+		case ' ', '\t', '\n', '\r':
+			continue
+		}
+		d.r.UnreadByte()
+		return c
+	}
+}
+
+// An atom: a symbol, keyword or number.
+func (d *sexpReader) atom() string {
+	var b strings.Builder
+	for {
+		c, err := d.r.ReadByte()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			d.failf("%s", err)
+		}
+		if strings.IndexByte(" \t\n\r()\"", c) >= 0 {
+			d.r.UnreadByte()
+			break
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+func (d *sexpReader) str() string {
+	d.r.ReadByte() // "
+	var b strings.Builder
+	for {
+		c, err := d.r.ReadByte()
+		if err != nil {
+			d.failf("unterminated string: %s", unexpectedEOF(err))
+		}
+		switch c {
+		case '"':
+			return b.String()
+		case '\\':
+			if c, err = d.r.ReadByte(); err != nil {
+				d.failf("unterminated string: %s", unexpectedEOF(err))
+			}
+		}
+		b.WriteByte(c)
+	}
+}
+
+// An element of a list: a value, or (with the second result) a bare
+// symbol or keyword.
+func (d *sexpReader) element() (interface{}, string) {
+	switch d.peek() {
+	case '(':
+		return d.list(), ""
+	case '"':
+		return d.str(), ""
+	case ')':
+		d.failf("unexpected )")
+	}
+	a := d.atom()
+	switch a {
+	case "nil":
+		return nil, ""
+	case "true", "false":
+		return a == "true", ""
+	}
+	if f, ok := sexpNumber(a); ok {
+		return f, ""
+	}
+	return nil, a
+}
+
+// Atoms starting with a digit or minus sign are numbers, if they can
+// be.
+func sexpNumber(a string) (float64, bool) {
+	if c := a[0]; c == '-' || c >= '0' && c <= '9' {
+		f, err := strconv.ParseFloat(a, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func (d *sexpReader) value() interface{} {
+	v, sym := d.element()
+	if sym != "" {
+		d.failf("unexpected symbol %s", sym)
+	}
+	return v
+}
+
+func (d *sexpReader) list() interface{} {
+	d.r.ReadByte() // (
+	if d.peek() == ')' {
+		d.r.ReadByte()
+		return []interface{}{}
+	}
+	first, sym := d.element()
+	if sym == "" {
+		list := []interface{}{first}
+		for d.peek() != ')' {
+			list = append(list, d.value())
+		}
+		d.r.ReadByte()
+		return list
+	}
+
+	// A form: the kind and type, then keys and values.
+	obj := map[string]interface{}{}
+	_, typ := d.element()
+	if typ == "" {
+		d.failf("expected the type of a form after %s", sym)
+	}
+	for k, head := range map[string]string{"kind": sym, "type": typ} {
+		if head != "_" {
+			obj[k] = head
+		}
+	}
+	for d.peek() != ')' {
+		key, sym := d.element()
+		if str, ok := key.(string); ok {
+			sym = ":" + str
+		}
+		if !strings.HasPrefix(sym, ":") {
+			d.failf("expected a key, got %v", key)
+		}
+		obj[sym[1:]] = d.value()
+	}
+	d.r.ReadByte()
+	return obj
+}
@@ -0,0 +1,246 @@
+package goblin
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"io"
+	"sort"
+)
+
+// Dumps of whole programs are large, so besides building them as maps
+// they can be written out while they are being made: lists of
+// packages, files and declarations are dumped and written one element
+// at a time, and only the element being written is held in memory
+// (along with the table of named types). Streaming stops at
+// declarations: each one is dumped as a map and then written, so a
+// single large declaration takes as much memory as it does with
+// DumpFile.
+//
+// JSON output is byte for byte what encoding/json gives for the map,
+// which means object keys are written in sorted order. The binary
+// formats (see binary.go and proto.go) and s-expressions (sexp.go)
+// encode the same values, in the same order.
+
+// Like DumpFile, but write the file to w in d.Format one declaration at
+// a time.
+func (d *Dumper) EncodeFile(w io.Writer, f *ast.File, path string) (err error) {
+	s := newStream(w, d.Format)
+	defer s.flush(&err)
+	defer catch(&err)
+	s.top("file")
+	d.encodeFile(s, f, path)
+	return nil
+}
+
+func (d *Dumper) encodeFile(s *stream, f *ast.File, path string) {
+	d = d.forFile(f)
+	imports := importDecls(f)
+	s.object(d.dumpFileHeader(f, path), map[string]func(){
+		"declarations": func() {
+			s.array(len(f.Decls), func(i int) {
+				s.value(d.dumpDecl(f.Decls[i]))
+			})
+		},
+		"imports": func() {
+			s.array(len(imports), func(i int) {
+				s.value(d.dumpDecl(imports[i]))
+			})
+		},
+	})
+}
+
+// Write a dump (or any value that encoding/json accepts) to w in the
+// given format.
+func Encode(w io.Writer, v interface{}, format Format) (err error) {
+	s := newStream(w, format)
+	defer s.flush(&err)
+	defer catch(&err)
+	if format == Proto {
+		s.message = protoMessageOf(v)
+	}
+	s.value(v)
+	return nil
+}
+
+type stream struct {
+	w      *bufio.Writer
+	format Format
+
+	// For Proto, the message being written at the top, and the field
+	// that values are being written to (nil at the top).
+	message *protoMessage
+	field   *protoField
+}
+
+func newStream(w io.Writer, format Format) *stream {
+	return &stream{w: bufio.NewWriter(w), format: format}
+}
+
+// Say what the stream holds, as the name of a schema definition.
+func (s *stream) top(definition string) {
+	if s.format == Proto {
+		s.message = protoMessageFor(definition)
+	}
+}
+
+// Write errors bail out of the dump like any other error.
+func (s *stream) write(data ...byte) {
+	if _, err := s.w.Write(data); err != nil {
+		fail(INVALID_POSITION, "write_error", err.Error())
+	}
+}
+
+// Write out whatever is buffered, unless there was an error already.
+func (s *stream) flush(err *error) {
+	if *err != nil {
+		return
+	}
+	if e := s.w.Flush(); e != nil {
+		*err = &Error{Type: "write_error", Reason: e.Error(), Position: INVALID_POSITION}
+	}
+}
+
+func (s *stream) value(v interface{}) {
+	switch s.format {
+	case JSON:
+		data, err := json.Marshal(v)
+		if err != nil {
+			fail(INVALID_POSITION, "json_error", err.Error())
+		}
+		s.write(data...)
+	case Sexp:
+		s.sexpValue(v)
+	case Proto:
+		if s.field == nil {
+			s.write(s.message.appendBody(nil, v)...)
+		} else {
+			s.write(s.field.append(nil, v)...)
+		}
+	default:
+		s.binaryValue(v)
+	}
+}
+
+// Write an object with the keys of both fields and streamed, in sorted
+// order. The values of streamed keys are written by the corresponding
+// function when their turn comes.
+func (s *stream) object(fields map[string]interface{}, streamed map[string]func()) {
+	keys := make([]string, 0, len(fields)+len(streamed))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	for k := range streamed {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	if s.format == Proto {
+		s.protoObject(keys, fields, streamed)
+		return
+	}
+	if s.format == Sexp {
+		for _, k := range s.sexpHead(fields, keys) {
+			s.sexpKey(k)
+			if write, ok := streamed[k]; ok {
+				write()
+			} else {
+				s.value(fields[k])
+			}
+		}
+		s.write(')')
+		return
+	}
+	if s.format != JSON {
+		s.binaryHeader(mapHeader, uint64(len(keys)))
+	} else {
+		s.write('{')
+	}
+	for i, k := range keys {
+		if s.format == JSON && i > 0 {
+			s.write(',')
+		}
+		s.value(k)
+		if s.format == JSON {
+			s.write(':')
+		}
+		if write, ok := streamed[k]; ok {
+			write()
+		} else {
+			s.value(fields[k])
+		}
+	}
+	if s.format == JSON {
+		s.write('}')
+	}
+}
+
+// Write an array of n elements, each written by item.
+func (s *stream) array(n int, item func(i int)) {
+	if s.format == Proto {
+		// Each element is written to the repeated field in turn.
+		f := s.field
+		e := *f
+		e.repeated, e.element = false, true
+		s.field = &e
+		for i := 0; i < n; i++ {
+			item(i)
+		}
+		s.field = f
+		return
+	}
+	open, sep, close := byte('['), byte(','), byte(']')
+	if s.format == Sexp {
+		open, sep, close = '(', ' ', ')'
+	} else if s.format != JSON {
+		s.binaryHeader(arrayHeader, uint64(n))
+	}
+	isText := s.format == JSON || s.format == Sexp
+	if isText {
+		s.write(open)
+	}
+	for i := 0; i < n; i++ {
+		if isText && i > 0 {
+			s.write(sep)
+		}
+		item(i)
+	}
+	if isText {
+		s.write(close)
+	}
+}
+
+// A message has to be preceded by its length, so unless it is at the
+// top it is held in memory until it is complete.
+func (s *stream) protoObject(keys []string, fields map[string]interface{}, streamed map[string]func()) {
+	m, f, w := s.message, s.field, s.w
+	var buf bytes.Buffer
+	if f != nil {
+		m = f.msg
+		s.w = bufio.NewWriter(&buf)
+	}
+	for _, k := range keys {
+		s.field = m.byKey[k]
+		if s.field == nil {
+			if m.consts[k] == nil {
+				fail(INVALID_POSITION, "encode_error", fmt.Sprintf("no field in %s for key %q", m.name, k))
+			}
+			continue
+		}
+		if write, ok := streamed[k]; ok {
+			write()
+		} else {
+			s.value(fields[k])
+		}
+	}
+	s.field = f
+	if f != nil {
+		if err := s.w.Flush(); err != nil {
+			fail(INVALID_POSITION, "write_error", err.Error())
+		}
+		s.w = w
+		s.write(appendProtoBytes(nil, f.number, buf.Bytes())...)
+	}
+}